@@ -0,0 +1,159 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAckShortForms(t *testing.T) {
+	tests := []struct {
+		packetType PacketType
+		flags      byte
+		// prefix is the length of the fields before the reason code
+		prefix int
+		codes  []byte
+		packet func(code byte, props *Properties) Packet
+	}{
+		{
+			packetType: PUBACK,
+			prefix:     2,
+			codes: []byte{PubackSuccess, PubackNoMatchingSubscribers, PubackUnspecifiedError,
+				PubackImplementationSpecificError, PubackNotAuthorized, PubackTopicNameInvalid,
+				PubackPacketIdentifierInUse, PubackQuotaExceeded, PubackPayloadFormatInvalid},
+			packet: func(code byte, props *Properties) Packet {
+				return &Puback{PacketID: 10, ReasonCode: code, Properties: props}
+			},
+		},
+		{
+			packetType: PUBREC,
+			prefix:     2,
+			codes: []byte{PubrecSuccess, PubrecNoMatchingSubscribers, PubrecUnspecifiedError,
+				PubrecImplementationSpecificError, PubrecNotAuthorized, PubrecTopicNameInvalid,
+				PubrecPacketIdentifierInUse, PubrecQuotaExceeded, PubrecPayloadFormatInvalid},
+			packet: func(code byte, props *Properties) Packet {
+				return &Pubrec{PacketID: 10, ReasonCode: code, Properties: props}
+			},
+		},
+		{
+			packetType: PUBREL,
+			flags:      2,
+			prefix:     2,
+			codes:      []byte{PubrelSuccess, PubrelPacketIdentifierNotFound},
+			packet: func(code byte, props *Properties) Packet {
+				return &Pubrel{PacketID: 10, ReasonCode: code, Properties: props}
+			},
+		},
+		{
+			packetType: PUBCOMP,
+			prefix:     2,
+			codes:      []byte{PubcompSuccess, PubcompPacketIdentifierNotFound},
+			packet: func(code byte, props *Properties) Packet {
+				return &Pubcomp{PacketID: 10, ReasonCode: code, Properties: props}
+			},
+		},
+		{
+			packetType: DISCONNECT,
+			codes: []byte{DisconnectNormalDisconnection, DisconnectDisconnectWithWillMessage,
+				DisconnectUnspecifiedError, DisconnectMalformedPacket, DisconnectProtocolError,
+				DisconnectImplementationSpecificError, DisconnectNotAuthorized, DisconnectServerBusy,
+				DisconnectServerShuttingDown, DisconnectKeepAliveTimeout, DisconnectSessionTakenOver,
+				DisconnectTopicFilterInvalid, DisconnectTopicNameInvalid, DisconnectReceiveMaximumExceeded,
+				DisconnectTopicAliasInvalid, DisconnectPacketTooLarge, DisconnectMessageRateTooHigh,
+				DisconnectQuotaExceeded, DisconnectAdministrativeAction, DisconnectPayloadFormatInvalid,
+				DisconnectRetainNotSupported, DisconnectQoSNotSupported, DisconnectUseAnotherServer,
+				DisconnectServerMoved, DisconnectSharedSubscriptionNotSupported,
+				DisconnectConnectionRateExceeded, DisconnectMaximumConnectTime,
+				DisconnectSubscriptionIdentifiersNotSupported, DisconnectWildcardSubscriptionsNotSupported},
+			packet: func(code byte, props *Properties) Packet {
+				return &Disconnect{ReasonCode: code, Properties: props}
+			},
+		},
+		{
+			packetType: AUTH,
+			codes:      []byte{AuthSuccess, AuthContinueAuthentication, AuthReauthenticate},
+			packet: func(code byte, props *Properties) Packet {
+				return &Auth{ReasonCode: code, Properties: props}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, code := range tt.codes {
+			for _, withProps := range []bool{false, true} {
+				props := &Properties{}
+				if withProps {
+					props.User = []User{{Key: "k", Value: "v"}}
+				}
+				name := fmt.Sprintf("%s 0x%02X props=%t", packetTypeName(tt.packetType), code, withProps)
+
+				t.Run(name, func(t *testing.T) {
+					cp := &ControlPacket{FixedHeader: FixedHeader{Type: tt.packetType, Flags: tt.flags}}
+					cp.Content = tt.packet(code, props)
+
+					var b bytes.Buffer
+					_, err := cp.WriteTo(&b)
+					require.NoError(t, err)
+
+					want := tt.prefix
+					switch {
+					case withProps:
+						// reason code, property length and one user property
+						want += 2 + 7
+					case code != 0:
+						want++
+					}
+					assert.Equal(t, want, int(b.Bytes()[1]))
+
+					decoded, err := ReadPacket(&b, MQTTv5)
+					require.NoError(t, err)
+					assert.Equal(t, cp.Content, decoded.Content)
+				})
+			}
+		}
+	}
+}
+
+func TestAckDecodeLengths(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		want   Packet
+	}{
+		{name: "pubrec success", packet: []byte{0x50, 2, 0, 1},
+			want: &Pubrec{PacketID: 1, Properties: &Properties{}}},
+		{name: "pubrec reason", packet: []byte{0x50, 3, 0, 1, 0x10},
+			want: &Pubrec{PacketID: 1, ReasonCode: 0x10, Properties: &Properties{}}},
+		{name: "pubrec empty properties", packet: []byte{0x50, 4, 0, 1, 0x10, 0},
+			want: &Pubrec{PacketID: 1, ReasonCode: 0x10, Properties: &Properties{}}},
+		{name: "pubrec reason string", packet: []byte{0x50, 8, 0, 1, 0x10, 4, 0x1F, 0, 1, 'r'},
+			want: &Pubrec{PacketID: 1, ReasonCode: 0x10, Properties: &Properties{ReasonString: "r"}}},
+		{name: "pubcomp reason string", packet: []byte{0x70, 8, 0, 1, 0x92, 4, 0x1F, 0, 1, 'r'},
+			want: &Pubcomp{PacketID: 1, ReasonCode: 0x92, Properties: &Properties{ReasonString: "r"}}},
+		{name: "disconnect success", packet: []byte{0xE0, 0},
+			want: &Disconnect{Properties: &Properties{}}},
+		{name: "disconnect reason", packet: []byte{0xE0, 1, 0x04},
+			want: &Disconnect{ReasonCode: 0x04, Properties: &Properties{}}},
+		{name: "auth empty properties", packet: []byte{0xF0, 2, 0x18, 0},
+			want: &Auth{ReasonCode: 0x18, Properties: &Properties{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, n, err := DecodePacket(tt.packet, MQTTv5)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.packet), n)
+			assert.Equal(t, tt.want, cp.Content)
+		})
+	}
+}
+
+func TestAuthUnpackWithoutProperties(t *testing.T) {
+	a := &Auth{}
+	require.NoError(t, a.Unpack(bytes.NewBuffer([]byte{0x18, 3, 0x15, 0, 0})))
+	assert.Equal(t, byte(0x18), a.ReasonCode)
+	assert.Equal(t, &Properties{}, a.Properties)
+}
@@ -8,8 +8,11 @@ import (
 
 // Auth is the Variable Header definition for a Auth control packet
 type Auth struct {
-	Properties *Properties
-	ReasonCode byte
+	Properties *Properties `json:"properties,omitempty"`
+	ReasonCode byte        `json:"reasonCode,omitempty"`
+
+	// limits is handed to properties created while unpacking
+	limits *decodeLimits
 }
 
 // AuthSuccess is the return code for successful authentication
@@ -21,36 +24,56 @@ const (
 
 // Unpack is the implementation of the interface required function for a packet
 func (a *Auth) Unpack(r *bytes.Buffer) error {
-	var err error
-
-	success := r.Len() == 0
-	noProps := r.Len() == 1
-	if !success {
-		a.ReasonCode, err = r.ReadByte()
-		if err != nil {
-			return err
-		}
+	// a success without properties can be sent without any content
+	if r.Len() == 0 {
+		return nil
+	}
+	if a.Properties == nil {
+		a.Properties = &Properties{limits: a.limits}
+	}
 
-		if !noProps {
-			err = a.Properties.Unpack(r, AUTH)
-			if err != nil {
-				return err
-			}
-		}
+	a.ReasonCode, _ = r.ReadByte()
+	if r.Len() == 0 {
+		return nil
 	}
 
-	return nil
+	return a.Properties.Unpack(r, AUTH)
 }
 
 // Buffers is the implementation of the interface required function for a packet
 func (a *Auth) Buffers() net.Buffers {
 	idvp := a.Properties.Pack(AUTH)
-	propLen := encodeVBI(len(idvp))
-	n := net.Buffers{[]byte{a.ReasonCode}, propLen}
-	if len(idvp) > 0 {
-		n = append(n, idvp)
+	if len(idvp) == 0 {
+		if a.ReasonCode == AuthSuccess {
+			return net.Buffers{}
+		}
+		return net.Buffers{[]byte{a.ReasonCode}}
+	}
+
+	return net.Buffers{[]byte{a.ReasonCode}, encodeVBI(len(idvp)), idvp}
+}
+
+func (a *Auth) size() int {
+	idvp := a.Properties.packedLen(AUTH)
+	if idvp == 0 {
+		if a.ReasonCode == AuthSuccess {
+			return 0
+		}
+		return 1
+	}
+
+	return 1 + vbiLen(idvp) + idvp
+}
+
+func (a *Auth) appendTo(dst []byte) []byte {
+	switch a.size() {
+	case 0:
+		return dst
+	case 1:
+		return append(dst, a.ReasonCode)
 	}
-	return n
+
+	return a.Properties.appendPrefixed(append(dst, a.ReasonCode), AUTH)
 }
 
 // WriteTo is the implementation of the interface required function for a packet
@@ -0,0 +1,355 @@
+package mqttpackets
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrTrailingData is returned by UnmarshalBinary when the data continues
+// after the packet
+var ErrTrailingData = errors.New("data continues after the packet")
+
+// MarshalBinary implements encoding.BinaryMarshaler. The packet is encoded
+// like Encode does, prefixed with one byte holding the protocol version, so
+// UnmarshalBinary can decode it without knowing the version of the
+// connection. The version of CONNECT packets is their ProtocolVersion, AUTH
+// packets and packets with properties are MQTTv5 and other packets MQTTv311,
+// which encodes them like MQTTv31 does.
+func (c *ControlPacket) MarshalBinary() ([]byte, error) {
+	v := packetVersion(c)
+	if err := checkVersion(v); err != nil {
+		return nil, &EncodeError{PacketType: c.Type, Field: "ProtocolVersion", Err: err}
+	}
+
+	dst := make([]byte, 1, 1+c.Size())
+	dst[0] = byte(v)
+	data, err := c.AppendTo(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, it decodes data
+// produced by MarshalBinary and replaces c with the decoded packet. data is
+// copied, so the packet doesn't alias it.
+func (c *ControlPacket) UnmarshalBinary(data []byte) error {
+	cp, err := unmarshalPacket(data)
+	if err != nil {
+		return err
+	}
+
+	*c = *cp
+	return nil
+}
+
+// packetVersion returns the protocol version MarshalBinary records for cp
+func packetVersion(cp *ControlPacket) Version {
+	switch p := cp.Content.(type) {
+	case *Connect:
+		return p.ProtocolVersion
+	case *Auth:
+		return MQTTv5
+	}
+	if len(packetProperties(cp)) > 0 {
+		return MQTTv5
+	}
+
+	return MQTTv311
+}
+
+func checkVersion(v Version) error {
+	if v != MQTTv31 && v != MQTTv311 && v != MQTTv5 {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+	return nil
+}
+
+func unmarshalPacket(data []byte) (*ControlPacket, error) {
+	if len(data) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	v := Version(data[0])
+	if err := checkVersion(v); err != nil {
+		return nil, err
+	}
+
+	frame := append([]byte(nil), data[1:]...)
+	cp, n, err := DecodePacket(frame, v)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(frame) {
+		return nil, ErrTrailingData
+	}
+
+	return cp, nil
+}
+
+// marshalContent marshals content as the packet of type t, with the fixed
+// header flags required for t
+func marshalContent(t PacketType, content Packet) ([]byte, error) {
+	cp := &ControlPacket{FixedHeader: FixedHeader{Type: t}, Content: content}
+	switch t {
+	case PUBREL, SUBSCRIBE, UNSUBSCRIBE:
+		cp.Flags = 2
+	}
+
+	return cp.MarshalBinary()
+}
+
+// unmarshalContent unmarshals data and returns the content of the packet,
+// which has to be of type t
+func unmarshalContent(t PacketType, data []byte) (Packet, error) {
+	cp, err := unmarshalPacket(data)
+	if err != nil {
+		return nil, err
+	}
+	if cp.Type != t {
+		return nil, fmt.Errorf("%w: got %s, expected %s", ErrInvalidPacketType, cp.Type, t)
+	}
+
+	return cp.Content, nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (c *Connect) MarshalBinary() ([]byte, error) {
+	return marshalContent(CONNECT, c)
+}
+
+// UnmarshalBinary decodes a CONNECT packet encoded by MarshalBinary
+func (c *Connect) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(CONNECT, data)
+	if err != nil {
+		return err
+	}
+
+	*c = *content.(*Connect)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (c *Connack) MarshalBinary() ([]byte, error) {
+	return marshalContent(CONNACK, c)
+}
+
+// UnmarshalBinary decodes a CONNACK packet encoded by MarshalBinary
+func (c *Connack) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(CONNACK, data)
+	if err != nil {
+		return err
+	}
+
+	*c = *content.(*Connack)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (p *Publish) MarshalBinary() ([]byte, error) {
+	return marshalContent(PUBLISH, p)
+}
+
+// UnmarshalBinary decodes a PUBLISH packet encoded by MarshalBinary
+func (p *Publish) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(PUBLISH, data)
+	if err != nil {
+		return err
+	}
+
+	*p = *content.(*Publish)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (p *Puback) MarshalBinary() ([]byte, error) {
+	return marshalContent(PUBACK, p)
+}
+
+// UnmarshalBinary decodes a PUBACK packet encoded by MarshalBinary
+func (p *Puback) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(PUBACK, data)
+	if err != nil {
+		return err
+	}
+
+	*p = *content.(*Puback)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (p *Pubrec) MarshalBinary() ([]byte, error) {
+	return marshalContent(PUBREC, p)
+}
+
+// UnmarshalBinary decodes a PUBREC packet encoded by MarshalBinary
+func (p *Pubrec) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(PUBREC, data)
+	if err != nil {
+		return err
+	}
+
+	*p = *content.(*Pubrec)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (p *Pubrel) MarshalBinary() ([]byte, error) {
+	return marshalContent(PUBREL, p)
+}
+
+// UnmarshalBinary decodes a PUBREL packet encoded by MarshalBinary
+func (p *Pubrel) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(PUBREL, data)
+	if err != nil {
+		return err
+	}
+
+	*p = *content.(*Pubrel)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (p *Pubcomp) MarshalBinary() ([]byte, error) {
+	return marshalContent(PUBCOMP, p)
+}
+
+// UnmarshalBinary decodes a PUBCOMP packet encoded by MarshalBinary
+func (p *Pubcomp) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(PUBCOMP, data)
+	if err != nil {
+		return err
+	}
+
+	*p = *content.(*Pubcomp)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (s *Subscribe) MarshalBinary() ([]byte, error) {
+	return marshalContent(SUBSCRIBE, s)
+}
+
+// UnmarshalBinary decodes a SUBSCRIBE packet encoded by MarshalBinary
+func (s *Subscribe) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(SUBSCRIBE, data)
+	if err != nil {
+		return err
+	}
+
+	*s = *content.(*Subscribe)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (s *Suback) MarshalBinary() ([]byte, error) {
+	return marshalContent(SUBACK, s)
+}
+
+// UnmarshalBinary decodes a SUBACK packet encoded by MarshalBinary
+func (s *Suback) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(SUBACK, data)
+	if err != nil {
+		return err
+	}
+
+	*s = *content.(*Suback)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (u *Unsubscribe) MarshalBinary() ([]byte, error) {
+	return marshalContent(UNSUBSCRIBE, u)
+}
+
+// UnmarshalBinary decodes an UNSUBSCRIBE packet encoded by MarshalBinary
+func (u *Unsubscribe) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(UNSUBSCRIBE, data)
+	if err != nil {
+		return err
+	}
+
+	*u = *content.(*Unsubscribe)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (u *Unsuback) MarshalBinary() ([]byte, error) {
+	return marshalContent(UNSUBACK, u)
+}
+
+// UnmarshalBinary decodes an UNSUBACK packet encoded by MarshalBinary
+func (u *Unsuback) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(UNSUBACK, data)
+	if err != nil {
+		return err
+	}
+
+	*u = *content.(*Unsuback)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (p *Pingreq) MarshalBinary() ([]byte, error) {
+	return marshalContent(PINGREQ, p)
+}
+
+// UnmarshalBinary decodes a PINGREQ packet encoded by MarshalBinary
+func (p *Pingreq) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(PINGREQ, data)
+	if err != nil {
+		return err
+	}
+
+	*p = *content.(*Pingreq)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (p *Pingresp) MarshalBinary() ([]byte, error) {
+	return marshalContent(PINGRESP, p)
+}
+
+// UnmarshalBinary decodes a PINGRESP packet encoded by MarshalBinary
+func (p *Pingresp) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(PINGRESP, data)
+	if err != nil {
+		return err
+	}
+
+	*p = *content.(*Pingresp)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (d *Disconnect) MarshalBinary() ([]byte, error) {
+	return marshalContent(DISCONNECT, d)
+}
+
+// UnmarshalBinary decodes a DISCONNECT packet encoded by MarshalBinary
+func (d *Disconnect) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(DISCONNECT, data)
+	if err != nil {
+		return err
+	}
+
+	*d = *content.(*Disconnect)
+	return nil
+}
+
+// MarshalBinary encodes the packet like ControlPacket.MarshalBinary does
+func (a *Auth) MarshalBinary() ([]byte, error) {
+	return marshalContent(AUTH, a)
+}
+
+// UnmarshalBinary decodes an AUTH packet encoded by MarshalBinary
+func (a *Auth) UnmarshalBinary(data []byte) error {
+	content, err := unmarshalContent(AUTH, data)
+	if err != nil {
+		return err
+	}
+
+	*a = *content.(*Auth)
+	return nil
+}
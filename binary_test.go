@@ -0,0 +1,132 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	type versioned struct {
+		cp *ControlPacket
+		v  Version
+	}
+	var packets []versioned
+	for _, cp := range benchmarkPackets() {
+		packets = append(packets, versioned{cp, MQTTv5})
+	}
+	for _, v := range []Version{MQTTv31, MQTTv311} {
+		for _, cp := range benchmarkPackets()[:AUTH-1] {
+			translated, err := Translate(cp, MQTTv5, v)
+			require.NoError(t, err)
+			packets = append(packets, versioned{translated, v})
+		}
+	}
+
+	for _, tt := range packets {
+		cp := tt.cp
+		data, err := cp.MarshalBinary()
+		require.NoError(t, err)
+
+		// the packet decodes as if its version was known
+		bufs, err := cp.Encode()
+		require.NoError(t, err)
+		want, _, err := DecodePacket(bytes.Join(bufs, nil), tt.v)
+		require.NoError(t, err)
+
+		var decoded ControlPacket
+		require.NoError(t, decoded.UnmarshalBinary(data))
+		assert.Equal(t, cp.Type, decoded.Type)
+		assert.Equal(t, want.Content, decoded.Content)
+		again, err := decoded.MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, data, again)
+
+		// the content types marshal to the same bytes
+		m, ok := cp.Content.(encoding.BinaryMarshaler)
+		require.True(t, ok, cp.PacketType())
+		content, err := m.MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, data, content)
+
+		u := NewControlPacket(cp.Type, MQTTv311).Content.(encoding.BinaryUnmarshaler)
+		require.NoError(t, u.UnmarshalBinary(data))
+		assert.Equal(t, want.Content, u)
+	}
+}
+
+func TestMarshalBinaryVersion(t *testing.T) {
+	cp := NewControlPacket(CONNECT, MQTTv31)
+	data, err := cp.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, byte(MQTTv31), data[0])
+
+	data, err = NewControlPacket(SUBSCRIBE, MQTTv5).MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, byte(MQTTv5), data[0])
+
+	// the subscription options are decoded as v5
+	s := &Subscribe{PacketID: 1, Properties: &Properties{}, Subscriptions: []Subscription{{Topic: "a", NoLocal: true}}}
+	data, err = s.MarshalBinary()
+	require.NoError(t, err)
+	var decoded Subscribe
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, s, &decoded)
+}
+
+func TestUnmarshalBinaryCopies(t *testing.T) {
+	p := &Publish{Topic: "a", Payload: []byte("payload")}
+	data, err := p.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded Publish
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	for i := range data {
+		data[i] = 0
+	}
+	assert.Equal(t, []byte("payload"), decoded.Payload)
+}
+
+func TestMarshalBinaryGob(t *testing.T) {
+	type entry struct {
+		Packet  *ControlPacket
+		Publish *Publish
+	}
+
+	cp := NewControlPacket(PUBLISH, MQTTv5)
+	p := cp.Content.(*Publish)
+	p.Topic = "a/b"
+	p.QoS = 1
+	p.PacketID = 7
+	p.Payload = []byte("payload")
+	p.Properties.User = []User{{Key: "k", Value: "v"}}
+	in := entry{Packet: cp, Publish: &Publish{Topic: "c", Payload: []byte("x")}}
+
+	var b bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&b).Encode(in))
+	var out entry
+	require.NoError(t, gob.NewDecoder(&b).Decode(&out))
+	assert.Equal(t, in.Packet.Content, out.Packet.Content)
+	assert.Equal(t, in.Publish, out.Publish)
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	var cp ControlPacket
+	assert.Equal(t, io.ErrUnexpectedEOF, cp.UnmarshalBinary(nil))
+	assert.True(t, errors.Is(cp.UnmarshalBinary([]byte{6, 0xC0, 0}), ErrUnsupportedVersion))
+	assert.Equal(t, ErrTrailingData, cp.UnmarshalBinary([]byte{4, 0xC0, 0, 0}))
+	assert.Equal(t, io.ErrUnexpectedEOF, cp.UnmarshalBinary([]byte{4, 0x30, 5, 0, 1}))
+
+	var p Publish
+	assert.True(t, errors.Is(p.UnmarshalBinary([]byte{4, 0xC0, 0}), ErrInvalidPacketType))
+
+	c := NewControlPacket(CONNECT, 0)
+	_, err := c.MarshalBinary()
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
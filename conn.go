@@ -0,0 +1,154 @@
+package mqttpackets
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Errors wrapped by the ProtocolError returned by Conn
+var (
+	ErrConnectExpected = errors.New("first packet of a connection must be CONNECT")
+	ErrSecondConnect   = errors.New("CONNECT was already sent on the connection")
+)
+
+// Conn reads and writes packets on a connection. It learns the protocol
+// version from the first CONNECT packet it reads or writes and uses it for
+// every later packet. Reading and writing may happen in different goroutines,
+// but only one goroutine may read and one may write at a time.
+type Conn struct {
+	// Options are the limits enforced on read packets, nil enforces none
+	Options *ReaderOptions
+
+	rw       io.ReadWriter
+	mu       sync.Mutex
+	version  Version
+	clientID string
+}
+
+// NewConn returns a Conn reading and writing packets on rw, which is usually
+// a net.Conn
+func NewConn(rw io.ReadWriter) *Conn {
+	return &Conn{rw: rw}
+}
+
+// ReadPacket reads the next packet from the connection. The first packet has
+// to be CONNECT, a second CONNECT is rejected with a *ProtocolError after it
+// has been read.
+func (c *Conn) ReadPacket() (*ControlPacket, error) {
+	header, headerLen, err := readFixedHeader(c.rw, 0, c.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	// the version is taken once the fixed header arrived, so a packet
+	// answering a CONNECT written while waiting uses its version
+	v := c.Version()
+	cp, err := newPacketFromHeader(byte(header.Type)<<4|header.Flags, v)
+	if err != nil {
+		return nil, err
+	}
+	cp.remainingLength = header.remainingLength
+	if cp, err = readContent(c.rw, cp, headerLen, v, c.Options); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = c.check(cp); err != nil {
+		return nil, err
+	}
+	c.record(cp)
+
+	return cp, nil
+}
+
+// WritePacket writes cp to the connection. The first packet has to be
+// CONNECT and a second CONNECT is rejected with a *ProtocolError, nothing
+// is written in both cases. The state of the connection only changes once
+// the packet was written.
+func (c *Conn) WritePacket(cp *ControlPacket) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(cp); err != nil {
+		return err
+	}
+
+	// the lock is held while CONNECT is written, so a reader receiving the
+	// reply waits until its version is recorded
+	if cp.Type == CONNECT {
+		if _, err := cp.WriteTo(c.rw); err != nil {
+			return err
+		}
+	} else {
+		c.mu.Unlock()
+		_, err := cp.WriteTo(c.rw)
+		c.mu.Lock()
+		if err != nil {
+			return err
+		}
+	}
+	c.record(cp)
+
+	return nil
+}
+
+// Version returns the protocol version of the connection or 0 if no CONNECT
+// packet was read or written yet
+func (c *Conn) Version() Version {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.version
+}
+
+// ClientID returns the client identifier sent in CONNECT, or the one assigned
+// by the server in a v5 CONNACK if the client didn't send one
+func (c *Conn) ClientID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.clientID
+}
+
+// check checks that cp is allowed in the current state of the connection,
+// c.mu has to be held
+func (c *Conn) check(cp *ControlPacket) error {
+	switch cp.Content.(type) {
+	case *Connect:
+		if c.version != 0 {
+			return connError(cp.Type, "MQTT-3.1.0-2", ErrSecondConnect)
+		}
+	default:
+		if c.version == 0 {
+			return connError(cp.Type, "MQTT-3.1.0-1", ErrConnectExpected)
+		}
+	}
+
+	return nil
+}
+
+// record updates the state of the connection with a read or written packet
+// that passed check, c.mu has to be held
+func (c *Conn) record(cp *ControlPacket) {
+	switch p := cp.Content.(type) {
+	case *Connect:
+		c.version = p.ProtocolVersion
+		c.clientID = p.ClientID
+	case *Connack:
+		if c.clientID == "" && p.Properties != nil {
+			c.clientID = p.Properties.AssignedClientID
+		}
+	}
+}
+
+func connError(t PacketType, rule string, err error) error {
+	return &ProtocolError{
+		Err:        err,
+		Field:      "PacketType",
+		PacketType: t,
+		ReasonCode: reasonProtocolError,
+		Rule:       rule,
+	}
+}
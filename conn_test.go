@@ -0,0 +1,199 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connPair returns a client and a server Conn connected by buffers
+func connPair() (*Conn, *Conn) {
+	var toServer, toClient bytes.Buffer
+	client := NewConn(struct {
+		io.Reader
+		io.Writer
+	}{&toClient, &toServer})
+	server := NewConn(struct {
+		io.Reader
+		io.Writer
+	}{&toServer, &toClient})
+
+	return client, server
+}
+
+func TestConnLearnsVersion(t *testing.T) {
+	client, server := connPair()
+
+	connect := NewControlPacket(CONNECT, MQTTv5)
+	require.NoError(t, client.WritePacket(connect))
+	assert.Equal(t, MQTTv5, client.Version())
+	assert.Empty(t, client.ClientID())
+
+	cp, err := server.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, connect.Content, cp.Content)
+	assert.Equal(t, MQTTv5, server.Version())
+
+	connack := NewControlPacket(CONNACK, MQTTv5)
+	connack.Content.(*Connack).Properties.AssignedClientID = "assigned"
+	require.NoError(t, server.WritePacket(connack))
+	assert.Equal(t, "assigned", server.ClientID())
+
+	cp, err = client.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, connack.Content, cp.Content)
+	assert.Equal(t, "assigned", client.ClientID())
+
+	publish := NewControlPacket(PUBLISH, MQTTv5)
+	publish.Content.(*Publish).Topic = "a"
+	publish.Content.(*Publish).Payload = []byte("x")
+	publish.Content.(*Publish).Properties.User = []User{{Key: "k", Value: "v"}}
+	require.NoError(t, client.WritePacket(publish))
+
+	cp, err = server.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, publish.Content, cp.Content)
+}
+
+func TestConnV3ClientID(t *testing.T) {
+	client, server := connPair()
+
+	connect := NewControlPacket(CONNECT, MQTTv311)
+	connect.Content.(*Connect).ClientID = "device-1"
+	require.NoError(t, client.WritePacket(connect))
+
+	_, err := server.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, MQTTv311, server.Version())
+	assert.Equal(t, "device-1", server.ClientID())
+}
+
+func TestConnRejectsSecondConnect(t *testing.T) {
+	client, server := connPair()
+
+	require.NoError(t, client.WritePacket(NewControlPacket(CONNECT, MQTTv311)))
+	err := client.WritePacket(NewControlPacket(CONNECT, MQTTv5))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrSecondConnect))
+	assert.Equal(t, MQTTv311, client.Version())
+
+	// the rejected packet was not written
+	_, err = server.ReadPacket()
+	require.NoError(t, err)
+	_, err = server.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+
+	// a second CONNECT read from the peer is rejected after it is consumed
+	var b bytes.Buffer
+	_, err = NewControlPacket(CONNECT, MQTTv311).WriteTo(&b)
+	require.NoError(t, err)
+	_, err = NewControlPacket(CONNECT, MQTTv311).WriteTo(&b)
+	require.NoError(t, err)
+	_, err = NewControlPacket(PINGREQ, MQTTv311).WriteTo(&b)
+	require.NoError(t, err)
+
+	conn := NewConn(&b)
+	_, err = conn.ReadPacket()
+	require.NoError(t, err)
+	_, err = conn.ReadPacket()
+	var protoErr *ProtocolError
+	require.True(t, errors.As(err, &protoErr))
+	assert.Equal(t, "MQTT-3.1.0-2", protoErr.Rule)
+	assert.Equal(t, byte(reasonProtocolError), protoErr.ReasonCode)
+
+	cp, err := conn.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, PINGREQ, cp.Type)
+}
+
+func TestConnRequiresConnect(t *testing.T) {
+	client, _ := connPair()
+
+	err := client.WritePacket(NewControlPacket(PINGREQ, MQTTv5))
+	assert.True(t, errors.Is(err, ErrConnectExpected))
+	assert.Zero(t, client.Version())
+
+	var b bytes.Buffer
+	_, err = NewControlPacket(CONNACK, MQTTv311).WriteTo(&b)
+	require.NoError(t, err)
+	_, err = NewConn(&b).ReadPacket()
+	assert.True(t, errors.Is(err, ErrConnectExpected))
+}
+
+func TestConnReaderWaitingForConnack(t *testing.T) {
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+	reading := make(chan struct{})
+	client := NewConn(struct {
+		io.Reader
+		io.Writer
+	}{&notifyingReader{Reader: clientSide, reading: reading}, clientSide})
+	server := NewConn(serverSide)
+
+	// the client starts reading before it writes CONNECT
+	type result struct {
+		cp  *ControlPacket
+		err error
+	}
+	read := make(chan result)
+	go func() {
+		cp, err := client.ReadPacket()
+		read <- result{cp, err}
+	}()
+
+	go func() {
+		if _, err := server.ReadPacket(); err != nil {
+			return
+		}
+		connack := NewControlPacket(CONNACK, MQTTv5)
+		connack.Content.(*Connack).Properties.AssignedClientID = "assigned"
+		_ = server.WritePacket(connack)
+	}()
+
+	<-reading
+	require.NoError(t, client.WritePacket(NewControlPacket(CONNECT, MQTTv5)))
+	r := <-read
+	require.NoError(t, r.err)
+	assert.Equal(t, "assigned", r.cp.Content.(*Connack).Properties.AssignedClientID)
+	assert.Equal(t, "assigned", client.ClientID())
+}
+
+// notifyingReader closes reading when the first read starts
+type notifyingReader struct {
+	io.Reader
+	reading chan struct{}
+	once    sync.Once
+}
+
+func (r *notifyingReader) Read(b []byte) (int, error) {
+	r.once.Do(func() { close(r.reading) })
+	return r.Reader.Read(b)
+}
+
+// failingWriter fails every write
+type failingWriter struct {
+	io.Reader
+}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func TestConnFailedConnectWrite(t *testing.T) {
+	conn := NewConn(failingWriter{&bytes.Buffer{}})
+
+	err := conn.WritePacket(NewControlPacket(CONNECT, MQTTv5))
+	assert.Equal(t, io.ErrClosedPipe, err)
+	assert.Zero(t, conn.Version())
+
+	// the connection still expects CONNECT
+	err = conn.WritePacket(NewControlPacket(PINGREQ, MQTTv5))
+	assert.True(t, errors.Is(err, ErrConnectExpected))
+}
@@ -8,22 +8,22 @@ import (
 
 // Connack is the Variable Header definition for a connack control packet
 type Connack struct {
-	Properties     *Properties
-	ReasonCode     byte
-	SessionPresent bool
+	Properties     *Properties `json:"properties,omitempty"`
+	ReasonCode     byte        `json:"reasonCode,omitempty"`
+	SessionPresent bool        `json:"sessionPresent,omitempty"`
 }
 
 //Unpack is the implementation of the interface required function for a packet
 func (c *Connack) Unpack(r *bytes.Buffer) error {
 	connackFlags, err := r.ReadByte()
 	if err != nil {
-		return err
+		return malformed(CONNACK, "SessionPresent", r.Len(), err)
 	}
 	c.SessionPresent = connackFlags&0x01 > 0
 
 	c.ReasonCode, err = r.ReadByte()
 	if err != nil {
-		return err
+		return malformed(CONNACK, "ReasonCode", r.Len(), err)
 	}
 
 	if c.Properties == nil {
@@ -62,6 +62,19 @@ func (c *Connack) Buffers() net.Buffers {
 	return n
 }
 
+func (c *Connack) size() int {
+	return 2 + c.Properties.prefixedLen(CONNACK)
+}
+
+func (c *Connack) appendTo(dst []byte) []byte {
+	var sessionPresent byte
+	if c.SessionPresent {
+		sessionPresent = 1
+	}
+	dst = append(dst, sessionPresent, c.ReasonCode)
+	return c.Properties.appendPrefixed(dst, CONNACK)
+}
+
 // WriteTo is the implementation of the interface required function for a packet
 func (c *Connack) WriteTo(w io.Writer) (int64, error) {
 	cp := &ControlPacket{FixedHeader: FixedHeader{Type: CONNACK}}
@@ -70,9 +83,16 @@ func (c *Connack) WriteTo(w io.Writer) (int64, error) {
 	return cp.WriteTo(w)
 }
 
-// Reason returns a string representation of the meaning of the ReasonCode
+// Reason returns a string representation of the meaning of the ReasonCode,
+// the v3 return codes of packets without properties are converted with
+// ConnackReasonCode first
 func (c *Connack) Reason() string {
-	switch c.ReasonCode {
+	code := c.ReasonCode
+	if c.Properties == nil && code < 0x80 {
+		code = byte(ConnackReasonCode(code))
+	}
+
+	switch code {
 	case 0:
 		return "Success - The Connection is accepted."
 	case 128:
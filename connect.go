@@ -9,22 +9,27 @@ import (
 
 // Connect is the Variable Header definition for a connect control packet
 type Connect struct {
-	WillMessage     []byte
-	Password        []byte
-	Username        string
-	ProtocolName    string
-	ClientID        string
-	WillTopic       string
-	Properties      *Properties
-	WillProperties  *Properties
-	KeepAlive       uint16
-	ProtocolVersion Version
-	WillQOS         byte
-	PasswordFlag    bool
-	UsernameFlag    bool
-	WillRetain      bool
-	WillFlag        bool
-	CleanStart      bool
+	WillMessage     []byte      `json:"willMessage,omitempty"`
+	Password        []byte      `json:"password,omitempty"`
+	Username        string      `json:"username,omitempty"`
+	ProtocolName    string      `json:"protocolName,omitempty"`
+	ClientID        string      `json:"clientID,omitempty"`
+	WillTopic       string      `json:"willTopic,omitempty"`
+	Properties      *Properties `json:"properties,omitempty"`
+	WillProperties  *Properties `json:"willProperties,omitempty"`
+	KeepAlive       uint16      `json:"keepAlive,omitempty"`
+	ProtocolVersion Version     `json:"protocolVersion,omitempty"`
+	WillQOS         byte        `json:"willQOS,omitempty"`
+	PasswordFlag    bool        `json:"passwordFlag,omitempty"`
+	UsernameFlag    bool        `json:"usernameFlag,omitempty"`
+	WillRetain      bool        `json:"willRetain,omitempty"`
+	WillFlag        bool        `json:"willFlag,omitempty"`
+	CleanStart      bool        `json:"cleanStart,omitempty"`
+
+	// reserved is set when the reserved connect flag was set on the wire
+	reserved bool
+	// limits is handed to the properties created while unpacking
+	limits *decodeLimits
 }
 
 // PackFlags takes the Connect flags and packs them into the single byte
@@ -52,6 +57,7 @@ func (c *Connect) PackFlags() (f byte) {
 // UnpackFlags takes the wire byte representing the connect options flags
 // and fills out the appropriate variables in the struct
 func (c *Connect) UnpackFlags(b byte) {
+	c.reserved = 1&b > 0
 	c.CleanStart = 1&(b>>1) > 0
 	c.WillFlag = 1&(b>>2) > 0
 	c.WillQOS = 3 & (b >> 3)
@@ -65,30 +71,31 @@ func (c *Connect) Unpack(r *bytes.Buffer) error {
 	var err error
 
 	if c.ProtocolName, err = readString(r); err != nil {
-		return err
+		return malformed(CONNECT, "ProtocolName", r.Len(), err)
 	}
 
 	version, err := r.ReadByte()
 	if err != nil {
-		return err
+		return malformed(CONNECT, "ProtocolVersion", r.Len(), err)
 	}
 	if version != 3 && version != 4 && version != 5 {
-		return fmt.Errorf("unknown protocol version: %d", version)
+		return protocolError(CONNECT, "ProtocolVersion", reasonUnsupportedProtocolVersion, r.Len()+1,
+			fmt.Errorf("unknown protocol version: %d", version))
 	}
 	c.ProtocolVersion = Version(version)
 
 	flags, err := r.ReadByte()
 	if err != nil {
-		return err
+		return malformed(CONNECT, "ConnectFlags", r.Len(), err)
 	}
 	c.UnpackFlags(flags)
 
 	if c.KeepAlive, err = readUint16(r); err != nil {
-		return err
+		return malformed(CONNECT, "KeepAlive", r.Len(), err)
 	}
 
 	if c.ProtocolVersion == MQTTv5 {
-		c.Properties = &Properties{}
+		c.Properties = &Properties{limits: c.limits}
 		err = c.Properties.Unpack(r, CONNECT)
 		if err != nil {
 			return err
@@ -97,12 +104,12 @@ func (c *Connect) Unpack(r *bytes.Buffer) error {
 
 	c.ClientID, err = readString(r)
 	if err != nil {
-		return err
+		return malformed(CONNECT, "ClientID", r.Len(), err)
 	}
 
 	if c.WillFlag {
 		if c.ProtocolVersion == MQTTv5 {
-			c.WillProperties = &Properties{}
+			c.WillProperties = &Properties{limits: c.limits}
 			err = c.WillProperties.Unpack(r, will)
 			if err != nil {
 				return err
@@ -110,25 +117,25 @@ func (c *Connect) Unpack(r *bytes.Buffer) error {
 		}
 		c.WillTopic, err = readString(r)
 		if err != nil {
-			return err
+			return malformed(CONNECT, "WillTopic", r.Len(), err)
 		}
 		c.WillMessage, err = readBinary(r)
 		if err != nil {
-			return err
+			return malformed(CONNECT, "WillMessage", r.Len(), err)
 		}
 	}
 
 	if c.UsernameFlag {
 		c.Username, err = readString(r)
 		if err != nil {
-			return err
+			return malformed(CONNECT, "Username", r.Len(), err)
 		}
 	}
 
 	if c.PasswordFlag {
 		c.Password, err = readBinary(r)
 		if err != nil {
-			return err
+			return malformed(CONNECT, "Password", r.Len(), err)
 		}
 	}
 
@@ -169,6 +176,59 @@ func (c *Connect) Buffers() net.Buffers {
 	return net.Buffers{cp.Bytes()}
 }
 
+func (c *Connect) size() int {
+	n := 2 + len(c.ProtocolName) + 4
+	if c.ProtocolVersion == MQTTv5 {
+		idvp := c.Properties.packedLen(CONNECT)
+		n += vbiLen(idvp) + idvp
+	}
+
+	n += 2 + len(c.ClientID)
+	if c.WillFlag {
+		if c.ProtocolVersion == MQTTv5 {
+			willIdvp := c.WillProperties.packedLen(will)
+			n += vbiLen(willIdvp) + willIdvp
+		}
+		n += 2 + len(c.WillTopic) + 2 + len(c.WillMessage)
+	}
+	if c.UsernameFlag {
+		n += 2 + len(c.Username)
+	}
+	if c.PasswordFlag {
+		n += 2 + len(c.Password)
+	}
+
+	return n
+}
+
+func (c *Connect) appendTo(dst []byte) []byte {
+	dst = appendString(dst, c.ProtocolName)
+	dst = append(dst, byte(c.ProtocolVersion), c.PackFlags())
+	dst = appendUint16(dst, c.KeepAlive)
+	if c.ProtocolVersion == MQTTv5 {
+		dst = appendVBI(dst, c.Properties.packedLen(CONNECT))
+		dst = c.Properties.appendPacked(dst, CONNECT)
+	}
+
+	dst = appendString(dst, c.ClientID)
+	if c.WillFlag {
+		if c.ProtocolVersion == MQTTv5 {
+			dst = appendVBI(dst, c.WillProperties.packedLen(will))
+			dst = c.WillProperties.appendPacked(dst, will)
+		}
+		dst = appendString(dst, c.WillTopic)
+		dst = appendBinary(dst, c.WillMessage)
+	}
+	if c.UsernameFlag {
+		dst = appendString(dst, c.Username)
+	}
+	if c.PasswordFlag {
+		dst = appendBinary(dst, c.Password)
+	}
+
+	return dst
+}
+
 // WriteTo is the implementation of the interface required function for a packet
 func (c *Connect) WriteTo(w io.Writer) (int64, error) {
 	cp := &ControlPacket{FixedHeader: FixedHeader{Type: CONNECT}}
@@ -0,0 +1,500 @@
+package mqttpackets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PublishOption configures the packet created by NewPublish
+type PublishOption interface {
+	applyPublish(p *Publish, b *builder)
+}
+
+// ConnectOption configures the packet created by NewConnect
+type ConnectOption interface {
+	applyConnect(c *Connect, b *builder)
+}
+
+// SubscribeOption configures the packet created by NewSubscribe
+type SubscribeOption interface {
+	applySubscribe(s *Subscribe, b *builder)
+}
+
+// ConnackOption configures the packet created by NewConnack
+type ConnackOption interface {
+	applyConnack(c *Connack, b *builder)
+}
+
+// builder holds the options shared by all constructors
+type builder struct {
+	version Version
+	props   *Properties
+	// hasProperties is set when a property or will property was given
+	hasProperties bool
+}
+
+// properties returns the properties of the packet, creating them when the
+// first property is set
+func (b *builder) properties() *Properties {
+	if b.props == nil {
+		b.props = &Properties{}
+	}
+	b.hasProperties = true
+	return b.props
+}
+
+// finish sets the version of content and returns it as a packet of type t
+// once it passes Validate. Without WithVersion the packet is MQTTv5 if
+// properties were given and MQTTv311 otherwise, properties given for an
+// older version are an error.
+func (b *builder) finish(t PacketType, content Packet, props **Properties) (*ControlPacket, error) {
+	v := b.version
+	if v == 0 {
+		v = MQTTv311
+		if b.hasProperties {
+			v = MQTTv5
+		}
+	}
+	if err := checkVersion(v); err != nil {
+		return nil, &EncodeError{PacketType: t, Field: "ProtocolVersion", Err: err}
+	}
+
+	if v != MQTTv5 && b.hasProperties {
+		return nil, &EncodeError{PacketType: t, Field: "Properties",
+			Err: fmt.Errorf("%w: properties require MQTT 5", ErrUnsupportedVersion)}
+	}
+
+	cp := NewControlPacket(t, v)
+	if v == MQTTv5 && b.props == nil {
+		b.props = &Properties{}
+	}
+	*props = b.props
+
+	switch p := content.(type) {
+	case *Connect:
+		p.ProtocolVersion = v
+		if p.ProtocolName == "" {
+			p.ProtocolName = cp.Content.(*Connect).ProtocolName
+		}
+		if p.WillProperties != nil && !p.WillFlag {
+			return nil, &EncodeError{PacketType: t, Field: "WillProperties", Err: errWillPropertiesWithoutWill}
+		}
+		if v == MQTTv5 && p.WillFlag && p.WillProperties == nil {
+			p.WillProperties = &Properties{}
+		}
+	case *Publish:
+		cp.Flags = p.flags()
+	}
+
+	cp.Content = content
+	if err := cp.Validate(v); err != nil {
+		return nil, err
+	}
+	// Validate only warns about a packet ID of a QoS 0 message, as it isn't
+	// encoded, but here it would be dropped silently
+	if p, ok := content.(*Publish); ok && p.QoS == 0 && p.PacketID != 0 {
+		for _, f := range Lint(cp, v) {
+			if f.Field == "PacketID" {
+				f.ReasonCode = reasonProtocolError
+				return nil, f.error(t)
+			}
+		}
+	}
+	return cp, nil
+}
+
+var errWillPropertiesWithoutWill = errors.New("will properties require a will, see WithWill")
+
+// NewPublish returns a PUBLISH packet for the given topic and payload, the
+// payload is not copied. Without options the message is sent with QoS 0.
+// An error is returned if the packet doesn't pass Validate, e.g. because a
+// QoS above 0 is set without a packet ID, or if a packet ID is set for QoS 0.
+func NewPublish(topic string, payload []byte, opts ...PublishOption) (*ControlPacket, error) {
+	var b builder
+	p := &Publish{Topic: topic, Payload: payload}
+	for _, o := range opts {
+		o.applyPublish(p, &b)
+	}
+
+	return b.finish(PUBLISH, p, &p.Properties)
+}
+
+// NewConnect returns a CONNECT packet for the client ID. An error is
+// returned if the packet doesn't pass Validate, e.g. because a will QoS is
+// set without a will.
+func NewConnect(clientID string, opts ...ConnectOption) (*ControlPacket, error) {
+	var b builder
+	c := &Connect{ClientID: clientID}
+	for _, o := range opts {
+		o.applyConnect(c, &b)
+	}
+
+	return b.finish(CONNECT, c, &c.Properties)
+}
+
+// NewSubscribe returns a SUBSCRIBE packet for the subscriptions, an error is
+// returned if the packet doesn't pass Validate, e.g. because the packet ID
+// is 0 or there are no subscriptions
+func NewSubscribe(packetID uint16, subscriptions []Subscription, opts ...SubscribeOption) (*ControlPacket, error) {
+	var b builder
+	s := &Subscribe{PacketID: packetID, Subscriptions: subscriptions}
+	for _, o := range opts {
+		o.applySubscribe(s, &b)
+	}
+
+	return b.finish(SUBSCRIBE, s, &s.Properties)
+}
+
+// NewConnack returns a CONNACK packet with the reason code, which is a v3
+// return code for older versions. An error is returned if the packet doesn't
+// pass Validate, e.g. because the session is present on a refused connection.
+func NewConnack(reasonCode byte, opts ...ConnackOption) (*ControlPacket, error) {
+	var b builder
+	c := &Connack{ReasonCode: reasonCode}
+	for _, o := range opts {
+		o.applyConnack(c, &b)
+	}
+
+	return b.finish(CONNACK, c, &c.Properties)
+}
+
+// PacketOption is an option accepted by all constructors
+type PacketOption func(b *builder)
+
+func (o PacketOption) applyPublish(_ *Publish, b *builder)     { o(b) }
+func (o PacketOption) applyConnect(_ *Connect, b *builder)     { o(b) }
+func (o PacketOption) applySubscribe(_ *Subscribe, b *builder) { o(b) }
+func (o PacketOption) applyConnack(_ *Connack, b *builder)     { o(b) }
+
+// WithVersion sets the protocol version of the packet. By default packets
+// with properties are MQTTv5 and other packets MQTTv311, like MarshalBinary
+// assumes.
+func WithVersion(v Version) PacketOption {
+	return func(b *builder) {
+		b.version = v
+	}
+}
+
+// PropertyOption sets a property, it is accepted by all constructors and
+// by WithWillProperties
+type PropertyOption func(p *Properties)
+
+func (o PropertyOption) applyPublish(_ *Publish, b *builder)     { o(b.properties()) }
+func (o PropertyOption) applyConnect(_ *Connect, b *builder)     { o(b.properties()) }
+func (o PropertyOption) applySubscribe(_ *Subscribe, b *builder) { o(b.properties()) }
+func (o PropertyOption) applyConnack(_ *Connack, b *builder)     { o(b.properties()) }
+
+type publishOption func(p *Publish, b *builder)
+
+func (o publishOption) applyPublish(p *Publish, b *builder) { o(p, b) }
+
+// WithQoS sets the QoS of the message, QoS 1 and 2 require WithPacketID
+func WithQoS(qos byte) PublishOption {
+	return publishOption(func(p *Publish, _ *builder) {
+		p.QoS = qos
+	})
+}
+
+// WithPacketID sets the packet ID of a message sent with QoS 1 or 2
+func WithPacketID(id uint16) PublishOption {
+	return publishOption(func(p *Publish, _ *builder) {
+		p.PacketID = id
+	})
+}
+
+// WithRetain sets the retain flag
+func WithRetain() PublishOption {
+	return publishOption(func(p *Publish, _ *builder) {
+		p.Retain = true
+	})
+}
+
+// WithDuplicate sets the DUP flag of a redelivered message
+func WithDuplicate() PublishOption {
+	return publishOption(func(p *Publish, _ *builder) {
+		p.Duplicate = true
+	})
+}
+
+type connectOption func(c *Connect, b *builder)
+
+func (o connectOption) applyConnect(c *Connect, b *builder) { o(c, b) }
+
+// WithProtocolName overrides the protocol name, which defaults to the name
+// of the protocol version
+func WithProtocolName(name string) ConnectOption {
+	return connectOption(func(c *Connect, _ *builder) {
+		c.ProtocolName = name
+	})
+}
+
+// WithCleanStart sets the clean start flag, called clean session before
+// MQTT 5
+func WithCleanStart() ConnectOption {
+	return connectOption(func(c *Connect, _ *builder) {
+		c.CleanStart = true
+	})
+}
+
+// WithKeepAlive sets the keep alive interval in seconds
+func WithKeepAlive(seconds uint16) ConnectOption {
+	return connectOption(func(c *Connect, _ *builder) {
+		c.KeepAlive = seconds
+	})
+}
+
+// WithUsername sets the user name and its flag
+func WithUsername(username string) ConnectOption {
+	return connectOption(func(c *Connect, _ *builder) {
+		c.UsernameFlag = true
+		c.Username = username
+	})
+}
+
+// WithPassword sets the password and its flag, before MQTT 5 a password
+// requires a user name
+func WithPassword(password []byte) ConnectOption {
+	return connectOption(func(c *Connect, _ *builder) {
+		c.PasswordFlag = true
+		c.Password = password
+	})
+}
+
+// WithWill sets the will flag, topic and message
+func WithWill(topic string, message []byte) ConnectOption {
+	return connectOption(func(c *Connect, _ *builder) {
+		c.WillFlag = true
+		c.WillTopic = topic
+		c.WillMessage = message
+	})
+}
+
+// WithWillQoS sets the QoS of the will, it requires WithWill
+func WithWillQoS(qos byte) ConnectOption {
+	return connectOption(func(c *Connect, _ *builder) {
+		c.WillQOS = qos
+	})
+}
+
+// WithWillRetain sets the retain flag of the will, it requires WithWill
+func WithWillRetain() ConnectOption {
+	return connectOption(func(c *Connect, _ *builder) {
+		c.WillRetain = true
+	})
+}
+
+// WithWillProperties sets properties of the will, it requires WithWill
+func WithWillProperties(opts ...PropertyOption) ConnectOption {
+	return connectOption(func(c *Connect, b *builder) {
+		if c.WillProperties == nil {
+			c.WillProperties = &Properties{}
+		}
+		for _, o := range opts {
+			o(c.WillProperties)
+		}
+		b.hasProperties = true
+	})
+}
+
+type connackOption func(c *Connack, b *builder)
+
+func (o connackOption) applyConnack(c *Connack, b *builder) { o(c, b) }
+
+// WithSessionPresent sets the session present flag
+func WithSessionPresent() ConnackOption {
+	return connackOption(func(c *Connack, _ *builder) {
+		c.SessionPresent = true
+	})
+}
+
+// WithPayloadFormat sets the Payload Format Indicator property
+func WithPayloadFormat(format byte) PropertyOption {
+	return func(p *Properties) {
+		p.PayloadFormat = &format
+	}
+}
+
+// WithMessageExpiry sets the Message Expiry Interval property in seconds
+func WithMessageExpiry(seconds uint32) PropertyOption {
+	return func(p *Properties) {
+		p.MessageExpiry = &seconds
+	}
+}
+
+// WithContentType sets the Content Type property
+func WithContentType(contentType string) PropertyOption {
+	return func(p *Properties) {
+		p.ContentType = contentType
+	}
+}
+
+// WithResponseTopic sets the Response Topic property
+func WithResponseTopic(topic string) PropertyOption {
+	return func(p *Properties) {
+		p.ResponseTopic = topic
+	}
+}
+
+// WithCorrelationData sets the Correlation Data property, data is not
+// copied
+func WithCorrelationData(data []byte) PropertyOption {
+	return func(p *Properties) {
+		p.CorrelationData = data
+	}
+}
+
+// WithSubscriptionIdentifier sets the Subscription Identifier property
+func WithSubscriptionIdentifier(id int) PropertyOption {
+	return func(p *Properties) {
+		p.SubscriptionIdentifier = &id
+	}
+}
+
+// WithSessionExpiryInterval sets the Session Expiry Interval property in
+// seconds
+func WithSessionExpiryInterval(seconds uint32) PropertyOption {
+	return func(p *Properties) {
+		p.SessionExpiryInterval = &seconds
+	}
+}
+
+// WithAssignedClientID sets the Assigned Client Identifier property
+func WithAssignedClientID(clientID string) PropertyOption {
+	return func(p *Properties) {
+		p.AssignedClientID = clientID
+	}
+}
+
+// WithServerKeepAlive sets the Server Keep Alive property in seconds
+func WithServerKeepAlive(seconds uint16) PropertyOption {
+	return func(p *Properties) {
+		p.ServerKeepAlive = &seconds
+	}
+}
+
+// WithAuthMethod sets the Authentication Method property
+func WithAuthMethod(method string) PropertyOption {
+	return func(p *Properties) {
+		p.AuthMethod = method
+	}
+}
+
+// WithAuthData sets the Authentication Data property, data is not copied
+func WithAuthData(data []byte) PropertyOption {
+	return func(p *Properties) {
+		p.AuthData = data
+	}
+}
+
+// WithRequestProblemInfo sets the Request Problem Information property
+func WithRequestProblemInfo(request byte) PropertyOption {
+	return func(p *Properties) {
+		p.RequestProblemInfo = &request
+	}
+}
+
+// WithWillDelayInterval sets the Will Delay Interval property in seconds,
+// it is a will property
+func WithWillDelayInterval(seconds uint32) PropertyOption {
+	return func(p *Properties) {
+		p.WillDelayInterval = &seconds
+	}
+}
+
+// WithRequestResponseInfo sets the Request Response Information property
+func WithRequestResponseInfo(request byte) PropertyOption {
+	return func(p *Properties) {
+		p.RequestResponseInfo = &request
+	}
+}
+
+// WithResponseInfo sets the Response Information property
+func WithResponseInfo(info string) PropertyOption {
+	return func(p *Properties) {
+		p.ResponseInfo = info
+	}
+}
+
+// WithServerReference sets the Server Reference property
+func WithServerReference(server string) PropertyOption {
+	return func(p *Properties) {
+		p.ServerReference = server
+	}
+}
+
+// WithReasonString sets the Reason String property
+func WithReasonString(reason string) PropertyOption {
+	return func(p *Properties) {
+		p.ReasonString = reason
+	}
+}
+
+// WithReceiveMaximum sets the Receive Maximum property
+func WithReceiveMaximum(max uint16) PropertyOption {
+	return func(p *Properties) {
+		p.ReceiveMaximum = &max
+	}
+}
+
+// WithTopicAliasMaximum sets the Topic Alias Maximum property
+func WithTopicAliasMaximum(max uint16) PropertyOption {
+	return func(p *Properties) {
+		p.TopicAliasMaximum = &max
+	}
+}
+
+// WithTopicAlias sets the Topic Alias property
+func WithTopicAlias(alias uint16) PropertyOption {
+	return func(p *Properties) {
+		p.TopicAlias = &alias
+	}
+}
+
+// WithMaximumQOS sets the Maximum QoS property
+func WithMaximumQOS(qos byte) PropertyOption {
+	return func(p *Properties) {
+		p.MaximumQOS = &qos
+	}
+}
+
+// WithRetainAvailable sets the Retain Available property
+func WithRetainAvailable(available byte) PropertyOption {
+	return func(p *Properties) {
+		p.RetainAvailable = &available
+	}
+}
+
+// WithUserProperty adds a User Property, it can be given more than once
+func WithUserProperty(key, value string) PropertyOption {
+	return func(p *Properties) {
+		p.User = append(p.User, User{Key: key, Value: value})
+	}
+}
+
+// WithMaximumPacketSize sets the Maximum Packet Size property
+func WithMaximumPacketSize(size uint32) PropertyOption {
+	return func(p *Properties) {
+		p.MaximumPacketSize = &size
+	}
+}
+
+// WithWildcardSubAvailable sets the Wildcard Subscription Available property
+func WithWildcardSubAvailable(available byte) PropertyOption {
+	return func(p *Properties) {
+		p.WildcardSubAvailable = &available
+	}
+}
+
+// WithSubIDAvailable sets the Subscription Identifier Available property
+func WithSubIDAvailable(available byte) PropertyOption {
+	return func(p *Properties) {
+		p.SubIDAvailable = &available
+	}
+}
+
+// WithSharedSubAvailable sets the Shared Subscription Available property
+func WithSharedSubAvailable(available byte) PropertyOption {
+	return func(p *Properties) {
+		p.SharedSubAvailable = &available
+	}
+}
@@ -0,0 +1,216 @@
+package mqttpackets
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPublish(t *testing.T) {
+	cp, err := NewPublish("a/b", []byte("hi"), WithQoS(1), WithPacketID(7), WithRetain())
+	require.NoError(t, err)
+	assert.Equal(t, PUBLISH, cp.Type)
+	assert.Equal(t, byte(0x3), cp.Flags)
+	assert.Equal(t, &Publish{Topic: "a/b", QoS: 1, PacketID: 7, Retain: true, Payload: []byte("hi")}, cp.Content)
+
+	// properties make it a v5 packet
+	cp, err = NewPublish("a/b", nil, WithMessageExpiry(30), WithUserProperty("k", "v"), WithUserProperty("k", "w"))
+	require.NoError(t, err)
+	expiry := uint32(30)
+	assert.Equal(t, &Properties{
+		MessageExpiry: &expiry,
+		User:          []User{{Key: "k", Value: "v"}, {Key: "k", Value: "w"}},
+	}, cp.Content.(*Publish).Properties)
+
+	cp, err = NewPublish("a/b", nil, WithVersion(MQTTv5))
+	require.NoError(t, err)
+	assert.Equal(t, &Properties{}, cp.Content.(*Publish).Properties)
+
+	// the packet can be encoded as is
+	cp, err = NewPublish("a", []byte("x"), WithQoS(2), WithPacketID(1), WithDuplicate(), WithTopicAlias(3))
+	require.NoError(t, err)
+	b, err := cp.MarshalBinary()
+	require.NoError(t, err)
+	var decoded ControlPacket
+	require.NoError(t, decoded.UnmarshalBinary(b))
+	assert.Equal(t, cp, &decoded)
+}
+
+func TestNewConnect(t *testing.T) {
+	cp, err := NewConnect("client", WithCleanStart(), WithKeepAlive(30), WithUsername("u"), WithPassword([]byte("p")),
+		WithWill("will", []byte("bye")), WithWillQoS(1), WithWillRetain())
+	require.NoError(t, err)
+	assert.Equal(t, &Connect{
+		ProtocolName:    "MQTT",
+		ProtocolVersion: MQTTv311,
+		ClientID:        "client",
+		CleanStart:      true,
+		KeepAlive:       30,
+		UsernameFlag:    true,
+		Username:        "u",
+		PasswordFlag:    true,
+		Password:        []byte("p"),
+		WillFlag:        true,
+		WillQOS:         1,
+		WillRetain:      true,
+		WillTopic:       "will",
+		WillMessage:     []byte("bye"),
+	}, cp.Content)
+
+	cp, err = NewConnect("client", WithVersion(MQTTv31))
+	require.NoError(t, err)
+	assert.Equal(t, "MQIsdp", cp.Content.(*Connect).ProtocolName)
+	assert.Equal(t, MQTTv31, cp.Content.(*Connect).ProtocolVersion)
+
+	// will properties alone make it a v5 packet
+	cp, err = NewConnect("client", WithWill("will", nil), WithWillProperties(WithWillDelayInterval(10)))
+	require.NoError(t, err)
+	delay := uint32(10)
+	c := cp.Content.(*Connect)
+	assert.Equal(t, MQTTv5, c.ProtocolVersion)
+	assert.Equal(t, &Properties{}, c.Properties)
+	assert.Equal(t, &Properties{WillDelayInterval: &delay}, c.WillProperties)
+
+	cp, err = NewConnect("client", WithSessionExpiryInterval(60), WithWill("will", nil))
+	require.NoError(t, err)
+	assert.Equal(t, &Properties{}, cp.Content.(*Connect).WillProperties)
+}
+
+func TestNewSubscribe(t *testing.T) {
+	subs := []Subscription{{Topic: "a/#", QoS: 1}, {Topic: "b", NoLocal: true}}
+	cp, err := NewSubscribe(1, subs, WithSubscriptionIdentifier(5))
+	require.NoError(t, err)
+	id := 5
+	assert.Equal(t, byte(2), cp.Flags)
+	assert.Equal(t, &Subscribe{PacketID: 1, Subscriptions: subs, Properties: &Properties{SubscriptionIdentifier: &id}}, cp.Content)
+
+	// No Local is a v5 option
+	_, err = NewSubscribe(1, subs, WithVersion(MQTTv311))
+	assert.Error(t, err)
+}
+
+func TestNewConnack(t *testing.T) {
+	cp, err := NewConnack(0, WithSessionPresent(), WithAssignedClientID("c"), WithReceiveMaximum(10))
+	require.NoError(t, err)
+	max := uint16(10)
+	assert.Equal(t, &Connack{
+		SessionPresent: true,
+		ReasonCode:     0,
+		Properties:     &Properties{AssignedClientID: "c", ReceiveMaximum: &max},
+	}, cp.Content)
+
+	cp, err = NewConnack(0)
+	require.NoError(t, err)
+	assert.Nil(t, cp.Content.(*Connack).Properties)
+}
+
+func TestConstructorErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() (*ControlPacket, error)
+		rule  string
+		err   error
+	}{
+		{
+			name:  "packet ID required for QoS 1",
+			build: func() (*ControlPacket, error) { return NewPublish("a", nil, WithQoS(1)) },
+			rule:  "MQTT-2.3.1-1",
+		},
+		{
+			name:  "packet ID with QoS 0",
+			build: func() (*ControlPacket, error) { return NewPublish("a", nil, WithQoS(0), WithPacketID(3)) },
+			rule:  "MQTT-2.3.1-5",
+		},
+		{
+			name: "packet ID with QoS 0 v5",
+			build: func() (*ControlPacket, error) {
+				return NewPublish("a", nil, WithPacketID(3), WithVersion(MQTTv5))
+			},
+			rule: "MQTT-2.2.1-2",
+		},
+		{
+			name:  "invalid QoS",
+			build: func() (*ControlPacket, error) { return NewPublish("a", nil, WithQoS(3), WithPacketID(1)) },
+			rule:  "MQTT-3.3.1-4",
+		},
+		{
+			name:  "wildcard topic",
+			build: func() (*ControlPacket, error) { return NewPublish("a/#", nil) },
+			rule:  "MQTT-3.3.2-2",
+		},
+		{
+			name:  "will QoS without will",
+			build: func() (*ControlPacket, error) { return NewConnect("c", WithWillQoS(1)) },
+			rule:  "MQTT-3.1.2-13",
+		},
+		{
+			name:  "will retain without will",
+			build: func() (*ControlPacket, error) { return NewConnect("c", WithWillRetain(), WithVersion(MQTTv5)) },
+			rule:  "MQTT-3.1.2-13",
+		},
+		{
+			name:  "password without user name",
+			build: func() (*ControlPacket, error) { return NewConnect("c", WithPassword([]byte("p"))) },
+			rule:  "MQTT-3.1.2-22",
+		},
+		{
+			name: "will properties without will",
+			build: func() (*ControlPacket, error) {
+				return NewConnect("c", WithWillProperties(WithWillDelayInterval(1)))
+			},
+			err: errWillPropertiesWithoutWill,
+		},
+		{
+			name:  "property not valid for packet",
+			build: func() (*ControlPacket, error) { return NewPublish("a", nil, WithSessionExpiryInterval(1)) },
+			rule:  "§2.2.2.2",
+		},
+		{
+			name:  "properties before v5",
+			build: func() (*ControlPacket, error) { return NewConnect("c", WithVersion(MQTTv311), WithAuthMethod("m")) },
+			err:   ErrUnsupportedVersion,
+		},
+		{
+			name:  "unsupported version",
+			build: func() (*ControlPacket, error) { return NewConnack(0, WithVersion(6)) },
+			err:   ErrUnsupportedVersion,
+		},
+		{
+			name:  "packet ID required for SUBSCRIBE",
+			build: func() (*ControlPacket, error) { return NewSubscribe(0, []Subscription{{Topic: "a"}}) },
+			rule:  "MQTT-2.3.1-1",
+		},
+		{
+			name:  "invalid reason code",
+			build: func() (*ControlPacket, error) { return NewConnack(6) },
+			rule:  "§3.2.2.3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, err := tt.build()
+			require.Error(t, err)
+			assert.Nil(t, cp)
+			if tt.err != nil {
+				var encodeErr *EncodeError
+				assert.True(t, errors.As(err, &encodeErr), "%T", err)
+				assert.True(t, errors.Is(err, tt.err), "%v", err)
+				return
+			}
+
+			var rule string
+			var malformed *MalformedPacketError
+			var protocol *ProtocolError
+			switch {
+			case errors.As(err, &malformed):
+				rule = malformed.Rule
+			case errors.As(err, &protocol):
+				rule = protocol.Rule
+			}
+			assert.Equal(t, tt.rule, rule, "%v", err)
+		})
+	}
+}
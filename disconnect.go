@@ -8,8 +8,8 @@ import (
 
 // Disconnect is the Variable Header definition for a Disconnect control packet
 type Disconnect struct {
-	Properties *Properties
-	ReasonCode byte
+	Properties *Properties `json:"properties,omitempty"`
+	ReasonCode byte        `json:"reasonCode,omitempty"`
 }
 
 // DisconnectNormalDisconnection, etc are the list of valid disconnection reason codes.
@@ -47,22 +47,17 @@ const (
 
 // Unpack is the implementation of the interface required function for a packet
 func (d *Disconnect) Unpack(r *bytes.Buffer) error {
-	if d.Properties == nil {
+	// v3 packets and the v5 short form have no content
+	if d.Properties == nil || r.Len() == 0 {
 		return nil
 	}
 
-	var err error
-	d.ReasonCode, err = r.ReadByte()
-	if err != nil {
-		return err
-	}
-
-	err = d.Properties.Unpack(r, DISCONNECT)
-	if err != nil {
-		return err
+	d.ReasonCode, _ = r.ReadByte()
+	if r.Len() == 0 {
+		return nil
 	}
 
-	return nil
+	return d.Properties.Unpack(r, DISCONNECT)
 }
 
 // Buffers is the implementation of the interface required function for a packet
@@ -72,12 +67,41 @@ func (d *Disconnect) Buffers() net.Buffers {
 	}
 
 	idvp := d.Properties.Pack(DISCONNECT)
-	propLen := encodeVBI(len(idvp))
-	n := net.Buffers{[]byte{d.ReasonCode}, propLen}
-	if len(idvp) > 0 {
-		n = append(n, idvp)
+	if len(idvp) == 0 {
+		if d.ReasonCode == DisconnectNormalDisconnection {
+			return net.Buffers{}
+		}
+		return net.Buffers{[]byte{d.ReasonCode}}
+	}
+
+	return net.Buffers{[]byte{d.ReasonCode}, encodeVBI(len(idvp)), idvp}
+}
+
+func (d *Disconnect) size() int {
+	if d.Properties == nil {
+		return 0
 	}
-	return n
+
+	idvp := d.Properties.packedLen(DISCONNECT)
+	if idvp == 0 {
+		if d.ReasonCode == DisconnectNormalDisconnection {
+			return 0
+		}
+		return 1
+	}
+
+	return 1 + vbiLen(idvp) + idvp
+}
+
+func (d *Disconnect) appendTo(dst []byte) []byte {
+	switch d.size() {
+	case 0:
+		return dst
+	case 1:
+		return append(dst, d.ReasonCode)
+	}
+
+	return d.Properties.appendPrefixed(append(dst, d.ReasonCode), DISCONNECT)
 }
 
 // WriteTo is the implementation of the interface required function for a packet
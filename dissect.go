@@ -0,0 +1,530 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Field is a decoded field of a dissected frame
+type Field struct {
+	// Name is the name of the field, named like the struct field holding it
+	Name string
+	// Value is the decoded value formatted for people, it is empty for
+	// fields that only group other fields
+	Value string
+	// Start and End are the byte range of the field within the frame
+	Start, End int
+	// Fields are the fields this field consists of
+	Fields []*Field
+	// Err is set when the field could not be decoded
+	Err error
+}
+
+// Dissection is the field tree of a frame produced by Dissect
+type Dissection struct {
+	// Frame is the dissected frame, it is not copied
+	Frame []byte
+	// Fields are the top level fields in wire order, starting with the fixed
+	// header. Bytes that could not be decoded are covered by an Undecoded
+	// field and bytes after the packet by a TrailingData field.
+	Fields []*Field
+}
+
+// maxDumpBytes is the number of bytes String shows per field
+const maxDumpBytes = 8
+
+// String returns the dissection as an annotated hex dump with one line per
+// field, e.g.
+//
+//	0000  32                        FixedHeader
+//	0000  32                          PacketType: PUBLISH
+//
+// Fields longer than 8 bytes are shortened, errors follow the field they
+// occurred in.
+func (d *Dissection) String() string {
+	var b strings.Builder
+	var write func(fields []*Field, depth int)
+	write = func(fields []*Field, depth int) {
+		for _, f := range fields {
+			data := d.Frame[f.Start:f.End]
+			hex := make([]string, 0, maxDumpBytes+1)
+			for i := 0; i < len(data) && i < maxDumpBytes; i++ {
+				hex = append(hex, fmt.Sprintf("%02x", data[i]))
+			}
+			if len(data) > maxDumpBytes {
+				hex = append(hex, "..")
+			}
+			fmt.Fprintf(&b, "%04x  %-26s %s%s", f.Start, strings.Join(hex, " "), strings.Repeat("  ", depth), f.Name)
+			if f.Value != "" {
+				fmt.Fprintf(&b, ": %s", f.Value)
+			}
+			if f.Err != nil {
+				fmt.Fprintf(&b, " <%v>", f.Err)
+			}
+			b.WriteByte('\n')
+			write(f.Fields, depth+1)
+		}
+	}
+	write(d.Fields, 0)
+
+	return b.String()
+}
+
+// Dissect decodes the packet at the start of frame like DecodePacket does
+// and returns the byte range and value of every field. Unlike DecodePacket
+// it doesn't stop at every error: an invalid property skips the rest of the
+// property block, an invalid protocol version or subscription option is
+// reported and decoding continues. Errors that leave the remaining fields
+// unknown, like truncated fields, stop decoding, the rest of the packet is
+// then covered by an Undecoded field. The dissection is always returned, the
+// error is the first one found and matches the error of DecodePacket. A
+// frame shorter than its remaining length is dissected as far as it goes.
+func Dissect(frame []byte, v Version) (*Dissection, error) {
+	d := &dissector{dissection: &Dissection{Frame: frame}, frame: frame}
+	d.dissect(v)
+
+	return d.dissection, d.err
+}
+
+// dissector holds the state of a Dissect call, the field readers are the
+// ones used by the Unpack methods
+type dissector struct {
+	dissection *Dissection
+	frame      []byte
+	// r holds the unread bytes of the packet, which ends at end
+	r   *bytes.Buffer
+	end int
+	t   PacketType
+	v5  bool
+	// prefix is prepended to the field names of errors
+	prefix  string
+	err     error
+	stopped bool
+}
+
+func (d *dissector) dissect(v Version) {
+	header := &Field{Name: "FixedHeader"}
+	d.dissection.Fields = []*Field{header}
+	if len(d.frame) == 0 {
+		d.fail(header, io.ErrUnexpectedEOF)
+		return
+	}
+
+	header.End = 1
+	d.t = PacketType(d.frame[0] >> 4)
+	cp, err := newPacketFromHeader(d.frame[0], v)
+	packetType := &Field{Name: "PacketType", Value: d.t.String(), End: 1}
+	if err != nil {
+		d.fail(packetType, err)
+	}
+	flags := &Field{Name: "Flags", Value: headerFlags(d.t, d.frame[0]&0xF), End: 1}
+	header.Fields = append(header.Fields, packetType, flags)
+
+	d.r = bytes.NewBuffer(d.frame[1:])
+	d.end = len(d.frame)
+	remainingLength := &Field{Name: "RemainingLength", Start: 1}
+	header.Fields = append(header.Fields, remainingLength)
+	size := 0
+	vbi, err := getVBI(d.r)
+	if err == nil {
+		size, err = decodeVBI(vbi)
+	}
+	remainingLength.End = d.offset()
+	header.End = d.offset()
+	switch {
+	case err == io.EOF:
+		d.fail(remainingLength, io.ErrUnexpectedEOF)
+		cp = nil
+	case err != nil:
+		d.fail(remainingLength, remainingLengthError(d.t, err))
+		cp = nil
+	case header.End+size > len(d.frame):
+		d.fail(remainingLength, io.ErrUnexpectedEOF)
+	case header.End+size < len(d.frame):
+		d.end = header.End + size
+		d.seek(header.End)
+	}
+	if err == nil {
+		remainingLength.Value = fmt.Sprintf("%d", size)
+	}
+
+	if cp == nil {
+		d.stopped = true
+	} else {
+		d.v5 = len(packetProperties(cp)) > 0
+		d.content(cp)
+	}
+
+	if d.r.Len() > 0 {
+		d.add(&d.dissection.Fields, &Field{Name: "Undecoded", Value: fmt.Sprintf("%d bytes", d.r.Len()), Start: d.offset(), End: d.end})
+	}
+	if d.end < len(d.frame) {
+		d.add(&d.dissection.Fields, &Field{Name: "TrailingData", Value: fmt.Sprintf("%d bytes", len(d.frame)-d.end), Start: d.end, End: len(d.frame)})
+	}
+}
+
+// headerFlags formats the fixed header flags, naming the PUBLISH flags
+func headerFlags(t PacketType, flags byte) string {
+	if t != PUBLISH {
+		return fmt.Sprintf("0x%X", flags)
+	}
+
+	names := []string{fmt.Sprintf("q%d", flags&0x6>>1)}
+	if flags&0x8 > 0 {
+		names = append(names, "dup")
+	}
+	if flags&0x1 > 0 {
+		names = append(names, "retain")
+	}
+	return fmt.Sprintf("0x%X (%s)", flags, strings.Join(names, " "))
+}
+
+// offset returns the frame offset of the next unread byte
+func (d *dissector) offset() int {
+	return d.end - d.r.Len()
+}
+
+// seek continues reading at frame offset
+func (d *dissector) seek(offset int) {
+	d.r = bytes.NewBuffer(d.frame[offset:d.end])
+}
+
+func (d *dissector) add(parent *[]*Field, f *Field) *Field {
+	*parent = append(*parent, f)
+	return f
+}
+
+// fail records err for field f, the first error is returned by Dissect
+func (d *dissector) fail(f *Field, err error) {
+	f.Err = err
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// malformed records a MalformedPacketError for field f
+func (d *dissector) malformed(f *Field, err error) {
+	d.fail(f, setErrorOffset(malformed(d.t, d.prefix+f.Name, d.end-f.Start, err), d.end))
+}
+
+// read adds the field read by fn, decoding stops if fn fails. Nothing is
+// read once decoding stopped.
+func (d *dissector) read(parent *[]*Field, name string, fn func() (string, error)) (*Field, bool) {
+	if d.stopped {
+		return nil, false
+	}
+
+	f := &Field{Name: name, Start: d.offset()}
+	value, err := fn()
+	f.Value, f.End = value, d.offset()
+	d.add(parent, f)
+	if err != nil {
+		d.malformed(f, err)
+		d.stopped = true
+		return f, false
+	}
+
+	return f, true
+}
+
+func (d *dissector) readByte(parent *[]*Field, name string, format func(byte) string) (byte, *Field, bool) {
+	var b byte
+	f, ok := d.read(parent, name, func() (string, error) {
+		var err error
+		if b, err = d.r.ReadByte(); err != nil {
+			return "", err
+		}
+		return format(b), nil
+	})
+	return b, f, ok
+}
+
+func (d *dissector) readUint16(parent *[]*Field, name string) {
+	d.read(parent, name, func() (string, error) {
+		u, err := readUint16(d.r)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d", u), nil
+	})
+}
+
+func (d *dissector) readString(parent *[]*Field, name string) {
+	d.read(parent, name, func() (string, error) {
+		s, err := readString(d.r)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%q", s), nil
+	})
+}
+
+// readBinary adds a binary field, its value is the length or redacted
+func (d *dissector) readBinary(parent *[]*Field, name string, redact bool) {
+	d.read(parent, name, func() (string, error) {
+		data, err := readBinary(d.r)
+		if err != nil {
+			return "", err
+		}
+		if redact {
+			return redacted, nil
+		}
+		return fmt.Sprintf("%d bytes", len(data)), nil
+	})
+}
+
+// reasonCode formats a reason code, it is named for v5 packets
+func (d *dissector) reasonCode(code byte) string {
+	if d.v5 {
+		return fmt.Sprintf("0x%02X %s", code, ReasonCode(code).NameFor(d.t))
+	}
+	return fmt.Sprintf("0x%02X", code)
+}
+
+func formatUint8(b byte) string {
+	return fmt.Sprintf("%d", b)
+}
+
+// properties adds the property block of packet type p like
+// Properties.Unpack reads it. An invalid property skips the rest of the
+// block, decoding only stops if the length of the block is invalid.
+func (d *dissector) properties(parent *[]*Field, p PacketType) {
+	if d.stopped {
+		return
+	}
+
+	t, name := p, "Properties"
+	if p == will {
+		t, name = CONNECT, "WillProperties"
+	}
+	fail := func(f *Field, err error) {
+		d.fail(f, setErrorOffset(malformed(t, name, d.end-f.Start, err), d.end))
+	}
+
+	block := d.add(parent, &Field{Name: name, Start: d.offset()})
+	length := d.add(&block.Fields, &Field{Name: "Length", Start: block.Start})
+	vbi, err := getVBI(d.r)
+	size := 0
+	if err == nil {
+		size, err = decodeVBI(vbi)
+	}
+	if err == nil && size > d.r.Len() {
+		err = errPropertyOverflow
+	}
+	length.End, block.End = d.offset(), d.offset()
+	if err != nil {
+		// the error covers the block, like the one returned by Unpack
+		fail(block, err)
+		d.stopped = true
+		return
+	}
+	length.Value = fmt.Sprintf("%d", size)
+
+	end := d.offset() + size
+	for d.offset() < end {
+		prop := d.add(&block.Fields, &Field{Start: d.offset()})
+		id, _ := d.r.ReadByte()
+		prop.Name = propertyNames[id]
+		if prop.Name == "" {
+			prop.Name = fmt.Sprintf("Property 0x%02X", id)
+		}
+
+		if !ValidateID(p, id) {
+			err = fmt.Errorf("invalid Prop type %d for packet %d", id, p)
+		} else {
+			var value Properties
+			if err = value.unpackProperty(d.r, id); err == nil && d.offset() > end {
+				err = errPropertyOverflow
+			}
+			if err == nil {
+				prop.Value = value.formatProperty(id)
+			}
+		}
+		prop.End = d.offset()
+		if err != nil {
+			fail(prop, err)
+			if prop.End < end {
+				d.add(&block.Fields, &Field{Name: "Undecoded", Value: fmt.Sprintf("%d bytes", end-prop.End), Start: prop.End, End: end})
+				d.seek(end)
+			}
+			break
+		}
+	}
+	block.End = d.offset()
+}
+
+// content adds the fields of the variable header and payload of cp
+func (d *dissector) content(cp *ControlPacket) {
+	fields := &d.dissection.Fields
+	switch p := cp.Content.(type) {
+	case *Connect:
+		d.connect(fields, p)
+	case *Connack:
+		d.readByte(fields, "SessionPresent", func(b byte) string {
+			return fmt.Sprintf("%t", b&0x01 > 0)
+		})
+		d.readByte(fields, "ReasonCode", d.reasonCode)
+		if d.v5 {
+			d.properties(fields, CONNACK)
+		}
+	case *Publish:
+		d.readString(fields, "Topic")
+		if p.QoS > 0 {
+			d.readUint16(fields, "PacketID")
+		}
+		if d.v5 {
+			d.properties(fields, PUBLISH)
+		}
+		d.read(fields, "Payload", func() (string, error) {
+			return fmt.Sprintf("%d bytes", len(d.r.Next(d.r.Len()))), nil
+		})
+	case *Puback, *Pubrec, *Pubrel, *Pubcomp:
+		d.readUint16(fields, "PacketID")
+		d.reasonAndProperties(fields)
+	case *Subscribe:
+		d.subscribe(fields)
+	case *Suback, *Unsuback:
+		d.readUint16(fields, "PacketID")
+		if d.v5 {
+			d.properties(fields, d.t)
+		}
+		d.reasons(fields)
+	case *Unsubscribe:
+		d.readUint16(fields, "PacketID")
+		if d.v5 {
+			d.properties(fields, UNSUBSCRIBE)
+		}
+		for i := 0; !d.stopped && d.r.Len() > 0; i++ {
+			d.readString(fields, fmt.Sprintf("Topics[%d]", i))
+		}
+	case *Disconnect, *Auth:
+		d.v5 = d.v5 || d.t == AUTH
+		d.reasonAndProperties(fields)
+	}
+}
+
+// reasonAndProperties adds the optional v5 reason code and properties that
+// end acknowledgements, DISCONNECT and AUTH packets
+func (d *dissector) reasonAndProperties(fields *[]*Field) {
+	if !d.v5 || d.stopped || d.r.Len() == 0 {
+		return
+	}
+	d.readByte(fields, "ReasonCode", d.reasonCode)
+	if d.r.Len() > 0 {
+		d.properties(fields, d.t)
+	}
+}
+
+func (d *dissector) connect(fields *[]*Field, c *Connect) {
+	d.readString(fields, "ProtocolName")
+
+	version, f, ok := d.readByte(fields, "ProtocolVersion", formatUint8)
+	if ok && version != 3 && version != 4 && version != 5 {
+		err := protocolError(CONNECT, f.Name, reasonUnsupportedProtocolVersion, d.end-f.Start,
+			fmt.Errorf("unknown protocol version: %d", version))
+		d.fail(f, setErrorOffset(err, d.end))
+	} else if ok {
+		c.ProtocolVersion = Version(version)
+	}
+	d.v5 = c.ProtocolVersion == MQTTv5
+
+	d.readByte(fields, "ConnectFlags", func(b byte) string {
+		c.UnpackFlags(b)
+		return connectFlags(b)
+	})
+	d.readUint16(fields, "KeepAlive")
+	if d.v5 {
+		d.properties(fields, CONNECT)
+	}
+	d.readString(fields, "ClientID")
+
+	if c.WillFlag {
+		if d.v5 {
+			d.properties(fields, will)
+		}
+		d.readString(fields, "WillTopic")
+		d.readBinary(fields, "WillMessage", false)
+	}
+	if c.UsernameFlag {
+		d.readString(fields, "Username")
+	}
+	if c.PasswordFlag {
+		d.readBinary(fields, "Password", true)
+	}
+}
+
+// connectFlags formats the connect flags, naming the ones that are set
+func connectFlags(b byte) string {
+	var c Connect
+	c.UnpackFlags(b)
+
+	var names []string
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{c.UsernameFlag, "username"},
+		{c.PasswordFlag, "password"},
+		{c.WillRetain, "willRetain"},
+		{c.WillFlag || c.WillQOS > 0, fmt.Sprintf("willQOS=%d", c.WillQOS)},
+		{c.WillFlag, "will"},
+		{c.CleanStart, "cleanStart"},
+		{c.reserved, "reserved"},
+	} {
+		if flag.set {
+			names = append(names, flag.name)
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("0x%02X", b)
+	}
+	return fmt.Sprintf("0x%02X (%s)", b, strings.Join(names, " "))
+}
+
+func (d *dissector) subscribe(fields *[]*Field) {
+	d.readUint16(fields, "PacketID")
+	v := MQTTv311
+	if d.v5 {
+		v = MQTTv5
+		d.properties(fields, SUBSCRIBE)
+	}
+
+	for i := 0; !d.stopped && d.r.Len() > 0; i++ {
+		name := fmt.Sprintf("Subscriptions[%d]", i)
+		sub := d.add(fields, &Field{Name: name, Start: d.offset()})
+		d.prefix = name + "."
+		d.readString(&sub.Fields, "Topic")
+		b, f, ok := d.readByte(&sub.Fields, "Options", subscriptionOptions)
+		if ok {
+			if err := optionsError(b, v); err != nil {
+				d.malformed(f, err)
+			}
+		}
+		d.prefix = ""
+		sub.End = d.offset()
+	}
+}
+
+// subscriptionOptions formats a subscription options byte like
+// Subscribe.String does
+func subscriptionOptions(b byte) string {
+	var s Subscription
+	s.unpackOptions(b)
+	return fmt.Sprintf("0x%02X (%s)", b, s.optionsString())
+}
+
+// reasons adds the reason codes that make up the payload of SUBACK and
+// UNSUBACK packets
+func (d *dissector) reasons(fields *[]*Field) {
+	if d.stopped || d.r.Len() == 0 {
+		return
+	}
+
+	reasons := d.add(fields, &Field{Name: "Reasons", Start: d.offset()})
+	for i := 0; d.r.Len() > 0; i++ {
+		d.readByte(&reasons.Fields, fmt.Sprintf("Reasons[%d]", i), d.reasonCode)
+	}
+	reasons.End = d.offset()
+}
@@ -0,0 +1,274 @@
+package mqttpackets
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fieldRange is the name, value and byte range of a dissected field
+type fieldRange struct {
+	Name, Value string
+	Start, End  int
+}
+
+func fieldRanges(fields []*Field) []fieldRange {
+	ranges := make([]fieldRange, len(fields))
+	for i, f := range fields {
+		ranges[i] = fieldRange{f.Name, f.Value, f.Start, f.End}
+	}
+	return ranges
+}
+
+// checkCoverage checks that the top level fields cover the frame without
+// gaps and that child fields lie within their parent
+func checkCoverage(t *testing.T, d *Dissection) {
+	t.Helper()
+
+	var within func(parent *Field)
+	within = func(parent *Field) {
+		for _, f := range parent.Fields {
+			assert.True(t, f.Start >= parent.Start && f.End <= parent.End && f.Start <= f.End,
+				"%s [%d,%d) outside of %s [%d,%d)", f.Name, f.Start, f.End, parent.Name, parent.Start, parent.End)
+			within(f)
+		}
+	}
+
+	offset := 0
+	for _, f := range d.Fields {
+		assert.Equal(t, offset, f.Start, f.Name)
+		offset = f.End
+		within(f)
+	}
+	assert.Equal(t, len(d.Frame), offset)
+}
+
+func TestDissectPublish(t *testing.T) {
+	expiry := uint32(60)
+	cp := NewControlPacket(PUBLISH, MQTTv5)
+	p := cp.Content.(*Publish)
+	p.QoS = 1
+	p.Retain = true
+	p.PacketID = 7
+	p.Topic = "a/b"
+	p.Properties.MessageExpiry = &expiry
+	p.Properties.User = []User{{Key: "k", Value: "v"}}
+	p.Payload = []byte("hi")
+	frame, err := cp.AppendTo(nil)
+	require.NoError(t, err)
+
+	d, err := Dissect(frame, MQTTv5)
+	require.NoError(t, err)
+	checkCoverage(t, d)
+
+	assert.Equal(t, []fieldRange{
+		{"FixedHeader", "", 0, 2},
+		{"Topic", `"a/b"`, 2, 7},
+		{"PacketID", "7", 7, 9},
+		{"Properties", "", 9, 22},
+		{"Payload", "2 bytes", 22, 24},
+	}, fieldRanges(d.Fields))
+	assert.Equal(t, []fieldRange{
+		{"PacketType", "PUBLISH", 0, 1},
+		{"Flags", "0x3 (q1 retain)", 0, 1},
+		{"RemainingLength", "22", 1, 2},
+	}, fieldRanges(d.Fields[0].Fields))
+	assert.Equal(t, []fieldRange{
+		{"Length", "12", 9, 10},
+		{"MessageExpiry", "60", 10, 15},
+		{"User", `{"k":"v"}`, 15, 22},
+	}, fieldRanges(d.Fields[3].Fields))
+}
+
+func TestDissectSubscribe(t *testing.T) {
+	cp := NewControlPacket(SUBSCRIBE, MQTTv5)
+	s := cp.Content.(*Subscribe)
+	s.PacketID = 1
+	s.Subscriptions = []Subscription{{Topic: "a", QoS: 1}, {Topic: "b/#", QoS: 2, NoLocal: true, RetainHandling: 1}}
+	frame, err := cp.AppendTo(nil)
+	require.NoError(t, err)
+
+	d, err := Dissect(frame, MQTTv5)
+	require.NoError(t, err)
+	checkCoverage(t, d)
+
+	assert.Equal(t, []fieldRange{
+		{"FixedHeader", "", 0, 2},
+		{"PacketID", "1", 2, 4},
+		{"Properties", "", 4, 5},
+		{"Subscriptions[0]", "", 5, 9},
+		{"Subscriptions[1]", "", 9, 15},
+	}, fieldRanges(d.Fields))
+	assert.Equal(t, []fieldRange{
+		{"Topic", `"b/#"`, 9, 14},
+		{"Options", "0x16 (q2,nl,rh1)", 14, 15},
+	}, fieldRanges(d.Fields[4].Fields))
+}
+
+func TestDissectReasonNames(t *testing.T) {
+	d, err := Dissect([]byte{0x90, 4, 0, 1, 0, 0}, MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, "0x00 Granted QoS 0", d.Fields[3].Fields[0].Value)
+
+	d, err = Dissect([]byte{0xE0, 2, 0, 0}, MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, "0x00 Normal disconnection", d.Fields[1].Value)
+
+	d, err = Dissect([]byte{0x20, 3, 0, 0, 0}, MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, "0x00 Success", d.Fields[2].Value)
+}
+
+func TestDissectConnect(t *testing.T) {
+	cp := NewControlPacket(CONNECT, MQTTv5)
+	c := cp.Content.(*Connect)
+	c.ClientID = "c"
+	c.CleanStart = true
+	c.KeepAlive = 30
+	c.WillFlag = true
+	c.WillQOS = 1
+	c.WillTopic = "w"
+	c.WillMessage = []byte("bye")
+	c.WillProperties = &Properties{ContentType: "text/plain"}
+	c.PasswordFlag = true
+	c.Password = []byte("secret")
+	frame, err := cp.AppendTo(nil)
+	require.NoError(t, err)
+
+	// the version is taken from the packet
+	d, err := Dissect(frame, 0)
+	require.NoError(t, err)
+	checkCoverage(t, d)
+
+	names := make([]string, len(d.Fields))
+	for i, f := range d.Fields {
+		names[i] = f.Name
+	}
+	assert.Equal(t, []string{"FixedHeader", "ProtocolName", "ProtocolVersion", "ConnectFlags", "KeepAlive",
+		"Properties", "ClientID", "WillProperties", "WillTopic", "WillMessage", "Password"}, names)
+	assert.Equal(t, "0x4E (password willQOS=1 will cleanStart)", d.Fields[3].Value)
+	assert.Equal(t, redacted, d.Fields[10].Value)
+	assert.Equal(t, `"text/plain"`, d.Fields[7].Fields[1].Value)
+}
+
+func TestDissectMatchesDecodePacket(t *testing.T) {
+	stream, _ := parserStream(t)
+	var frames [][]byte
+	for len(stream) > 0 {
+		n := frameLen(stream)
+		frames = append(frames, stream[:n])
+		stream = stream[n:]
+	}
+	frames = append(frames,
+		[]byte{16, 17, 0, 4, 77, 81, 84, 84, 4, 2, 0, 30, 0, 10, 116, 101, 115, 116, 67},
+		[]byte{16, 12, 0, 4, 77, 81, 84, 84, 6, 2, 0, 30, 0, 0},
+		[]byte{0x40, 7, 0, 1, 0x10, 3, 35, 0, 1},
+		[]byte{0x30, 12, 0, 1, 'a', 4, 3, 0, 5, 'a', 'b', 'c', 'd', 'e'},
+		[]byte{0x82, 9, 0, 1, 0, 1, 'a', 1, 0, 5, 'b'},
+		[]byte{0x82, 7, 0, 1, 0, 0, 1, 'a', 0x40},
+		[]byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x01},
+		[]byte{0x00, 0x00},
+		[]byte{0x30},
+		nil,
+	)
+
+	for _, frame := range frames {
+		for _, v := range []Version{0, MQTTv311, MQTTv5} {
+			// every truncation of the body, with the remaining length fixed up
+			headerLen := 1
+			for headerLen < len(frame) && frame[headerLen] >= 0x80 {
+				headerLen++
+			}
+			headerLen++
+			for n := 0; headerLen <= len(frame) && n <= len(frame)-headerLen && n < 128; n++ {
+				truncated := append([]byte{frame[0], byte(n)}, frame[headerLen:headerLen+n]...)
+
+				_, _, want := DecodePacket(truncated, v)
+				d, err := Dissect(truncated, v)
+				assert.Equal(t, want, err, "% x version %d", truncated, v)
+				checkCoverage(t, d)
+			}
+
+			_, _, want := DecodePacket(frame, v)
+			d, err := Dissect(frame, v)
+			assert.Equal(t, want, err, "% x version %d", frame, v)
+			if len(frame) > 0 {
+				checkCoverage(t, d)
+			}
+		}
+	}
+}
+
+func TestDissectContinuesAfterErrors(t *testing.T) {
+	// PUBLISH with a Session Expiry Interval property, which is not allowed
+	// in PUBLISH packets, followed by a Message Expiry Interval
+	frame := []byte{0x30, 16, 0, 1, 'a', 10, 0x11, 0, 0, 0, 1, 0x02, 0, 0, 0, 60, 'h', 'i'}
+
+	_, _, want := DecodePacket(frame, MQTTv5)
+	require.Error(t, want)
+	d, err := Dissect(frame, MQTTv5)
+	assert.Equal(t, want, err)
+	checkCoverage(t, d)
+
+	props := d.Fields[2]
+	require.Len(t, props.Fields, 3)
+	assert.Equal(t, err, props.Fields[1].Err)
+	assert.Equal(t, fieldRange{"Undecoded", "9 bytes", 7, 16}, fieldRanges(props.Fields)[2])
+	assert.Equal(t, fieldRange{"Payload", "2 bytes", 16, 18}, fieldRanges(d.Fields)[3])
+
+	// reserved option bits in the first of two subscriptions
+	frame = []byte{0x82, 10, 0, 1, 0, 1, 'a', 0x40, 0, 1, 'b', 0x01}
+	_, _, want = DecodePacket(frame, MQTTv311)
+	d, err = Dissect(frame, MQTTv311)
+	assert.Equal(t, want, err)
+	checkCoverage(t, d)
+	require.Len(t, d.Fields, 4)
+	assert.Equal(t, err, d.Fields[2].Fields[1].Err)
+	assert.Equal(t, `"b"`, d.Fields[3].Fields[0].Value)
+
+	// bytes after the packet are not decoded
+	d, err = Dissect([]byte{0xC0, 0, 0xD0, 0}, MQTTv311)
+	require.NoError(t, err)
+	assert.Equal(t, fieldRange{"TrailingData", "2 bytes", 2, 4}, fieldRanges(d.Fields)[1])
+}
+
+func TestDissectTruncatedFrame(t *testing.T) {
+	// the remaining length claims more bytes than the frame holds
+	frame := []byte{0x30, 10, 0, 1, 'a', 'h'}
+
+	d, err := Dissect(frame, MQTTv311)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	checkCoverage(t, d)
+	assert.Equal(t, err, d.Fields[0].Fields[2].Err)
+	assert.Equal(t, []fieldRange{
+		{"FixedHeader", "", 0, 2},
+		{"Topic", `"a"`, 2, 5},
+		{"Payload", "1 bytes", 5, 6},
+	}, fieldRanges(d.Fields))
+}
+
+func TestDissectionString(t *testing.T) {
+	d, err := Dissect([]byte{0x40, 4, 0, 1, 0x10, 0}, MQTTv5)
+	require.NoError(t, err)
+
+	assert.Equal(t, `0000  40 04                      FixedHeader
+0000  40                           PacketType: PUBACK
+0000  40                           Flags: 0x0
+0001  04                           RemainingLength: 4
+0002  00 01                      PacketID: 1
+0004  10                         ReasonCode: 0x10 No matching subscribers
+0005  00                         Properties
+0005  00                           Length: 0
+`, d.String())
+
+	d, _ = Dissect([]byte{0x30, 9, 0, 5, 'a', 'b'}, MQTTv311)
+	assert.Equal(t, `0000  30 09                      FixedHeader
+0000  30                           PacketType: PUBLISH
+0000  30                           Flags: 0x0 (q0)
+0001  09                           RemainingLength: 9 <unexpected EOF>
+0002                             Topic <malformed PUBLISH packet: Topic at offset 2: unexpected EOF>
+0002  00 05 61 62                Undecoded: 4 bytes
+`, d.String())
+}
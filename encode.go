@@ -0,0 +1,275 @@
+package mqttpackets
+
+import (
+	"fmt"
+	"net"
+)
+
+// maxFieldLen is the largest string or binary field a two byte length prefix
+// can describe
+const maxFieldLen = 65535
+
+// Encode returns the wire format of the packet, starting with the fixed
+// header. Unlike Buffers it checks the packet first and returns an
+// *EncodeError instead of producing a corrupt frame when a string or binary
+// field is longer than 65535 bytes, the remaining length exceeds 268435455,
+// a QoS or retain handling is out of range or a property is not valid for the packet type.
+// The flags of PUBLISH packets are taken from the Publish content.
+func (c *ControlPacket) Encode() (net.Buffers, error) {
+	header, err := c.fixedHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	buffers := c.Content.Buffers()
+	remaining := 0
+	for _, b := range buffers {
+		remaining += len(b)
+	}
+	if remaining > maxRemainingLength {
+		return nil, &EncodeError{PacketType: c.Type, Field: "RemainingLength", Err: ErrVBIOutOfRange}
+	}
+	c.remainingLength = remaining
+
+	fixed := make([]byte, 1, 1+maxVBILen)
+	fixed[0] = header
+	fixed = append(fixed, encodeVBI(remaining)...)
+
+	return append(net.Buffers{fixed}, buffers...), nil
+}
+
+// Size returns the number of bytes the packet takes on the wire, including
+// the fixed header, without encoding it. The packet is not checked, so the
+// size is only meaningful for packets that Encode accepts.
+func (c *ControlPacket) Size() int {
+	if c.Content == nil {
+		return 0
+	}
+
+	n := c.bodyLen()
+	return 1 + vbiLen(n) + n
+}
+
+// AppendTo appends the wire format of the packet to dst and returns the
+// extended slice. The packet is checked like Encode does and dst is returned
+// unchanged on error. dst grows at most once, so nothing is allocated when it
+// has Size bytes of spare capacity.
+func (c *ControlPacket) AppendTo(dst []byte) ([]byte, error) {
+	header, err := c.fixedHeader()
+	if err != nil {
+		return dst, err
+	}
+
+	remaining := c.bodyLen()
+	if remaining > maxRemainingLength {
+		return dst, &EncodeError{PacketType: c.Type, Field: "RemainingLength", Err: ErrVBIOutOfRange}
+	}
+	c.remainingLength = remaining
+
+	if n := 1 + vbiLen(remaining) + remaining; cap(dst)-len(dst) < n {
+		grown := make([]byte, len(dst), len(dst)+n)
+		copy(grown, dst)
+		dst = grown
+	}
+	dst = appendVBI(append(dst, header), remaining)
+	if a, ok := c.Content.(appender); ok {
+		return a.appendTo(dst), nil
+	}
+	for _, b := range c.Content.Buffers() {
+		dst = append(dst, b...)
+	}
+
+	return dst, nil
+}
+
+// appender is implemented by the contents of this package, so they can be
+// sized and encoded without building their Buffers
+type appender interface {
+	size() int
+	appendTo(dst []byte) []byte
+}
+
+// bodyLen returns the remaining length of the packet
+func (c *ControlPacket) bodyLen() int {
+	if a, ok := c.Content.(appender); ok {
+		return a.size()
+	}
+
+	var n int
+	for _, b := range c.Content.Buffers() {
+		n += len(b)
+	}
+	return n
+}
+
+// fixedHeader checks the packet for Encode and AppendTo and returns the first
+// byte of its fixed header. The flags of PUBLISH packets are taken from the
+// Publish content.
+func (c *ControlPacket) fixedHeader() (byte, error) {
+	if c.Type == 0 || c.Type > AUTH || c.Content == nil {
+		return 0, &EncodeError{PacketType: c.Type, Field: "PacketType", Err: ErrInvalidPacketType}
+	}
+
+	ec := &encodeChecker{t: c.Type}
+	ec.packet(c.Content)
+	if ec.err != nil {
+		return 0, ec.err
+	}
+
+	flags := c.Flags
+	if p, ok := c.Content.(*Publish); ok {
+		flags = p.flags()
+	}
+
+	return byte(c.Type)<<4 | flags&0x0F, nil
+}
+
+// encodeChecker walks the content of a packet and records the first field
+// that can't be encoded
+type encodeChecker struct {
+	t   PacketType
+	err error
+}
+
+func (ec *encodeChecker) fail(field string, err error) {
+	if ec.err == nil {
+		ec.err = &EncodeError{PacketType: ec.t, Field: field, Err: err}
+	}
+}
+
+// length checks the length of a string or binary field
+func (ec *encodeChecker) length(field string, n int) {
+	if n > maxFieldLen {
+		ec.fail(field, ErrFieldTooLong)
+	}
+}
+
+func (ec *encodeChecker) qos(field string, q byte) {
+	if q > 2 {
+		ec.fail(field, ErrInvalidQoS)
+	}
+}
+
+func (ec *encodeChecker) packet(content Packet) {
+	switch p := content.(type) {
+	case *Connect:
+		ec.length("ProtocolName", len(p.ProtocolName))
+		ec.qos("WillQOS", p.WillQOS)
+		if p.ProtocolVersion == MQTTv5 {
+			ec.properties(CONNECT, "Properties", p.Properties)
+		}
+		ec.length("ClientID", len(p.ClientID))
+		if p.WillFlag {
+			if p.ProtocolVersion == MQTTv5 {
+				ec.properties(will, "WillProperties", p.WillProperties)
+			}
+			ec.length("WillTopic", len(p.WillTopic))
+			ec.length("WillMessage", len(p.WillMessage))
+		}
+		if p.UsernameFlag {
+			ec.length("Username", len(p.Username))
+		}
+		if p.PasswordFlag {
+			ec.length("Password", len(p.Password))
+		}
+	case *Connack:
+		ec.properties(CONNACK, "Properties", p.Properties)
+	case *Publish:
+		ec.qos("QoS", p.QoS)
+		ec.length("Topic", len(p.Topic))
+		ec.properties(PUBLISH, "Properties", p.Properties)
+	case *Puback:
+		ec.properties(PUBACK, "Properties", p.Properties)
+	case *Pubrec:
+		ec.properties(PUBREC, "Properties", p.Properties)
+	case *Pubrel:
+		ec.properties(PUBREL, "Properties", p.Properties)
+	case *Pubcomp:
+		ec.properties(PUBCOMP, "Properties", p.Properties)
+	case *Subscribe:
+		ec.properties(SUBSCRIBE, "Properties", p.Properties)
+		for i, s := range p.Subscriptions {
+			if len(s.Topic) > maxFieldLen {
+				ec.fail(fmt.Sprintf("Subscriptions[%d].Topic", i), ErrFieldTooLong)
+			}
+			if s.QoS > 2 {
+				ec.fail(fmt.Sprintf("Subscriptions[%d].QoS", i), ErrInvalidQoS)
+			}
+			if s.RetainHandling > 2 {
+				ec.fail(fmt.Sprintf("Subscriptions[%d].RetainHandling", i), ErrInvalidRetainHandling)
+			}
+		}
+	case *Suback:
+		ec.properties(SUBACK, "Properties", p.Properties)
+	case *Unsubscribe:
+		ec.properties(UNSUBSCRIBE, "Properties", p.Properties)
+		for i, t := range p.Topics {
+			if len(t) > maxFieldLen {
+				ec.fail(fmt.Sprintf("Topics[%d]", i), ErrFieldTooLong)
+			}
+		}
+	case *Unsuback:
+		ec.properties(UNSUBACK, "Properties", p.Properties)
+	case *Disconnect:
+		ec.properties(DISCONNECT, "Properties", p.Properties)
+	case *Auth:
+		ec.properties(AUTH, "Properties", p.Properties)
+	}
+}
+
+// properties checks that every property set is valid for packet type t and
+// fits its encoding
+func (ec *encodeChecker) properties(t PacketType, field string, p *Properties) {
+	if p == nil {
+		return
+	}
+
+	for _, id := range propertyOrder {
+		if p.encodedLen(id) == 0 {
+			continue
+		}
+		// the name is only built when the property fails
+		if !ValidateID(t, id) {
+			ec.fail(field+"."+propertyNames[id], ErrInvalidProperty)
+			continue
+		}
+
+		var n int
+		switch id {
+		case PropContentType:
+			n = len(p.ContentType)
+		case PropResponseTopic:
+			n = len(p.ResponseTopic)
+		case PropCorrelationData:
+			n = len(p.CorrelationData)
+		case PropSubscriptionIdentifier:
+			if *p.SubscriptionIdentifier < 0 || *p.SubscriptionIdentifier > maxRemainingLength {
+				ec.fail(field+"."+propertyNames[id], ErrVBIOutOfRange)
+			}
+		case PropAssignedClientID:
+			n = len(p.AssignedClientID)
+		case PropResponseInfo:
+			n = len(p.ResponseInfo)
+		case PropAuthMethod:
+			n = len(p.AuthMethod)
+		case PropAuthData:
+			n = len(p.AuthData)
+		case PropServerReference:
+			n = len(p.ServerReference)
+		case PropReasonString:
+			n = len(p.ReasonString)
+		case PropUser:
+			for i, u := range p.User {
+				if len(u.Key) > maxFieldLen {
+					ec.fail(fmt.Sprintf("%s.User[%d].Key", field, i), ErrFieldTooLong)
+				}
+				if len(u.Value) > maxFieldLen {
+					ec.fail(fmt.Sprintf("%s.User[%d].Value", field, i), ErrFieldTooLong)
+				}
+			}
+		}
+		if n > maxFieldLen {
+			ec.fail(field+"."+propertyNames[id], ErrFieldTooLong)
+		}
+	}
+}
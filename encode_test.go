@@ -0,0 +1,304 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeErrors(t *testing.T) {
+	long := strings.Repeat("a", 70000)
+	tooBig := maxRemainingLength + 1
+
+	tests := []struct {
+		name   string
+		packet func() *ControlPacket
+		field  string
+		err    error
+	}{
+		{
+			name: "long topic",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv311)
+				cp.Content.(*Publish).Topic = long
+				return cp
+			},
+			field: "Topic",
+			err:   ErrFieldTooLong,
+		},
+		{
+			name: "long password",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(CONNECT, MQTTv311)
+				c := cp.Content.(*Connect)
+				c.UsernameFlag = true
+				c.PasswordFlag = true
+				c.Password = []byte(long)
+				return cp
+			},
+			field: "Password",
+			err:   ErrFieldTooLong,
+		},
+		{
+			name: "long user property",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBACK, MQTTv5)
+				cp.Content.(*Puback).Properties.User = []User{{Key: "k", Value: long}}
+				return cp
+			},
+			field: "Properties.User[0].Value",
+			err:   ErrFieldTooLong,
+		},
+		{
+			name: "publish qos 3",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv311)
+				cp.Content.(*Publish).Topic = "a"
+				cp.Content.(*Publish).QoS = 3
+				return cp
+			},
+			field: "QoS",
+			err:   ErrInvalidQoS,
+		},
+		{
+			name: "will qos 3",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(CONNECT, MQTTv311)
+				cp.Content.(*Connect).WillFlag = true
+				cp.Content.(*Connect).WillQOS = 3
+				return cp
+			},
+			field: "WillQOS",
+			err:   ErrInvalidQoS,
+		},
+		{
+			name: "subscription qos 3",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBSCRIBE, MQTTv311)
+				cp.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "a"}, {Topic: "b", QoS: 3}}
+				return cp
+			},
+			field: "Subscriptions[1].QoS",
+			err:   ErrInvalidQoS,
+		},
+		{
+			name: "reason string in publish",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv5)
+				cp.Content.(*Publish).Topic = "a"
+				cp.Content.(*Publish).Properties.ReasonString = "reason"
+				return cp
+			},
+			field: "Properties.ReasonString",
+			err:   ErrInvalidProperty,
+		},
+		{
+			name: "topic alias in will",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(CONNECT, MQTTv5)
+				alias := uint16(1)
+				cp.Content.(*Connect).WillFlag = true
+				cp.Content.(*Connect).WillProperties = &Properties{TopicAlias: &alias}
+				return cp
+			},
+			field: "WillProperties.TopicAlias",
+			err:   ErrInvalidProperty,
+		},
+		{
+			name: "subscription identifier out of range",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBSCRIBE, MQTTv5)
+				cp.Content.(*Subscribe).Properties.SubscriptionIdentifier = &tooBig
+				cp.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "a"}}
+				return cp
+			},
+			field: "Properties.SubscriptionIdentifier",
+			err:   ErrVBIOutOfRange,
+		},
+		{
+			name: "invalid packet type",
+			packet: func() *ControlPacket {
+				return &ControlPacket{FixedHeader: FixedHeader{Type: 16}, Content: &Pingreq{}}
+			},
+			field: "PacketType",
+			err:   ErrInvalidPacketType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := tt.packet()
+			_, err := cp.Encode()
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tt.err), err)
+
+			var encErr *EncodeError
+			require.True(t, errors.As(err, &encErr))
+			assert.Equal(t, tt.field, encErr.Field)
+
+			var b bytes.Buffer
+			n, err := cp.WriteTo(&b)
+			assert.Error(t, err)
+			assert.Zero(t, n)
+			assert.Zero(t, b.Len())
+		})
+	}
+}
+
+func TestEncodePublishFlags(t *testing.T) {
+	cp := NewControlPacket(PUBLISH, MQTTv311)
+	p := cp.Content.(*Publish)
+	p.Topic = "a"
+	p.QoS = 2
+	p.PacketID = 1
+	p.Retain = true
+	p.Duplicate = true
+	p.Payload = []byte("x")
+
+	bufs, err := cp.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, byte(PUBLISH<<4|0x0D), bufs[0][0])
+
+	decoded, _, err := DecodePacket(bytes.Join(bufs, nil), MQTTv311)
+	require.NoError(t, err)
+	assert.Equal(t, p, decoded.Content)
+}
+
+func TestEncodeWillProperties(t *testing.T) {
+	cp := NewControlPacket(CONNECT, MQTTv5)
+	c := cp.Content.(*Connect)
+	c.ClientID = "c"
+	c.WillFlag = true
+	c.WillTopic = "will"
+	c.WillMessage = []byte("bye")
+	delay := uint32(30)
+	c.WillProperties = &Properties{WillDelayInterval: &delay, ContentType: "text/plain"}
+
+	var b bytes.Buffer
+	_, err := cp.WriteTo(&b)
+	require.NoError(t, err)
+
+	decoded, err := ReadPacket(&b, 0)
+	require.NoError(t, err)
+	props := decoded.Content.(*Connect).WillProperties
+	require.NotNil(t, props.WillDelayInterval)
+	assert.Equal(t, delay, *props.WillDelayInterval)
+	assert.Equal(t, "text/plain", props.ContentType)
+}
+
+func TestSizeAndAppendTo(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet func() *ControlPacket
+	}{
+		{
+			name: "puback success",
+			packet: func() *ControlPacket {
+				return NewControlPacket(PUBACK, MQTTv5)
+			},
+		},
+		{
+			name: "pubrec reason code",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBREC, MQTTv5)
+				cp.Content.(*Pubrec).ReasonCode = PubrecNoMatchingSubscribers
+				return cp
+			},
+		},
+		{
+			name: "pubcomp properties",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBCOMP, MQTTv5)
+				cp.Content.(*Pubcomp).Properties.ReasonString = "done"
+				return cp
+			},
+		},
+		{
+			name: "connect without properties",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(CONNECT, MQTTv5)
+				c := cp.Content.(*Connect)
+				c.Properties = nil
+				c.WillFlag = true
+				c.WillTopic = "will"
+				return cp
+			},
+		},
+		{
+			name: "disconnect v3",
+			packet: func() *ControlPacket {
+				return NewControlPacket(DISCONNECT, MQTTv311)
+			},
+		},
+		{
+			name: "disconnect reason code",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(DISCONNECT, MQTTv5)
+				cp.Content.(*Disconnect).ReasonCode = DisconnectServerShuttingDown
+				return cp
+			},
+		},
+		{
+			name: "subscribe v3",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBSCRIBE, MQTTv311)
+				cp.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "a/#", QoS: 1, NoLocal: true}}
+				return cp
+			},
+		},
+		{
+			name: "publish with a two byte remaining length",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv5)
+				p := cp.Content.(*Publish)
+				p.Topic = "a"
+				p.QoS = 1
+				p.PacketID = 1
+				p.Payload = bytes.Repeat([]byte("x"), 200)
+				id := 300
+				p.Properties.SubscriptionIdentifier = &id
+				p.Properties.User = []User{{Key: "k", Value: "v"}}
+				return cp
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := tt.packet()
+			bufs, err := cp.Encode()
+			require.NoError(t, err)
+			frame := bytes.Join(bufs, nil)
+			assert.Equal(t, len(frame), cp.Size())
+
+			prefix := []byte("prefix")
+			appended, err := cp.AppendTo(prefix)
+			require.NoError(t, err)
+			assert.Equal(t, append(prefix, frame...), appended)
+
+			buf := make([]byte, 0, cp.Size())
+			allocs := testing.AllocsPerRun(10, func() {
+				_, _ = cp.AppendTo(buf)
+			})
+			assert.Zero(t, allocs)
+		})
+	}
+}
+
+func TestAppendToErrors(t *testing.T) {
+	cp := NewControlPacket(PUBLISH, MQTTv5)
+	cp.Content.(*Publish).QoS = 3
+
+	dst := []byte("prefix")
+	appended, err := cp.AppendTo(dst)
+	assert.True(t, errors.Is(err, ErrInvalidQoS))
+	assert.Equal(t, dst, appended)
+
+	_, err = (&ControlPacket{}).AppendTo(nil)
+	assert.True(t, errors.Is(err, ErrInvalidPacketType))
+	assert.Zero(t, (&ControlPacket{}).Size())
+}
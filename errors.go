@@ -0,0 +1,190 @@
+package mqttpackets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reason codes carried by the decode errors, they match the v5 reason codes
+// that should be sent in a DISCONNECT or CONNACK in response to the error.
+const (
+	reasonMalformedPacket            = 0x81
+	reasonProtocolError              = 0x82
+	reasonUnsupportedProtocolVersion = 0x84
+	reasonPacketTooLarge             = 0x95
+	reasonQuotaExceeded              = 0x97
+)
+
+// MalformedPacketError is returned when a packet can not be decoded because
+// it does not follow the MQTT wire format.
+type MalformedPacketError struct {
+	// Err is the underlying cause of the error
+	Err error
+	// Field is the name of the field that was being decoded
+	Field string
+	// Offset is the byte offset within the frame at which decoding failed.
+	// It is filled in by ReadPacket and DecodePacket, errors returned
+	// directly by an Unpack method have it set to -1.
+	Offset int
+	// PacketType is the type of the packet that was being decoded
+	PacketType PacketType
+	// ReasonCode is the v5 reason code to send in response to the error
+	ReasonCode byte
+	// Rule is the violated spec statement, e.g. MQTT-3.3.1-4, it is only set
+	// by validation
+	Rule string
+
+	remaining int
+}
+
+func (e *MalformedPacketError) Error() string {
+	if e.Rule != "" {
+		return fmt.Sprintf("malformed %s packet: %s: %v [%s]", packetTypeName(e.PacketType), e.Field, e.Err, e.Rule)
+	}
+	return fmt.Sprintf("malformed %s packet: %s at offset %d: %v", packetTypeName(e.PacketType), e.Field, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying cause of the error
+func (e *MalformedPacketError) Unwrap() error {
+	return e.Err
+}
+
+// ProtocolError is returned when a packet is well formed but its content is
+// not allowed by the MQTT protocol.
+type ProtocolError struct {
+	// Err is the underlying cause of the error
+	Err error
+	// Field is the name of the field that was being decoded
+	Field string
+	// Offset is the byte offset within the frame at which decoding failed.
+	// It is filled in by ReadPacket and DecodePacket, errors returned
+	// directly by an Unpack method have it set to -1.
+	Offset int
+	// PacketType is the type of the packet that was being decoded
+	PacketType PacketType
+	// ReasonCode is the v5 reason code to send in response to the error
+	ReasonCode byte
+	// Rule is the violated spec statement, e.g. MQTT-3.3.1-4, it is only set
+	// by validation
+	Rule string
+
+	remaining int
+}
+
+func (e *ProtocolError) Error() string {
+	if e.Rule != "" {
+		return fmt.Sprintf("protocol error in %s packet: %s: %v [%s]", packetTypeName(e.PacketType), e.Field, e.Err, e.Rule)
+	}
+	return fmt.Sprintf("protocol error in %s packet: %s at offset %d: %v", packetTypeName(e.PacketType), e.Field, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying cause of the error
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+// Errors wrapped by EncodeError
+var (
+	ErrFieldTooLong          = errors.New("field exceeds 65535 bytes")
+	ErrVBIOutOfRange         = errors.New("value exceeds the variable byte integer range")
+	ErrInvalidQoS            = errors.New("QoS must be 0, 1 or 2")
+	ErrInvalidRetainHandling = errors.New("retain handling must be 0, 1 or 2")
+	ErrInvalidProperty       = errors.New("property is not valid for the packet type")
+	ErrInvalidPacketType     = errors.New("invalid packet type")
+)
+
+// EncodeError is returned when a packet can not be encoded without producing
+// an invalid frame.
+type EncodeError struct {
+	// Err is the underlying cause of the error
+	Err error
+	// Field is the name of the field that can't be encoded
+	Field string
+	// PacketType is the type of the packet that was being encoded
+	PacketType PacketType
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("can't encode %s packet: %s: %v", packetTypeName(e.PacketType), e.Field, e.Err)
+}
+
+// Unwrap returns the underlying cause of the error
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
+// malformed creates a MalformedPacketError for a field of packet type t,
+// remaining is the number of unread bytes in the packet when the error
+// occurred and is used to calculate the frame offset.
+func malformed(t PacketType, field string, remaining int, err error) *MalformedPacketError {
+	return &MalformedPacketError{
+		PacketType: t,
+		Field:      field,
+		Offset:     -1,
+		ReasonCode: reasonMalformedPacket,
+		Err:        err,
+		remaining:  remaining,
+	}
+}
+
+// protocolError creates a ProtocolError for a field of packet type t, see
+// malformed for the meaning of remaining.
+func protocolError(t PacketType, field string, code byte, remaining int, err error) *ProtocolError {
+	return &ProtocolError{
+		PacketType: t,
+		Field:      field,
+		Offset:     -1,
+		ReasonCode: code,
+		Err:        err,
+		remaining:  remaining,
+	}
+}
+
+// setErrorOffset fills in the frame offset of a decode error returned by an
+// Unpack method, frameLen is the length of the whole packet.
+func setErrorOffset(err error, frameLen int) error {
+	switch e := err.(type) {
+	case *MalformedPacketError:
+		e.Offset = frameLen - e.remaining
+	case *ProtocolError:
+		e.Offset = frameLen - e.remaining
+	}
+
+	return err
+}
+
+// packetTypeName returns the name of a packet type, the will pseudo type is
+// reported as CONNECT.
+func packetTypeName(t PacketType) string {
+	if t == will {
+		return "CONNECT"
+	}
+	return t.String()
+}
+
+// Errors wrapped by TranslateError
+var (
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrNoEquivalent       = errors.New("packet has no equivalent in the target version")
+	ErrUnknownTopicAlias  = errors.New("topic alias has not been set")
+)
+
+// TranslateError is returned when a packet can not be translated to another
+// protocol version.
+type TranslateError struct {
+	// Err is the underlying cause of the error
+	Err error
+	// Field is the name of the field that can't be translated
+	Field string
+	// PacketType is the type of the packet that was being translated
+	PacketType PacketType
+}
+
+func (e *TranslateError) Error() string {
+	return fmt.Sprintf("can't translate %s packet: %s: %v", packetTypeName(e.PacketType), e.Field, e.Err)
+}
+
+// Unwrap returns the underlying cause of the error
+func (e *TranslateError) Unwrap() error {
+	return e.Err
+}
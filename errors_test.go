@@ -0,0 +1,139 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeErrorTruncatedField(t *testing.T) {
+	// CONNECT with the client ID length claiming more bytes than available
+	p := []byte{16, 17, 0, 4, 77, 81, 84, 84, 4, 2, 0, 30, 0, 10, 116, 101, 115, 116, 67}
+
+	_, err := ReadPacket(bytes.NewReader(p), 0)
+
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, CONNECT, malformedErr.PacketType)
+	assert.Equal(t, "ClientID", malformedErr.Field)
+	assert.Equal(t, 12, malformedErr.Offset)
+	assert.Equal(t, byte(0x81), malformedErr.ReasonCode)
+
+	_, _, err = DecodePacket(p, 0)
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, 12, malformedErr.Offset)
+}
+
+func TestDecodeErrorProtocolVersion(t *testing.T) {
+	p := []byte{16, 12, 0, 4, 77, 81, 84, 84, 6, 2, 0, 30, 0, 0}
+
+	_, err := ReadPacket(bytes.NewReader(p), 0)
+
+	var protocolErr *ProtocolError
+	require.True(t, errors.As(err, &protocolErr))
+	assert.Equal(t, CONNECT, protocolErr.PacketType)
+	assert.Equal(t, "ProtocolVersion", protocolErr.Field)
+	assert.Equal(t, 8, protocolErr.Offset)
+	assert.Equal(t, byte(0x84), protocolErr.ReasonCode)
+}
+
+func TestDecodeErrorInvalidProperty(t *testing.T) {
+	// PUBACK with a reason code and a Topic Alias property
+	p := []byte{0x40, 7, 0, 1, 0x10, 3, 35, 0, 1}
+
+	_, err := ReadPacket(bytes.NewReader(p), MQTTv5)
+
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, PUBACK, malformedErr.PacketType)
+	assert.Equal(t, "Properties", malformedErr.Field)
+	assert.Equal(t, 6, malformedErr.Offset)
+}
+
+func TestDecodeErrorPropertyOverflow(t *testing.T) {
+	// PUBLISH with a Content Type property longer than the property length
+	p := []byte{0x30, 12, 0, 1, 'a', 4, 3, 0, 5, 'a', 'b', 'c', 'd', 'e'}
+
+	_, err := ReadPacket(bytes.NewReader(p), MQTTv5)
+
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, 6, malformedErr.Offset)
+	assert.True(t, errors.Is(err, errPropertyOverflow))
+}
+
+func TestDecodeErrorSubscription(t *testing.T) {
+	p := []byte{0x82, 9, 0, 1, 0, 1, 'a', 1, 0, 5, 'b'}
+
+	_, err := ReadPacket(bytes.NewReader(p), MQTTv311)
+
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, "Subscriptions[1].Topic", malformedErr.Field)
+	assert.Equal(t, 8, malformedErr.Offset)
+}
+
+func TestDecodeErrorSubscriptionReservedOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		version Version
+		packet  []byte
+		err     error
+	}{
+		{name: "no local on v3", version: MQTTv311, packet: []byte{0x82, 6, 0, 1, 0, 1, 'a', 0x05}, err: errReservedOptions},
+		{name: "retain handling on v3", version: MQTTv311, packet: []byte{0x82, 6, 0, 1, 0, 1, 'a', 0x20}, err: errReservedOptions},
+		{name: "bit 6 on v5", version: MQTTv5, packet: []byte{0x82, 7, 0, 1, 0, 0, 1, 'a', 0x40}, err: errReservedOptions},
+		{name: "QoS 3 on v3", version: MQTTv311, packet: []byte{0x82, 6, 0, 1, 0, 1, 'a', 0x03}, err: ErrInvalidQoS},
+		{name: "QoS 3 on v5", version: MQTTv5, packet: []byte{0x82, 7, 0, 1, 0, 0, 1, 'a', 0x07}, err: ErrInvalidQoS},
+		{name: "retain handling 3 on v5", version: MQTTv5, packet: []byte{0x82, 7, 0, 1, 0, 0, 1, 'a', 0x31}, err: ErrInvalidRetainHandling},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadPacket(bytes.NewReader(tt.packet), tt.version)
+
+			var malformedErr *MalformedPacketError
+			require.True(t, errors.As(err, &malformedErr))
+			assert.Equal(t, "Subscriptions[0].Options", malformedErr.Field)
+			assert.Equal(t, len(tt.packet)-1, malformedErr.Offset)
+			assert.True(t, errors.Is(err, tt.err), "%v", err)
+		})
+	}
+
+	cp, err := ReadPacket(bytes.NewReader([]byte{0x82, 7, 0, 1, 0, 0, 1, 'a', 0x2E}), MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, []Subscription{{Topic: "a", QoS: 2, NoLocal: true, RetainAsPublished: true, RetainHandling: 2}},
+		cp.Content.(*Subscribe).Subscriptions)
+}
+
+func TestDecodeErrorPacketType(t *testing.T) {
+	_, err := ReadPacket(bytes.NewReader([]byte{0x00, 0x00}), MQTTv311)
+
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, "PacketType", malformedErr.Field)
+	assert.Equal(t, 0, malformedErr.Offset)
+}
+
+func TestDecodeErrorUnpack(t *testing.T) {
+	var p Publish
+	err := p.Unpack(bytes.NewBuffer([]byte{0, 5, 'a'}))
+
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, PUBLISH, malformedErr.PacketType)
+	assert.Equal(t, "Topic", malformedErr.Field)
+	assert.Equal(t, -1, malformedErr.Offset)
+}
+
+func TestDecodeErrorNetwork(t *testing.T) {
+	p := []byte{0x30, 10, 0, 1}
+
+	_, err := ReadPacket(bytes.NewReader(p), MQTTv311)
+
+	var malformedErr *MalformedPacketError
+	assert.False(t, errors.As(err, &malformedErr))
+}
@@ -0,0 +1,124 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"io"
+	"net"
+)
+
+// RawFrame is a control packet split off a stream without being decoded
+type RawFrame struct {
+	// Header is the first byte of the fixed header, holding the packet type
+	// and flags
+	Header byte
+	// RemainingLength is the remaining length of the fixed header, the
+	// length of Body
+	RemainingLength int
+	// Body is the variable header and payload of the packet
+	Body []byte
+}
+
+// Type returns the packet type of the frame
+func (f *RawFrame) Type() PacketType {
+	return PacketType(f.Header >> 4)
+}
+
+// Flags returns the flags of the fixed header
+func (f *RawFrame) Flags() byte {
+	return f.Header & 0xF
+}
+
+// Decode decodes the frame as a packet of protocol version v with the
+// Unpack method of its type. Like DecodePacket it doesn't copy the body, so
+// binary fields alias Body.
+func (f *RawFrame) Decode(v Version) (*ControlPacket, error) {
+	cp, err := newPacketFromHeader(f.Header, v)
+	if err != nil {
+		return nil, err
+	}
+
+	cp.remainingLength = len(f.Body)
+	if err = cp.Content.Unpack(bytes.NewBuffer(f.Body)); err != nil {
+		return nil, setErrorOffset(err, 1+vbiLen(len(f.Body))+len(f.Body))
+	}
+
+	return cp, nil
+}
+
+// Buffers returns the frame in its wire format, Body is not copied
+func (f *RawFrame) Buffers() net.Buffers {
+	header := append([]byte{f.Header}, encodeVBI(len(f.Body))...)
+	return net.Buffers{header, f.Body}
+}
+
+// WriteTo writes the frame unchanged to w
+func (f *RawFrame) WriteTo(w io.Writer) (int64, error) {
+	buffers := f.Buffers()
+	return buffers.WriteTo(w)
+}
+
+// ReadRawFrame reads a single control packet from r without decoding it.
+// io.EOF is returned if r ends before the frame, io.ErrUnexpectedEOF if it
+// ends within it.
+func ReadRawFrame(r io.Reader) (RawFrame, error) {
+	var f RawFrame
+
+	t := [1]byte{}
+	if _, err := io.ReadFull(r, t[:]); err != nil {
+		return f, err
+	}
+	f.Header = t[0]
+
+	vbi, err := getVBI(r)
+	if err == io.EOF {
+		return f, io.ErrUnexpectedEOF
+	}
+	if err == errMalformedVBI {
+		return f, remainingLengthError(f.Type(), err)
+	}
+	if err != nil {
+		return f, err
+	}
+	f.RemainingLength, err = decodeVBI(vbi)
+	if err != nil {
+		return f, remainingLengthError(f.Type(), err)
+	}
+
+	var body bytes.Buffer
+	if f.RemainingLength <= maxPreallocSize {
+		body.Grow(f.RemainingLength)
+	}
+	n, err := io.CopyN(&body, r, int64(f.RemainingLength))
+	if err == io.EOF && n < int64(f.RemainingLength) {
+		return f, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return f, err
+	}
+	f.Body = body.Bytes()
+
+	return f, nil
+}
+
+// ScanPackets is a bufio.SplitFunc that splits a stream into whole control
+// packets, each token is a frame including its fixed header. Packets larger
+// than the buffer of the bufio.Scanner fail with bufio.ErrTooLong, use
+// Scanner.Buffer to allow them.
+func ScanPackets(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	n := frameLen(data)
+	if n == 0 && len(data) > maxVBILen {
+		return 0, nil, remainingLengthError(PacketType(data[0]>>4), errMalformedVBI)
+	}
+	if n == 0 || n > len(data) {
+		if atEOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+
+	return n, data[:n], nil
+}
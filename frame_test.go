@@ -0,0 +1,107 @@
+package mqttpackets
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanPackets(t *testing.T) {
+	stream, want := parserStream(t)
+
+	scanner := bufio.NewScanner(io.MultiReader(bytes.NewReader(stream[:5]), bytes.NewReader(stream[5:])))
+	scanner.Split(ScanPackets)
+
+	var frames [][]byte
+	for scanner.Scan() {
+		frames = append(frames, append([]byte(nil), scanner.Bytes()...))
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, frames, len(want))
+	assert.Equal(t, stream, bytes.Join(frames, nil))
+
+	for i, frame := range frames {
+		cp, n, err := DecodePacket(frame, MQTTv5)
+		require.NoError(t, err)
+		assert.Equal(t, len(frame), n)
+		assert.Equal(t, want[i].Content, cp.Content)
+	}
+}
+
+func TestScanPacketsErrors(t *testing.T) {
+	scanner := bufio.NewScanner(bytes.NewReader([]byte{0xC0, 0, 0x30, 5, 0}))
+	scanner.Split(ScanPackets)
+	require.True(t, scanner.Scan())
+	assert.False(t, scanner.Scan())
+	assert.Equal(t, io.ErrUnexpectedEOF, scanner.Err())
+
+	scanner = bufio.NewScanner(bytes.NewReader([]byte{0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0x01}))
+	scanner.Split(ScanPackets)
+	assert.False(t, scanner.Scan())
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(scanner.Err(), &malformedErr))
+	assert.Equal(t, "RemainingLength", malformedErr.Field)
+}
+
+func TestReadRawFrame(t *testing.T) {
+	stream, want := parserStream(t)
+	r := bytes.NewReader(stream)
+
+	var out bytes.Buffer
+	for _, cp := range want {
+		f, err := ReadRawFrame(r)
+		require.NoError(t, err)
+		assert.Equal(t, cp.Type, f.Type())
+		assert.Equal(t, cp.Flags, f.Flags())
+		assert.Equal(t, len(f.Body), f.RemainingLength)
+
+		decoded, err := f.Decode(MQTTv5)
+		require.NoError(t, err)
+		assert.Equal(t, cp.Content, decoded.Content)
+
+		_, err = f.WriteTo(&out)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, stream, out.Bytes())
+
+	_, err := ReadRawFrame(r)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = ReadRawFrame(bytes.NewReader([]byte{0x30, 10, 0, 1}))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestRawFrameUnpack(t *testing.T) {
+	cp := NewControlPacket(PUBLISH, MQTTv311)
+	p := cp.Content.(*Publish)
+	p.Topic = "a/b"
+	p.QoS = 1
+	p.PacketID = 7
+	p.Payload = []byte("payload")
+
+	var b bytes.Buffer
+	_, err := cp.WriteTo(&b)
+	require.NoError(t, err)
+
+	f, err := ReadRawFrame(&b)
+	require.NoError(t, err)
+
+	unpacked := &Publish{QoS: 1}
+	require.NoError(t, unpacked.Unpack(bytes.NewBuffer(f.Body)))
+	assert.Equal(t, "a/b", unpacked.Topic)
+	assert.Equal(t, uint16(7), unpacked.PacketID)
+	assert.Equal(t, []byte("payload"), unpacked.Payload)
+
+	// errors carry offsets within the whole frame
+	f.Body = f.Body[:1]
+	_, err = f.Decode(MQTTv311)
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, "Topic", malformedErr.Field)
+	assert.Equal(t, 2, malformedErr.Offset)
+}
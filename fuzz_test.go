@@ -3,11 +3,14 @@ package mqttpackets
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"testing"
 
 	v5packets "github.com/eclipse/paho.golang/packets"
 	v3packets "github.com/eclipse/paho.mqtt.golang/packets"
 	fuzz "github.com/google/gofuzz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFuzzingV311(t *testing.T) {
@@ -251,6 +254,7 @@ func fuzzV311Packet(t *testing.T, fuzzer *fuzz.Fuzzer, packetType byte) {
 	if !bytes.Equal(originalData.Bytes(), newData.Bytes()) {
 		t.Errorf("expected:\n\n%s\ngot:\n\n%s", hex.Dump(originalData.Bytes()), hex.Dump(newData.Bytes()))
 	}
+	checkAppendTo(t, newPacket, originalData.Bytes())
 }
 
 func TestFuzzingV5(t *testing.T) {
@@ -355,11 +359,36 @@ func TestFuzzingV5(t *testing.T) {
 				},
 			),
 		},
+		{
+			packetType: v5packets.SUBSCRIBE,
+			fuzzer: fuzz.New().Funcs(
+				func(p *v5packets.Subscribe, c fuzz.Continue) {
+					p.PacketID = uint16(c.RandUint64())
+					p.Subscriptions = make(map[string]v5packets.SubOptions)
+					for i := c.Intn(10); i >= 0; i-- {
+						p.Subscriptions[c.RandString()] = v5packets.SubOptions{
+							QoS:               byte(c.Intn(3)),
+							NoLocal:           c.RandBool(),
+							RetainAsPublished: c.RandBool(),
+							// paho.golang expects retain handling already shifted
+							RetainHandling: byte(c.Intn(3)) << 4,
+						}
+					}
+
+					p.Properties = &v5packets.Properties{}
+					if c.RandBool() {
+						id := c.Intn(268435455) + 1
+						p.Properties.SubscriptionIdentifier = &id
+					}
+					userProperties(p.Properties, c)
+				},
+			),
+		},
 	}
 
 	for _, tc := range tests {
 		tc := tc
-		packet := NewControlPacket(tc.packetType, MQTTv5)
+		packet := NewControlPacket(PacketType(tc.packetType), MQTTv5)
 
 		t.Run(packet.PacketType(), func(t *testing.T) {
 			for i := 0; i < 100000; i++ {
@@ -392,6 +421,68 @@ func fuzzV5Packet(t *testing.T, fuzzer *fuzz.Fuzzer, packetType byte) {
 	if !bytes.Equal(originalData.Bytes(), newData.Bytes()) {
 		t.Errorf("expected:\n\n%s\ngot:\n\n%s", hex.Dump(originalData.Bytes()), hex.Dump(newData.Bytes()))
 	}
+	checkAppendTo(t, newPacket, originalData.Bytes())
+}
+
+// checkAppendTo checks that Size and AppendTo agree with the encoded frame
+func checkAppendTo(t *testing.T, cp *ControlPacket, frame []byte) {
+	if size := cp.Size(); size != len(frame) {
+		t.Errorf("expected size %d, got %d", len(frame), size)
+	}
+	appended, err := cp.AppendTo(nil)
+	if err != nil {
+		t.Errorf("packet append fail: %s", err)
+		return
+	}
+	if !bytes.Equal(frame, appended) {
+		t.Errorf("expected:\n\n%s\nappended:\n\n%s", hex.Dump(frame), hex.Dump(appended))
+	}
+}
+
+func TestSubscribeOptionsV5(t *testing.T) {
+	// QoS and retain handling 3 are malformed
+	for qos := byte(0); qos < 4; qos++ {
+		for retainHandling := byte(0); retainHandling < 4; retainHandling++ {
+			for _, noLocal := range []bool{false, true} {
+				for _, retainAsPublished := range []bool{false, true} {
+					options := v5packets.SubOptions{
+						QoS:               qos,
+						NoLocal:           noLocal,
+						RetainAsPublished: retainAsPublished,
+						RetainHandling:    retainHandling << 4,
+					}
+					packet := v5packets.NewControlPacket(v5packets.SUBSCRIBE)
+					packet.Content.(*v5packets.Subscribe).PacketID = 1
+					packet.Content.(*v5packets.Subscribe).Subscriptions["a/b"] = options
+
+					originalData := bytes.NewBuffer(nil)
+					_, err := packet.WriteTo(originalData)
+					require.NoError(t, err)
+
+					newPacket, err := ReadPacket(bytes.NewReader(originalData.Bytes()), MQTTv5)
+					if qos == 3 || retainHandling == 3 {
+						var malformedErr *MalformedPacketError
+						require.True(t, errors.As(err, &malformedErr), "%v", err)
+						assert.Equal(t, "Subscriptions[0].Options", malformedErr.Field)
+						continue
+					}
+					require.NoError(t, err)
+					assert.Equal(t, []Subscription{{
+						Topic:             "a/b",
+						QoS:               qos,
+						RetainHandling:    retainHandling,
+						NoLocal:           noLocal,
+						RetainAsPublished: retainAsPublished,
+					}}, newPacket.Content.(*Subscribe).Subscriptions)
+
+					newData := bytes.NewBuffer(nil)
+					_, err = newPacket.WriteTo(newData)
+					require.NoError(t, err)
+					assert.Equal(t, originalData.Bytes(), newData.Bytes())
+				}
+			}
+		}
+	}
 }
 
 func userProperties(properties *v5packets.Properties, c fuzz.Continue) {
@@ -3,8 +3,14 @@ module github.com/matevzmihalic/mqttpackets
 go 1.17
 
 require (
-	github.com/eclipse/paho.golang v0.9.1-0.20210429124907-6f81099163c2 // indirect
+	github.com/eclipse/paho.golang v0.9.1-0.20210429124907-6f81099163c2
 	github.com/eclipse/paho.mqtt.golang v1.3.3
 	github.com/google/gofuzz v1.2.0
 	github.com/stretchr/testify v1.7.0
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
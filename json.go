@@ -0,0 +1,135 @@
+package mqttpackets
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MarshalJSON implements json.Marshaler. A packet is encoded as a JSON object
+// holding its type name under "type" and the fields of its content, e.g.
+//
+//	{"type":"PUBLISH","payload":"aGk=","topic":"a/b","properties":{"messageExpiry":60},"packetID":42,"qos":1}
+//
+// The field names are the names of the Go fields starting with a lower case
+// letter, only qos is written in lower case entirely. Fields with zero
+// values are left out. Binary fields like payload, password and
+// correlationData are base64 strings and reason codes are numbers. As in
+// the Go structs, a "properties" object, even an empty one, marks a packet as
+// MQTT 5 and its absence as an older version. Unlike String, the JSON holds
+// the password and authentication data.
+func (c *ControlPacket) MarshalJSON() ([]byte, error) {
+	if !c.Type.IsValid() || c.Content == nil {
+		return nil, &EncodeError{PacketType: c.Type, Field: "PacketType", Err: ErrInvalidPacketType}
+	}
+
+	header, err := json.Marshal(struct {
+		Type PacketType `json:"type"`
+	}{c.Type})
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.Marshal(c.Content)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == len("{}") {
+		return header, nil
+	}
+
+	// merge the objects by replacing the closing brace of the header
+	return append(append(header[:len(header)-1], ','), content[1:]...), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler for the format written by
+// MarshalJSON and replaces c with the decoded packet. The content starts out
+// like NewControlPacket creates it for MQTTv311, so a CONNECT without a
+// protocolVersion is a v3.1.1 CONNECT. The flags of the fixed header are the
+// ones required by the packet type or, for PUBLISH, taken from the content.
+func (c *ControlPacket) UnmarshalJSON(data []byte) error {
+	var header struct {
+		Type *PacketType `json:"type"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	if header.Type == nil {
+		return fmt.Errorf("%w: missing type", ErrInvalidPacketType)
+	}
+
+	cp := NewControlPacket(*header.Type, MQTTv311)
+	if err := json.Unmarshal(data, cp.Content); err != nil {
+		return err
+	}
+	if p, ok := cp.Content.(*Publish); ok {
+		cp.Flags = p.flags()
+	}
+
+	*c = *cp
+	return nil
+}
+
+// reasonCodeList encodes reason codes as a JSON array of numbers instead of
+// the base64 string used for byte slices
+type reasonCodeList []byte
+
+func (r reasonCodeList) MarshalJSON() ([]byte, error) {
+	codes := make([]int, len(r))
+	for i, code := range r {
+		codes[i] = int(code)
+	}
+	return json.Marshal(codes)
+}
+
+// MarshalJSON implements json.Marshaler, the reason codes are encoded as
+// numbers
+func (s *Suback) MarshalJSON() ([]byte, error) {
+	type suback Suback
+	return json.Marshal(struct {
+		suback
+		Reasons reasonCodeList `json:"reasons,omitempty"`
+	}{suback(*s), s.Reasons})
+}
+
+// UnmarshalJSON implements json.Unmarshaler for the format written by
+// MarshalJSON
+func (s *Suback) UnmarshalJSON(data []byte) error {
+	type suback Suback
+	v := struct {
+		suback
+		Reasons reasonCodeList `json:"reasons,omitempty"`
+	}{suback(*s), s.Reasons}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*s = Suback(v.suback)
+	s.Reasons = v.Reasons
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, the reason codes are encoded as
+// numbers
+func (u *Unsuback) MarshalJSON() ([]byte, error) {
+	type unsuback Unsuback
+	return json.Marshal(struct {
+		unsuback
+		Reasons reasonCodeList `json:"reasons,omitempty"`
+	}{unsuback(*u), u.Reasons})
+}
+
+// UnmarshalJSON implements json.Unmarshaler for the format written by
+// MarshalJSON
+func (u *Unsuback) UnmarshalJSON(data []byte) error {
+	type unsuback Unsuback
+	v := struct {
+		unsuback
+		Reasons reasonCodeList `json:"reasons,omitempty"`
+	}{unsuback(*u), u.Reasons}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*u = Unsuback(v.unsuback)
+	u.Reasons = v.Reasons
+	return nil
+}
@@ -0,0 +1,168 @@
+package mqttpackets
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	packets := benchmarkPackets()
+	for _, cp := range benchmarkPackets()[:AUTH-1] {
+		translated, err := Translate(cp, MQTTv5, MQTTv311)
+		require.NoError(t, err)
+		packets = append(packets, translated)
+	}
+
+	for _, cp := range packets {
+		data, err := json.Marshal(cp)
+		require.NoError(t, err)
+
+		var decoded ControlPacket
+		require.NoError(t, json.Unmarshal(data, &decoded), string(data))
+		assert.Equal(t, cp.Type, decoded.Type)
+		assert.Equal(t, cp.Content, decoded.Content, string(data))
+
+		// the decoded packet encodes to the same frame
+		want, err := cp.AppendTo(nil)
+		require.NoError(t, err)
+		got, err := decoded.AppendTo(nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestUnmarshalJSONHandAuthored(t *testing.T) {
+	var cp ControlPacket
+	err := json.Unmarshal([]byte(`{
+		"type": "PUBLISH",
+		"topic": "a/b",
+		"qos": 1,
+		"packetID": 42,
+		"retain": true,
+		"payload": "aGk=",
+		"properties": {"messageExpiry": 60, "user": [{"key": "k", "value": "v"}]}
+	}`), &cp)
+	require.NoError(t, err)
+
+	expiry := uint32(60)
+	assert.Equal(t, PUBLISH, cp.Type)
+	assert.Equal(t, byte(0x3), cp.Flags)
+	assert.Equal(t, &Publish{
+		Topic:    "a/b",
+		QoS:      1,
+		PacketID: 42,
+		Retain:   true,
+		Payload:  []byte("hi"),
+		Properties: &Properties{
+			MessageExpiry: &expiry,
+			User:          []User{{Key: "k", Value: "v"}},
+		},
+	}, cp.Content)
+
+	// without properties the packet is a v3 packet with the flags of its type
+	require.NoError(t, json.Unmarshal([]byte(`{"type":"PUBREL","packetID":1}`), &cp))
+	assert.Equal(t, byte(2), cp.Flags)
+	assert.Equal(t, &Pubrel{PacketID: 1}, cp.Content)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"type":"CONNECT","clientID":"c"}`), &cp))
+	assert.Equal(t, &Connect{ProtocolName: "MQTT", ProtocolVersion: MQTTv311, ClientID: "c"}, cp.Content)
+}
+
+func TestJSONSchema(t *testing.T) {
+	b, u16, u32, id := byte(1), uint16(2), uint32(3), 4
+	props := &Properties{
+		PayloadFormat:          &b,
+		MessageExpiry:          &u32,
+		ContentType:            "text/plain",
+		ResponseTopic:          "response",
+		CorrelationData:        []byte{1},
+		SubscriptionIdentifier: &id,
+		SessionExpiryInterval:  &u32,
+		AssignedClientID:       "client",
+		ServerKeepAlive:        &u16,
+		AuthMethod:             "method",
+		AuthData:               []byte{2},
+		RequestProblemInfo:     &b,
+		WillDelayInterval:      &u32,
+		RequestResponseInfo:    &b,
+		ResponseInfo:           "info",
+		ServerReference:        "server",
+		ReasonString:           "reason",
+		ReceiveMaximum:         &u16,
+		TopicAliasMaximum:      &u16,
+		TopicAlias:             &u16,
+		MaximumQOS:             &b,
+		RetainAvailable:        &b,
+		User:                   []User{{Key: "k", Value: "v"}},
+		MaximumPacketSize:      &u32,
+		WildcardSubAvailable:   &b,
+		SubIDAvailable:         &b,
+		SharedSubAvailable:     &b,
+	}
+	data, err := json.Marshal(props)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"payloadFormat": 1,
+		"messageExpiry": 3,
+		"contentType": "text/plain",
+		"responseTopic": "response",
+		"correlationData": "AQ==",
+		"subscriptionIdentifier": 4,
+		"sessionExpiryInterval": 3,
+		"assignedClientID": "client",
+		"serverKeepAlive": 2,
+		"authMethod": "method",
+		"authData": "Ag==",
+		"requestProblemInfo": 1,
+		"willDelayInterval": 3,
+		"requestResponseInfo": 1,
+		"responseInfo": "info",
+		"serverReference": "server",
+		"reasonString": "reason",
+		"receiveMaximum": 2,
+		"topicAliasMaximum": 2,
+		"topicAlias": 2,
+		"maximumQOS": 1,
+		"retainAvailable": 1,
+		"user": [{"key": "k", "value": "v"}],
+		"maximumPacketSize": 3,
+		"wildcardSubAvailable": 1,
+		"subIDAvailable": 1,
+		"sharedSubAvailable": 1
+	}`, string(data))
+
+	// String uses the JSON names of the properties
+	fields := reflect.TypeOf(Properties{})
+	for _, name := range propertyNames {
+		f, ok := fields.FieldByName(name)
+		require.True(t, ok, name)
+		assert.Equal(t, jsonName(name)+",omitempty", f.Tag.Get("json"))
+	}
+
+	cp := NewControlPacket(SUBACK, MQTTv311)
+	cp.Content.(*Suback).PacketID = 1
+	cp.Content.(*Suback).Reasons = []byte{0, 0x80}
+	data, err = json.Marshal(cp)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"SUBACK","packetID":1,"reasons":[0,128]}`, string(data))
+}
+
+func TestJSONErrors(t *testing.T) {
+	var cp ControlPacket
+	err := json.Unmarshal([]byte(`{"topic":"a"}`), &cp)
+	assert.True(t, errors.Is(err, ErrInvalidPacketType))
+
+	err = json.Unmarshal([]byte(`{"type":"PUBLISHED"}`), &cp)
+	assert.True(t, errors.Is(err, ErrInvalidPacketType))
+
+	err = json.Unmarshal([]byte(`{"type":"SUBACK","reasons":[256]}`), &cp)
+	assert.Error(t, err)
+
+	_, err = json.Marshal(&ControlPacket{})
+	assert.True(t, errors.Is(err, ErrInvalidPacketType))
+}
@@ -0,0 +1,75 @@
+package mqttpackets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Severity is the severity of a lint finding
+type Severity byte
+
+// SeverityError marks a breach of a normative statement that makes a receiver
+// reject the packet, SeverityWarning marks a packet that is allowed but is
+// likely to cause interoperability problems.
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	}
+	return fmt.Sprintf("severity %d", s)
+}
+
+// Finding is a single conformance problem found by Lint
+type Finding struct {
+	// Severity is the severity of the finding
+	Severity Severity
+	// Rule is the spec statement ID or section, e.g. MQTT-3.3.1-4 or §3.1
+	Rule string
+	// Field is the name of the offending field, e.g. Subscriptions[1].Topic
+	Field string
+	// Offset is the byte offset of the field within the encoded frame,
+	// starting at the fixed header
+	Offset int
+	// Message describes the problem
+	Message string
+	// ReasonCode is the v5 reason code a receiver would respond with, it
+	// is 0 for warnings
+	ReasonCode byte
+
+	malformed bool
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s at offset %d: %s: %s [%s]", f.Severity, f.Offset, f.Field, f.Message, f.Rule)
+}
+
+// error converts the finding to a MalformedPacketError or ProtocolError
+func (f Finding) error(t PacketType) error {
+	if f.malformed {
+		e := malformed(t, f.Field, 0, errors.New(f.Message))
+		e.Rule = f.Rule
+		e.Offset = f.Offset
+		return e
+	}
+
+	e := protocolError(t, f.Field, f.ReasonCode, 0, errors.New(f.Message))
+	e.Rule = f.Rule
+	e.Offset = f.Offset
+	return e
+}
+
+// Lint walks the fixed header, variable header, properties and payload of
+// the packet and returns every conformance problem found for version v, in
+// wire order. Unlike Validate it does not stop at the first error and also
+// reports warnings. Offsets refer to the frame the packet encodes to. As
+// with Validate, v can be 0 for CONNECT packets.
+func Lint(cp *ControlPacket, v Version) []Finding {
+	return validatePacket(cp, v)
+}
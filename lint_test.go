@@ -0,0 +1,170 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lintFrame encodes cp without the checks of Encode
+func lintFrame(cp *ControlPacket) []byte {
+	body := bytes.Join(cp.Content.Buffers(), nil)
+	frame := append([]byte{byte(cp.Type)<<4 | cp.Flags}, encodeVBI(len(body))...)
+	return append(frame, body...)
+}
+
+func findingFor(findings []Finding, field string) (Finding, bool) {
+	for _, f := range findings {
+		if f.Field == field {
+			return f, true
+		}
+	}
+	return Finding{}, false
+}
+
+func TestLintCollectsAllFindings(t *testing.T) {
+	cp := NewControlPacket(CONNECT, MQTTv31)
+	c := cp.Content.(*Connect)
+	c.ClientID = strings.Repeat("c", 30)
+	c.WillFlag = true
+	c.WillTopic = "/a/#"
+	c.WillMessage = []byte("bye")
+	c.UsernameFlag = true
+	c.Username = "a-very-long-user"
+
+	findings := Lint(cp, MQTTv31)
+	require.Len(t, findings, 4)
+
+	// fixed header 2, protocol name 8, version, flags, keep alive 4
+	assert.Equal(t, Finding{Severity: SeverityWarning, Rule: "§3.1", Field: "ClientID", Offset: 14,
+		Message: "client ID is longer than 23 characters"}, findings[0])
+
+	assert.Equal(t, SeverityError, findings[1].Severity)
+	assert.Equal(t, "WillTopic", findings[1].Field)
+	assert.Equal(t, "MQTT-3.3.2-2", findings[1].Rule)
+	assert.Equal(t, 46, findings[1].Offset)
+
+	assert.Equal(t, SeverityWarning, findings[2].Severity)
+	assert.Equal(t, "topic contains leading slash", findings[2].Message)
+	assert.Equal(t, 46, findings[2].Offset)
+
+	assert.Equal(t, "Username", findings[3].Field)
+	assert.Equal(t, 57, findings[3].Offset)
+
+	frame := lintFrame(cp)
+	assert.Equal(t, []byte{0, 30, 'c'}, frame[14:17])
+	assert.Equal(t, []byte{0, 4, '/'}, frame[46:49])
+	assert.Equal(t, []byte{0, 16, 'a'}, frame[57:60])
+
+	err := cp.Validate(MQTTv31)
+	require.Error(t, err)
+	assert.Equal(t, "MQTT-3.3.2-2", err.(*ProtocolError).Rule)
+}
+
+func TestLintWarningsDoNotFailValidate(t *testing.T) {
+	cp := NewControlPacket(CONNECT, MQTTv311)
+	cp.Content.(*Connect).ClientID = "client-with-dashes"
+
+	findings := Lint(cp, MQTTv311)
+	require.Len(t, findings, 1)
+	assert.Equal(t, SeverityWarning, findings[0].Severity)
+	assert.Equal(t, "MQTT-3.1.3-5", findings[0].Rule)
+	assert.Nil(t, cp.Validate(MQTTv311))
+}
+
+func TestLintOffsets(t *testing.T) {
+	cp := NewControlPacket(SUBSCRIBE, MQTTv5)
+	s := cp.Content.(*Subscribe)
+	s.PacketID = 7
+	s.Properties.User = []User{{Key: "k", Value: "v\x01"}}
+	s.Subscriptions = []Subscription{{Topic: "a/b", QoS: 1}, {Topic: "a/#/b", QoS: 3}}
+
+	findings := Lint(cp, MQTTv5)
+	frame := lintFrame(cp)
+
+	f, ok := findingFor(findings, "Properties.User[0].Value")
+	require.True(t, ok)
+	assert.Equal(t, SeverityWarning, f.Severity)
+	assert.Equal(t, PropUser, frame[f.Offset])
+
+	f, ok = findingFor(findings, "Subscriptions[1].Topic")
+	require.True(t, ok)
+	assert.Equal(t, "MQTT-4.7.1-1", f.Rule)
+	topic, err := readString(bytes.NewBuffer(frame[f.Offset:]))
+	require.NoError(t, err)
+	assert.Equal(t, "a/#/b", topic)
+
+	f, ok = findingFor(findings, "Subscriptions[1].QoS")
+	require.True(t, ok)
+	assert.Equal(t, byte(3), frame[f.Offset])
+	assert.Equal(t, len(frame)-1, f.Offset)
+}
+
+func TestLintPublish(t *testing.T) {
+	cp := NewControlPacket(PUBLISH, MQTTv5)
+	p := cp.Content.(*Publish)
+	p.Topic = "$SYS/x"
+	p.PacketID = 3
+	p.Payload = []byte{0xff}
+	format := byte(1)
+	alias := uint16(0)
+	p.Properties.PayloadFormat = &format
+	p.Properties.TopicAlias = &alias
+	p.Properties.SessionExpiryInterval = new(uint32)
+
+	findings := Lint(cp, MQTTv5)
+	frame := lintFrame(cp)
+
+	var fields []string
+	for _, f := range findings {
+		fields = append(fields, f.Severity.String()+" "+f.Field)
+	}
+	assert.Equal(t, []string{
+		"warning Topic",
+		"warning PacketID",
+		"error Properties.TopicAlias",
+		"error Properties.SessionExpiryInterval",
+		"warning Payload",
+	}, fields)
+
+	f, _ := findingFor(findings, "Properties.TopicAlias")
+	assert.Equal(t, byte(0x94), f.ReasonCode)
+	assert.Equal(t, PropTopicAlias, frame[f.Offset])
+	// the invalid property is left out of the frame, so it is reported
+	// where it would have been written
+	expiry, _ := findingFor(findings, "Properties.SessionExpiryInterval")
+	assert.Equal(t, f.Offset+3, expiry.Offset)
+	f, _ = findingFor(findings, "Payload")
+	assert.Equal(t, len(frame)-1, f.Offset)
+}
+
+func TestLintInvalidPropertyOffsets(t *testing.T) {
+	cp := NewControlPacket(PUBLISH, MQTTv5)
+	p := cp.Content.(*Publish)
+	p.Topic = "a"
+	p.Payload = []byte{1}
+	p.Properties.SessionExpiryInterval = new(uint32)
+
+	frame := lintFrame(cp)
+	require.Len(t, frame, 7)
+	for _, f := range Lint(cp, MQTTv5) {
+		assert.Less(t, f.Offset, len(frame), f.Field)
+	}
+	f, ok := findingFor(Lint(cp, MQTTv5), "Properties.SessionExpiryInterval")
+	require.True(t, ok)
+	assert.Equal(t, 6, f.Offset)
+}
+
+func TestLintPropertiesBeforeV5(t *testing.T) {
+	cp := NewControlPacket(PUBACK, MQTTv311)
+	cp.Content.(*Puback).PacketID = 1
+	cp.Content.(*Puback).Properties = &Properties{}
+
+	findings := Lint(cp, MQTTv311)
+	require.Len(t, findings, 1)
+	assert.Equal(t, SeverityError, findings[0].Severity)
+	assert.Equal(t, 5, findings[0].Offset)
+}
@@ -2,19 +2,16 @@ package mqttpackets
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 )
 
-// PacketType is a type alias to byte representing the different
-// MQTT control packet types
-// type PacketType byte
-
 // The following consts are the packet type number for each of the
 // different control packets in MQTT
 const (
-	_ byte = iota
+	_ PacketType = iota
 	CONNECT
 	CONNACK
 	PUBLISH
@@ -43,7 +40,7 @@ type (
 	// FixedHeader is the definition of a control packet fixed header
 	FixedHeader struct {
 		remainingLength int
-		Type            byte
+		Type            PacketType
 		Flags           byte
 	}
 
@@ -51,6 +48,8 @@ type (
 	ControlPacket struct {
 		Content Packet
 		FixedHeader
+		// body is the pooled buffer a PacketReader unpacked the content from
+		body *bytes.Buffer
 	}
 
 	Version byte
@@ -62,6 +61,18 @@ const (
 	MQTTv5   Version = 5
 )
 
+const (
+	// maxVBILen is the maximum number of bytes in a variable byte integer
+	maxVBILen = 4
+	// maxRemainingLength is the largest value a remaining length can encode
+	maxRemainingLength = 268435455
+	// maxPreallocSize is the largest packet body that is allocated up front,
+	// bigger bodies grow as their data is received
+	maxPreallocSize = 64 * 1024
+)
+
+var errMalformedVBI = errors.New("malformed variable byte integer")
+
 // WriteTo operates on a FixedHeader and takes the option values and produces
 // the wire format byte that represents these.
 func (f *FixedHeader) WriteTo(w io.Writer) (int64, error) {
@@ -102,32 +113,60 @@ func (c *ControlPacket) PacketID() uint16 {
 	}
 }
 
+// ReasonCodes is a helper function that returns the reason codes of the
+// packet in the Content element as v5 reason codes, v3 CONNACK and SUBACK
+// return codes are converted. It returns one code per subscription for
+// SUBACK and UNSUBACK and nil for packets without reason codes.
+func (c *ControlPacket) ReasonCodes() []ReasonCode {
+	switch r := c.Content.(type) {
+	case *Connack:
+		if r.Properties == nil {
+			return []ReasonCode{ConnackReasonCode(r.ReasonCode)}
+		}
+		return []ReasonCode{ReasonCode(r.ReasonCode)}
+	case *Puback:
+		return []ReasonCode{ReasonCode(r.ReasonCode)}
+	case *Pubrec:
+		return []ReasonCode{ReasonCode(r.ReasonCode)}
+	case *Pubrel:
+		return []ReasonCode{ReasonCode(r.ReasonCode)}
+	case *Pubcomp:
+		return []ReasonCode{ReasonCode(r.ReasonCode)}
+	case *Suback:
+		codes := make([]ReasonCode, len(r.Reasons))
+		for i, code := range r.Reasons {
+			if r.Properties == nil {
+				codes[i] = SubackReasonCode(code)
+			} else {
+				codes[i] = ReasonCode(code)
+			}
+		}
+		return codes
+	case *Unsuback:
+		codes := make([]ReasonCode, len(r.Reasons))
+		for i, code := range r.Reasons {
+			codes[i] = ReasonCode(code)
+		}
+		return codes
+	case *Disconnect:
+		return []ReasonCode{ReasonCode(r.ReasonCode)}
+	case *Auth:
+		return []ReasonCode{ReasonCode(r.ReasonCode)}
+	default:
+		return nil
+	}
+}
+
+// PacketType returns the name of the type of the packet, e.g. PUBLISH
 func (c *ControlPacket) PacketType() string {
-	return [...]string{
-		"",
-		"CONNECT",
-		"CONNACK",
-		"PUBLISH",
-		"PUBACK",
-		"PUBREC",
-		"PUBREL",
-		"PUBCOMP",
-		"SUBSCRIBE",
-		"SUBACK",
-		"UNSUBSCRIBE",
-		"UNSUBACK",
-		"PINGREQ",
-		"PINGRESP",
-		"DISCONNECT",
-		"AUTH",
-	}[c.FixedHeader.Type]
+	return c.Type.String()
 }
 
 // NewControlPacket takes a packetType and returns a pointer to a
 // ControlPacket where the VariableHeader field is a pointer to an
 // instance of a VariableHeader definition for that packetType
 // Packet will be created as v3 if Version is not set correctly.
-func NewControlPacket(t byte, v Version) *ControlPacket {
+func NewControlPacket(t PacketType, v Version) *ControlPacket {
 	cp := &ControlPacket{FixedHeader: FixedHeader{Type: t}}
 	switch t {
 	case CONNECT:
@@ -216,7 +255,6 @@ func NewControlPacket(t byte, v Version) *ControlPacket {
 		}
 		cp.Content = content
 	case AUTH:
-		cp.Flags = 1
 		content := &Auth{}
 		if v == MQTTv5 {
 			content.Properties = &Properties{}
@@ -234,33 +272,62 @@ func NewControlPacket(t byte, v Version) *ControlPacket {
 // Version can be set to 0 when reading a Connect packet.
 // Packet will be parsed as v3 if Version is not set correctly when reading other types of packets.
 func ReadPacket(r io.Reader, v Version) (*ControlPacket, error) {
-	t := [1]byte{}
-	_, err := io.ReadFull(r, t[:])
+	return ReadPacketWithOptions(r, v, nil)
+}
+
+// ReadPacketWithOptions works like ReadPacket but rejects packets exceeding
+// the limits set in opts. The size of a packet is checked before its body is
+// read, so a hostile remaining length does not cause a large allocation.
+func ReadPacketWithOptions(r io.Reader, v Version, opts *ReaderOptions) (*ControlPacket, error) {
+	cp, headerLen, err := readFixedHeader(r, v, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	pt := t[0] >> 4
-	cp := NewControlPacket(pt, v)
-	if cp == nil {
-		return nil, fmt.Errorf("invalid packet type requested, %d", pt)
+	return readContent(r, cp, headerLen, v, opts)
+}
+
+// readFixedHeader reads the fixed header of a packet and returns the empty
+// packet together with the length of the header.
+func readFixedHeader(r io.Reader, v Version, opts *ReaderOptions) (*ControlPacket, int, error) {
+	t := [1]byte{}
+	_, err := io.ReadFull(r, t[:])
+	if err != nil {
+		return nil, 0, err
 	}
 
-	cp.Flags = t[0] & 0xF
-	if cp.Type == PUBLISH {
-		cp.Content.(*Publish).QoS = (cp.Flags & 0x6) >> 1
+	cp, err := newPacketFromHeader(t[0], v)
+	if err != nil {
+		return nil, 0, err
 	}
+
 	vbi, err := getVBI(r)
+	if err == errMalformedVBI {
+		return nil, 0, remainingLengthError(cp.Type, err)
+	}
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
+	headerLen := 1 + vbi.Len()
 	cp.remainingLength, err = decodeVBI(vbi)
 	if err != nil {
-		return nil, err
+		return nil, 0, remainingLengthError(cp.Type, err)
+	}
+
+	if err = opts.checkSize(cp.Type, headerLen+cp.remainingLength); err != nil {
+		return nil, 0, err
 	}
 
+	return cp, headerLen, nil
+}
+
+// readContent reads the body of a packet whose fixed header was read and
+// unpacks it.
+func readContent(r io.Reader, cp *ControlPacket, headerLen int, v Version, opts *ReaderOptions) (*ControlPacket, error) {
 	var content bytes.Buffer
-	content.Grow(cp.remainingLength)
+	if cp.remainingLength <= maxPreallocSize {
+		content.Grow(cp.remainingLength)
+	}
 
 	n, err := io.CopyN(&content, r, int64(cp.remainingLength))
 	if err != nil {
@@ -270,28 +337,115 @@ func ReadPacket(r io.Reader, v Version) (*ControlPacket, error) {
 	if n != int64(cp.remainingLength) {
 		return nil, fmt.Errorf("failed to read packet, expected %d bytes, read %d", cp.remainingLength, n)
 	}
-	err = cp.Content.Unpack(&content)
+	err = opts.unpack(cp, &content)
 	if err != nil {
+		return nil, setErrorOffset(err, headerLen+cp.remainingLength)
+	}
+	if err = opts.checkPacket(cp, v); err != nil {
 		return nil, err
 	}
 	return cp, nil
 }
 
-// WriteTo writes a packet to an io.Writer, handling packing all the parts of
-// a control packet.
-func (c *ControlPacket) WriteTo(w io.Writer) (int64, error) {
-	c.remainingLength = 0
-	buffers := c.Content.Buffers()
-	for _, b := range buffers {
-		c.remainingLength += len(b)
+// DecodePacket decodes a single control packet from the start of frame and
+// returns it together with the number of bytes consumed.
+// Unlike ReadPacket it does not copy the packet body, so binary fields such as
+// Publish.Payload, CorrelationData and AuthData alias frame and are only valid
+// for as long as frame is not modified.
+// io.ErrUnexpectedEOF is returned if frame does not hold a complete packet.
+func DecodePacket(frame []byte, v Version) (*ControlPacket, int, error) {
+	return DecodePacketWithOptions(frame, v, nil)
+}
+
+// DecodePacketWithOptions works like DecodePacket but rejects packets
+// exceeding the limits set in opts.
+func DecodePacketWithOptions(frame []byte, v Version, opts *ReaderOptions) (*ControlPacket, int, error) {
+	buf := bytes.NewBuffer(frame)
+	header, err := buf.ReadByte()
+	if err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
 	}
 
-	var header bytes.Buffer
-	if _, err := c.FixedHeader.WriteTo(&header); err != nil {
-		return 0, err
+	cp, err := newPacketFromHeader(header, v)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	vbi, err := getVBI(buf)
+	if err == io.EOF {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, 0, remainingLengthError(cp.Type, err)
 	}
+	cp.remainingLength, err = decodeVBI(vbi)
+	if err != nil {
+		return nil, 0, remainingLengthError(cp.Type, err)
+	}
+
+	headerLen := len(frame) - buf.Len()
+	if err = opts.checkSize(cp.Type, headerLen+cp.remainingLength); err != nil {
+		return nil, 0, err
+	}
+
+	if buf.Len() < cp.remainingLength {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	err = opts.unpack(cp, bytes.NewBuffer(buf.Next(cp.remainingLength)))
+	if err != nil {
+		return nil, 0, setErrorOffset(err, headerLen+cp.remainingLength)
+	}
+	if err = opts.checkPacket(cp, v); err != nil {
+		return nil, 0, err
+	}
+	return cp, len(frame) - buf.Len(), nil
+}
 
-	buffers = append(net.Buffers{header.Bytes()}, buffers...)
+// remainingLengthError wraps an error decoding the remaining length of
+// a packet, which always starts at offset 1.
+func remainingLengthError(t PacketType, err error) error {
+	return &MalformedPacketError{
+		PacketType: t,
+		Field:      "RemainingLength",
+		Offset:     1,
+		ReasonCode: reasonMalformedPacket,
+		Err:        err,
+	}
+}
+
+// newPacketFromHeader creates an empty control packet from the first byte of
+// the fixed header.
+func newPacketFromHeader(header byte, v Version) (*ControlPacket, error) {
+	pt := PacketType(header >> 4)
+	cp := NewControlPacket(pt, v)
+	if cp == nil {
+		return nil, &MalformedPacketError{
+			PacketType: pt,
+			Field:      "PacketType",
+			ReasonCode: reasonMalformedPacket,
+			Err:        fmt.Errorf("invalid packet type requested, %d", pt),
+		}
+	}
+
+	cp.Flags = header & 0xF
+	if cp.Type == PUBLISH {
+		p := cp.Content.(*Publish)
+		p.QoS = (cp.Flags & 0x6) >> 1
+		p.Duplicate = cp.Flags&0x8 > 0
+		p.Retain = cp.Flags&0x1 > 0
+	}
+
+	return cp, nil
+}
+
+// WriteTo writes a packet to an io.Writer, handling packing all the parts of
+// a control packet. Nothing is written if Encode fails.
+func (c *ControlPacket) WriteTo(w io.Writer) (int64, error) {
+	buffers, err := c.Encode()
+	if err != nil {
+		return 0, err
+	}
 
 	return buffers.WriteTo(w)
 }
@@ -313,6 +467,21 @@ func encodeVBI(length int) []byte {
 	}
 }
 
+// vbiLen returns the number of bytes needed to encode length as a variable
+// byte integer
+func vbiLen(length int) int {
+	switch {
+	case length < 128:
+		return 1
+	case length < 16384:
+		return 2
+	case length < 2097152:
+		return 3
+	default:
+		return 4
+	}
+}
+
 func encodeVBIdirect(length int, buf *bytes.Buffer) {
 	var x int
 	b := [4]byte{}
@@ -343,6 +512,9 @@ func getVBI(r io.Reader) (*bytes.Buffer, error) {
 		if digit[0] <= 0x7f {
 			return &ret, nil
 		}
+		if ret.Len() == maxVBILen {
+			return nil, errMalformedVBI
+		}
 	}
 }
 
@@ -359,6 +531,9 @@ func decodeVBI(r *bytes.Buffer) (int, error) {
 			break
 		}
 		multiplier += 7
+		if multiplier == 7*maxVBILen {
+			return 0, errMalformedVBI
+		}
 	}
 	return int(vbi), nil
 }
@@ -370,19 +545,6 @@ func writeUint16(u uint16, b *bytes.Buffer) error {
 	return b.WriteByte(byte(u))
 }
 
-func writeUint32(u uint32, b *bytes.Buffer) error {
-	if err := b.WriteByte(byte(u >> 24)); err != nil {
-		return err
-	}
-	if err := b.WriteByte(byte(u >> 16)); err != nil {
-		return err
-	}
-	if err := b.WriteByte(byte(u >> 8)); err != nil {
-		return err
-	}
-	return b.WriteByte(byte(u))
-}
-
 func writeString(s string, b *bytes.Buffer) {
 	writeUint16(uint16(len(s)), b)
 	b.WriteString(s)
@@ -393,51 +555,81 @@ func writeBinary(d []byte, b *bytes.Buffer) {
 	b.Write(d)
 }
 
-func readUint16(b *bytes.Buffer) (uint16, error) {
-	b1, err := b.ReadByte()
-	if err != nil {
-		return 0, err
+// appendVBI appends length to dst as a variable byte integer
+func appendVBI(dst []byte, length int) []byte {
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		dst = append(dst, digit)
+		if length == 0 {
+			return dst
+		}
 	}
-	b2, err := b.ReadByte()
-	if err != nil {
-		return 0, err
+}
+
+func appendUint16(dst []byte, u uint16) []byte {
+	return append(dst, byte(u>>8), byte(u))
+}
+
+func appendUint32(dst []byte, u uint32) []byte {
+	return append(dst, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
+
+func appendString(dst []byte, s string) []byte {
+	return append(appendUint16(dst, uint16(len(s))), s...)
+}
+
+func appendBinary(dst []byte, d []byte) []byte {
+	return append(appendUint16(dst, uint16(len(d))), d...)
+}
+
+// readUint16 reads a two byte integer, the buffer is left untouched if it
+// doesn't hold enough data.
+func readUint16(b *bytes.Buffer) (uint16, error) {
+	if b.Len() < 2 {
+		return 0, shortRead(b)
 	}
-	return (uint16(b1) << 8) | uint16(b2), nil
+	d := b.Next(2)
+	return (uint16(d[0]) << 8) | uint16(d[1]), nil
 }
 
+// readUint32 reads a four byte integer, the buffer is left untouched if it
+// doesn't hold enough data.
 func readUint32(b *bytes.Buffer) (uint32, error) {
-	b1, err := b.ReadByte()
-	if err != nil {
-		return 0, err
+	if b.Len() < 4 {
+		return 0, shortRead(b)
 	}
-	b2, err := b.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b3, err := b.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	b4, err := b.ReadByte()
-	if err != nil {
-		return 0, err
-	}
-	return (uint32(b1) << 24) | (uint32(b2) << 16) | (uint32(b3) << 8) | uint32(b4), nil
+	d := b.Next(4)
+	return (uint32(d[0]) << 24) | (uint32(d[1]) << 16) | (uint32(d[2]) << 8) | uint32(d[3]), nil
 }
 
+// readBinary reads length prefixed binary data. The returned slice aliases
+// the buffer contents instead of copying them. The buffer is left untouched
+// if it doesn't hold the whole field.
 func readBinary(b *bytes.Buffer) ([]byte, error) {
-	size, err := readUint16(b)
-	if err != nil {
-		return nil, err
+	if b.Len() < 2 {
+		return nil, shortRead(b)
 	}
-
-	var s bytes.Buffer
-	s.Grow(int(size))
-	if _, err := io.CopyN(&s, b, int64(size)); err != nil {
-		return nil, err
+	d := b.Bytes()
+	size := int(d[0])<<8 | int(d[1])
+	if len(d) < 2+size {
+		return nil, io.ErrUnexpectedEOF
 	}
 
-	return s.Bytes(), nil
+	b.Next(2)
+	return b.Next(size), nil
+}
+
+// shortRead returns io.EOF if the buffer is empty and io.ErrUnexpectedEOF if
+// it holds only part of a field.
+func shortRead(b *bytes.Buffer) error {
+	if b.Len() == 0 {
+		return io.EOF
+	}
+	return io.ErrUnexpectedEOF
 }
 
 func readString(b *bytes.Buffer) (string, error) {
@@ -3,6 +3,8 @@ package mqttpackets
 import (
 	"bufio"
 	"bytes"
+	"io"
+	"io/ioutil"
 	"reflect"
 	"testing"
 
@@ -147,7 +149,7 @@ func TestReadStringWriteString(t *testing.T) {
 func TestNewControlPacket(t *testing.T) {
 	tests := []struct {
 		name string
-		args byte
+		args PacketType
 		want *ControlPacket
 	}{
 		{
@@ -272,7 +274,7 @@ func TestNewControlPacket(t *testing.T) {
 			name: "auth",
 			args: AUTH,
 			want: &ControlPacket{
-				FixedHeader: FixedHeader{Type: AUTH, Flags: 1},
+				FixedHeader: FixedHeader{Type: AUTH},
 				Content:     &Auth{Properties: &Properties{}},
 			},
 		},
@@ -421,3 +423,313 @@ func BenchmarkPublish_Buffers(b *testing.B) {
 		pp.Buffers()
 	}
 }
+
+func TestDecodePacketConnect(t *testing.T) {
+	p := []byte{16, 38, 0, 4, 77, 81, 84, 84, 5, 128, 0, 30, 5, 17, 0, 0, 0, 30, 0, 10, 116, 101, 115, 116, 67, 108, 105, 101, 110, 116, 0, 8, 116, 101, 115, 116, 85, 115, 101, 114}
+
+	c, n, err := DecodePacket(p, MQTTv5)
+
+	require.Nil(t, err)
+	assert.Equal(t, len(p), n)
+	assert.Equal(t, uint16(30), c.Content.(*Connect).KeepAlive)
+	assert.Equal(t, "testClient", c.Content.(*Connect).ClientID)
+	assert.Equal(t, "testUser", c.Content.(*Connect).Username)
+	assert.Equal(t, uint32(30), *c.Content.(*Connect).Properties.SessionExpiryInterval)
+}
+
+func TestDecodePacketPublishAliasesFrame(t *testing.T) {
+	var b bytes.Buffer
+	x := NewControlPacket(PUBLISH, MQTTv5)
+	x.Content.(*Publish).Topic = "a/b"
+	x.Content.(*Publish).Payload = []byte("payload")
+	x.Content.(*Publish).Properties.CorrelationData = []byte("corr")
+	_, err := x.WriteTo(&b)
+	require.Nil(t, err)
+	frame := b.Bytes()
+
+	c, n, err := DecodePacket(frame, MQTTv5)
+
+	require.Nil(t, err)
+	assert.Equal(t, len(frame), n)
+	p := c.Content.(*Publish)
+	assert.Equal(t, "a/b", p.Topic)
+	assert.Equal(t, []byte("payload"), p.Payload)
+	assert.Equal(t, []byte("corr"), p.Properties.CorrelationData)
+
+	frame[len(frame)-1] = '!'
+	assert.Equal(t, []byte("payloa!"), p.Payload)
+}
+
+func TestDecodePacketMultipleFrames(t *testing.T) {
+	var b bytes.Buffer
+	_, err := NewControlPacket(PINGREQ, MQTTv311).WriteTo(&b)
+	require.Nil(t, err)
+	x := NewControlPacket(PUBACK, MQTTv311)
+	x.Content.(*Puback).PacketID = 7
+	_, err = x.WriteTo(&b)
+	require.Nil(t, err)
+	frames := b.Bytes()
+
+	c, n, err := DecodePacket(frames, MQTTv311)
+	require.Nil(t, err)
+	assert.Equal(t, PINGREQ, c.Type)
+	assert.Equal(t, 2, n)
+
+	c, m, err := DecodePacket(frames[n:], MQTTv311)
+	require.Nil(t, err)
+	assert.Equal(t, PUBACK, c.Type)
+	assert.Equal(t, uint16(7), c.PacketID())
+	assert.Equal(t, len(frames), n+m)
+}
+
+func TestDecodePacketTruncated(t *testing.T) {
+	p := []byte{16, 38, 0, 4, 77, 81, 84, 84, 5, 128, 0, 30, 5, 17, 0, 0, 0, 30, 0, 10, 116, 101, 115, 116, 67, 108, 105, 101, 110, 116, 0, 8, 116, 101, 115, 116, 85, 115, 101, 114}
+
+	for _, l := range []int{0, 1, 2, len(p) - 1} {
+		_, n, err := DecodePacket(p[:l], MQTTv5)
+		assert.Equal(t, io.ErrUnexpectedEOF, err, "length %d", l)
+		assert.Equal(t, 0, n)
+	}
+}
+
+func benchmarkPublishFrame(b *testing.B, size int) []byte {
+	var buf bytes.Buffer
+	x := NewControlPacket(PUBLISH, MQTTv5)
+	x.Content.(*Publish).QoS = 1
+	x.Content.(*Publish).Topic = "testTopic"
+	x.Content.(*Publish).PacketID = uint16(100)
+	x.Content.(*Publish).Payload = make([]byte, size)
+	x.Content.(*Publish).Properties.CorrelationData = []byte("correlation")
+	if _, err := x.WriteTo(&buf); err != nil {
+		b.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func BenchmarkReadPacket_Publish(b *testing.B) {
+	frame := benchmarkPublishFrame(b, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		if _, err := ReadPacket(bytes.NewReader(frame), MQTTv5); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodePacket_Publish(b *testing.B) {
+	frame := benchmarkPublishFrame(b, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		if _, _, err := DecodePacket(frame, MQTTv5); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPacket_Publish64K(b *testing.B) {
+	frame := benchmarkPublishFrame(b, 64*1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		if _, err := ReadPacket(bytes.NewReader(frame), MQTTv5); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodePacket_Publish64K(b *testing.B) {
+	frame := benchmarkPublishFrame(b, 64*1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		if _, _, err := DecodePacket(frame, MQTTv5); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodePublishView_64K(b *testing.B) {
+	frame := benchmarkPublishFrame(b, 64*1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		if _, _, err := DecodePublishView(frame, MQTTv5); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPublishViewRewrite_64K(b *testing.B) {
+	frame := benchmarkPublishFrame(b, 64*1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		pv, _, err := DecodePublishView(frame, MQTTv5)
+		if err != nil {
+			b.Fatal(err)
+		}
+		h := pv.Header()
+		h.Topic = "tenant/" + h.Topic
+		h.QoS = 0
+		if _, err = pv.Rewrite(h); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkPackets returns a v5 packet of every type
+func benchmarkPackets() []*ControlPacket {
+	var packets []*ControlPacket
+	for t := CONNECT; t <= AUTH; t++ {
+		cp := NewControlPacket(t, MQTTv5)
+		switch p := cp.Content.(type) {
+		case *Connect:
+			p.ClientID = "testClient"
+			p.KeepAlive = 30
+		case *Publish:
+			p.Topic = "testTopic"
+			p.QoS = 1
+			p.PacketID = 100
+			p.Payload = []byte("testPayload")
+			p.Properties.ContentType = "text/plain"
+		case *Puback:
+			p.PacketID = 100
+		case *Pubrec:
+			p.PacketID = 100
+		case *Pubrel:
+			p.PacketID = 100
+		case *Pubcomp:
+			p.PacketID = 100
+		case *Subscribe:
+			p.PacketID = 100
+			p.Subscriptions = []Subscription{{Topic: "test/#", QoS: 1}}
+		case *Suback:
+			p.PacketID = 100
+			p.Reasons = []byte{1}
+		case *Unsubscribe:
+			p.PacketID = 100
+			p.Topics = []string{"test/#"}
+		case *Unsuback:
+			p.PacketID = 100
+			p.Reasons = []byte{0}
+		case *Auth:
+			p.ReasonCode = AuthContinueAuthentication
+			p.Properties.AuthMethod = "SCRAM-SHA-1"
+		}
+		packets = append(packets, cp)
+	}
+
+	return packets
+}
+
+func BenchmarkReadPacket(b *testing.B) {
+	for _, cp := range benchmarkPackets() {
+		var buf bytes.Buffer
+		if _, err := cp.WriteTo(&buf); err != nil {
+			b.Fatal(err)
+		}
+		frame := buf.Bytes()
+
+		b.Run(cp.PacketType(), func(b *testing.B) {
+			r := bytes.NewReader(frame)
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				r.Reset(frame)
+				if _, err := ReadPacket(r, MQTTv5); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPacketReader(b *testing.B) {
+	for _, cp := range benchmarkPackets() {
+		var buf bytes.Buffer
+		if _, err := cp.WriteTo(&buf); err != nil {
+			b.Fatal(err)
+		}
+		frame := buf.Bytes()
+
+		b.Run(cp.PacketType(), func(b *testing.B) {
+			r := bytes.NewReader(frame)
+			pr := NewPacketReader(r, MQTTv5)
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				r.Reset(frame)
+				read, err := pr.ReadPacket()
+				if err != nil {
+					b.Fatal(err)
+				}
+				pr.Release(read)
+			}
+		})
+	}
+}
+
+func BenchmarkWriteTo(b *testing.B) {
+	for _, cp := range benchmarkPackets() {
+		cp := cp
+		b.Run(cp.PacketType(), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				if _, err := cp.WriteTo(ioutil.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPacketWriter(b *testing.B) {
+	for _, cp := range benchmarkPackets() {
+		cp := cp
+		b.Run(cp.PacketType(), func(b *testing.B) {
+			pw := NewPacketWriter(ioutil.Discard)
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				if err := pw.WritePacket(cp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSize(b *testing.B) {
+	for _, cp := range benchmarkPackets() {
+		cp := cp
+		b.Run(cp.PacketType(), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				if cp.Size() == 0 {
+					b.Fatal("empty packet")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAppendTo(b *testing.B) {
+	for _, cp := range benchmarkPackets() {
+		cp := cp
+		b.Run(cp.PacketType(), func(b *testing.B) {
+			buf := make([]byte, 0, cp.Size())
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				if _, err := cp.AppendTo(buf[:0]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
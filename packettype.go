@@ -0,0 +1,113 @@
+package mqttpackets
+
+import "fmt"
+
+// PacketType is the type of an MQTT control packet as carried in the upper
+// four bits of the fixed header
+type PacketType byte
+
+// Direction describes who is allowed to send a packet type
+type Direction byte
+
+// DirectionClientToServer, etc are the directions a packet type can flow in
+const (
+	DirectionClientToServer Direction = iota + 1
+	DirectionServerToClient
+	DirectionBoth
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionClientToServer:
+		return "client to server"
+	case DirectionServerToClient:
+		return "server to client"
+	case DirectionBoth:
+		return "both"
+	}
+	return fmt.Sprintf("direction %d", d)
+}
+
+type packetTypeInfo struct {
+	name      string
+	direction Direction
+	packetID  bool
+	ackOf     PacketType
+}
+
+// packetTypes holds the metadata of the packet types, indexed by type
+var packetTypes = [...]packetTypeInfo{
+	CONNECT:     {"CONNECT", DirectionClientToServer, false, 0},
+	CONNACK:     {"CONNACK", DirectionServerToClient, false, CONNECT},
+	PUBLISH:     {"PUBLISH", DirectionBoth, true, 0},
+	PUBACK:      {"PUBACK", DirectionBoth, true, PUBLISH},
+	PUBREC:      {"PUBREC", DirectionBoth, true, PUBLISH},
+	PUBREL:      {"PUBREL", DirectionBoth, true, PUBREC},
+	PUBCOMP:     {"PUBCOMP", DirectionBoth, true, PUBREL},
+	SUBSCRIBE:   {"SUBSCRIBE", DirectionClientToServer, true, 0},
+	SUBACK:      {"SUBACK", DirectionServerToClient, true, SUBSCRIBE},
+	UNSUBSCRIBE: {"UNSUBSCRIBE", DirectionClientToServer, true, 0},
+	UNSUBACK:    {"UNSUBACK", DirectionServerToClient, true, UNSUBSCRIBE},
+	PINGREQ:     {"PINGREQ", DirectionClientToServer, false, 0},
+	PINGRESP:    {"PINGRESP", DirectionServerToClient, false, PINGREQ},
+	DISCONNECT:  {"DISCONNECT", DirectionBoth, false, 0},
+	AUTH:        {"AUTH", DirectionBoth, false, 0},
+}
+
+// String returns the name of the packet type, e.g. PUBLISH
+func (t PacketType) String() string {
+	if !t.IsValid() {
+		return fmt.Sprintf("type %d", byte(t))
+	}
+	return packetTypes[t].name
+}
+
+// IsValid reports whether t is one of the packet types defined by MQTT
+func (t PacketType) IsValid() bool {
+	return t >= CONNECT && t <= AUTH
+}
+
+// Direction returns who may send packets of this type. DISCONNECT is
+// reported as DirectionBoth, before v5 only clients send it.
+func (t PacketType) Direction() Direction {
+	if !t.IsValid() {
+		return 0
+	}
+	return packetTypes[t].direction
+}
+
+// HasPacketID reports whether packets of this type carry a packet
+// identifier, PUBLISH packets only carry one when their QoS is above 0
+func (t PacketType) HasPacketID() bool {
+	return t.IsValid() && packetTypes[t].packetID
+}
+
+// AckOf returns the packet type that packets of type t acknowledge, e.g.
+// PUBLISH for PUBACK. The boolean is false if t is not an acknowledgement.
+func (t PacketType) AckOf() (PacketType, bool) {
+	if !t.IsValid() || packetTypes[t].ackOf == 0 {
+		return 0, false
+	}
+	return packetTypes[t].ackOf, true
+}
+
+// MarshalText implements encoding.TextMarshaler, packet types are encoded by
+// their name
+func (t PacketType) MarshalText() ([]byte, error) {
+	if !t.IsValid() {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPacketType, byte(t))
+	}
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, it accepts the names
+// returned by String
+func (t *PacketType) UnmarshalText(text []byte) error {
+	for pt := CONNECT; pt <= AUTH; pt++ {
+		if string(text) == pt.String() {
+			*t = pt
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidPacketType, text)
+}
@@ -0,0 +1,61 @@
+package mqttpackets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketType(t *testing.T) {
+	tests := []struct {
+		packetType PacketType
+		name       string
+		direction  Direction
+		packetID   bool
+		ackOf      PacketType
+	}{
+		{CONNECT, "CONNECT", DirectionClientToServer, false, 0},
+		{CONNACK, "CONNACK", DirectionServerToClient, false, CONNECT},
+		{PUBLISH, "PUBLISH", DirectionBoth, true, 0},
+		{PUBACK, "PUBACK", DirectionBoth, true, PUBLISH},
+		{PUBREC, "PUBREC", DirectionBoth, true, PUBLISH},
+		{PUBREL, "PUBREL", DirectionBoth, true, PUBREC},
+		{PUBCOMP, "PUBCOMP", DirectionBoth, true, PUBREL},
+		{SUBSCRIBE, "SUBSCRIBE", DirectionClientToServer, true, 0},
+		{SUBACK, "SUBACK", DirectionServerToClient, true, SUBSCRIBE},
+		{UNSUBSCRIBE, "UNSUBSCRIBE", DirectionClientToServer, true, 0},
+		{UNSUBACK, "UNSUBACK", DirectionServerToClient, true, UNSUBSCRIBE},
+		{PINGREQ, "PINGREQ", DirectionClientToServer, false, 0},
+		{PINGRESP, "PINGRESP", DirectionServerToClient, false, PINGREQ},
+		{DISCONNECT, "DISCONNECT", DirectionBoth, false, 0},
+		{AUTH, "AUTH", DirectionBoth, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, tt.packetType.IsValid())
+			assert.Equal(t, tt.name, tt.packetType.String())
+			assert.Equal(t, tt.direction, tt.packetType.Direction())
+			assert.Equal(t, tt.packetID, tt.packetType.HasPacketID())
+
+			ackOf, ok := tt.packetType.AckOf()
+			assert.Equal(t, tt.ackOf != 0, ok)
+			assert.Equal(t, tt.ackOf, ackOf)
+		})
+	}
+}
+
+func TestPacketTypeInvalid(t *testing.T) {
+	for _, pt := range []PacketType{0, 16, 255} {
+		assert.False(t, pt.IsValid())
+		assert.Zero(t, pt.Direction())
+		assert.False(t, pt.HasPacketID())
+		_, ok := pt.AckOf()
+		assert.False(t, ok)
+	}
+
+	assert.Equal(t, "type 0", PacketType(0).String())
+	assert.Equal(t, "type 16", PacketType(16).String())
+	assert.Equal(t, "type 16", (&ControlPacket{FixedHeader: FixedHeader{Type: 16}}).PacketType())
+	assert.Equal(t, "both", DirectionBoth.String())
+}
@@ -0,0 +1,136 @@
+package mqttpackets
+
+import "io"
+
+// Parser decodes packets from a stream that arrives in chunks of arbitrary
+// size, for servers that read connections in an event loop instead of a
+// goroutine per connection. Packets are decoded by the same code as
+// ReadPacket and don't alias the fed bytes.
+//
+// The Parser only buffers the bytes of an incomplete packet. A packet larger
+// than Options.MaxPacketSize is rejected as soon as its fixed header arrives,
+// so the buffer never grows beyond that size. Without a MaxPacketSize the
+// limit is DefaultParserMaxPacketSize.
+type Parser struct {
+	// Options are the limits enforced on decoded packets, nil only enforces
+	// DefaultParserMaxPacketSize
+	Options *ReaderOptions
+
+	buf     []byte
+	err     error
+	version Version
+}
+
+// DefaultParserMaxPacketSize is the maximum packet size of a Parser whose
+// Options don't set MaxPacketSize, it bounds the memory buffered for each
+// connection. Set MaxPacketSize to accept larger packets, up to the 256 MB
+// the remaining length can express.
+const DefaultParserMaxPacketSize = 1 << 20
+
+// NewParser returns a Parser decoding packets of protocol version v. With
+// version 0 the version is taken from the first CONNECT packet.
+func NewParser(v Version, opts *ReaderOptions) *Parser {
+	return &Parser{Options: opts, version: v}
+}
+
+// Version returns the protocol version packets are decoded with
+func (p *Parser) Version() Version {
+	return p.version
+}
+
+// Buffered returns the number of bytes of an incomplete packet that are
+// held until the rest of it is fed
+func (p *Parser) Buffered() int {
+	return len(p.buf)
+}
+
+// Feed adds data to the stream and returns the packets it completes. On an
+// error it returns the packets decoded before it, the stream can't be
+// resynchronised afterwards so every later call returns the same error.
+// Feed does not retain data.
+func (p *Parser) Feed(data []byte) ([]*ControlPacket, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	// decode straight from data when nothing is buffered, so only the tail
+	// of an incomplete packet is copied
+	stream := data
+	if len(p.buf) > 0 {
+		p.buf = append(p.buf, data...)
+		stream = p.buf
+	}
+
+	opts := p.options()
+	var packets []*ControlPacket
+	for {
+		n := frameLen(stream)
+		if n == 0 || n > len(stream) {
+			// DecodePacket reports invalid fixed headers and packets
+			// exceeding MaxPacketSize before their body arrives
+			if _, _, err := DecodePacketWithOptions(stream, p.version, opts); err != io.ErrUnexpectedEOF {
+				p.err = err
+				p.buf = nil
+				return packets, err
+			}
+			break
+		}
+
+		frame := make([]byte, n)
+		copy(frame, stream)
+		cp, _, err := DecodePacketWithOptions(frame, p.version, opts)
+		if err != nil {
+			p.err = err
+			p.buf = nil
+			return packets, err
+		}
+		if c, ok := cp.Content.(*Connect); ok && p.version == 0 {
+			p.version = c.ProtocolVersion
+		}
+
+		packets = append(packets, cp)
+		stream = stream[n:]
+	}
+
+	if len(p.buf) == 0 {
+		p.buf = append(p.buf, stream...)
+	} else {
+		p.buf = p.buf[:copy(p.buf, stream)]
+	}
+	// don't hold on to the memory of a large packet on an idle connection
+	if len(p.buf) == 0 && cap(p.buf) > maxPreallocSize {
+		p.buf = nil
+	}
+
+	return packets, nil
+}
+
+// options returns the Options with DefaultParserMaxPacketSize applied if
+// they don't set MaxPacketSize
+func (p *Parser) options() *ReaderOptions {
+	if p.Options != nil && p.Options.MaxPacketSize > 0 {
+		return p.Options
+	}
+
+	var opts ReaderOptions
+	if p.Options != nil {
+		opts = *p.Options
+	}
+	opts.MaxPacketSize = DefaultParserMaxPacketSize
+	return &opts
+}
+
+// frameLen returns the length of the packet at the start of b, or 0 if b
+// does not hold its whole fixed header or the remaining length is malformed
+func frameLen(b []byte) int {
+	var length, shift int
+	for i := 1; i < len(b) && i <= maxVBILen; i++ {
+		length |= int(b[i]&0x7f) << shift
+		if b[i]&0x80 == 0 {
+			return i + 1 + length
+		}
+		shift += 7
+	}
+
+	return 0
+}
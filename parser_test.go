@@ -0,0 +1,178 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parserStream returns a v5 connection stream and the packets it holds
+func parserStream(t *testing.T) ([]byte, []*ControlPacket) {
+	connect := NewControlPacket(CONNECT, MQTTv5)
+	connect.Content.(*Connect).ClientID = "c"
+
+	subscribe := NewControlPacket(SUBSCRIBE, MQTTv5)
+	subscribe.Content.(*Subscribe).PacketID = 1
+	subscribe.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "a/#", QoS: 1, NoLocal: true}}
+
+	publish := NewControlPacket(PUBLISH, MQTTv5)
+	publish.Content.(*Publish).Topic = "a/b"
+	publish.Content.(*Publish).Payload = bytes.Repeat([]byte("x"), 300)
+	publish.Content.(*Publish).Properties.User = []User{{Key: "k", Value: "v"}}
+
+	packets := []*ControlPacket{connect, subscribe, publish, NewControlPacket(PINGREQ, MQTTv5)}
+	var b bytes.Buffer
+	for _, cp := range packets {
+		_, err := cp.WriteTo(&b)
+		require.NoError(t, err)
+	}
+
+	return b.Bytes(), packets
+}
+
+func TestParserChunks(t *testing.T) {
+	stream, want := parserStream(t)
+
+	for _, size := range []int{1, 2, 3, 7, 64, 200, len(stream)} {
+		p := NewParser(0, nil)
+		var got []*ControlPacket
+		for i := 0; i < len(stream); i += size {
+			end := i + size
+			if end > len(stream) {
+				end = len(stream)
+			}
+			packets, err := p.Feed(stream[i:end])
+			require.NoError(t, err, "chunk size %d", size)
+			got = append(got, packets...)
+		}
+
+		require.Len(t, got, len(want), "chunk size %d", size)
+		for i := range want {
+			assert.Equal(t, want[i].Content, got[i].Content, "chunk size %d", size)
+		}
+		assert.Zero(t, p.Buffered())
+		assert.Equal(t, MQTTv5, p.Version())
+	}
+}
+
+func TestParserMatchesReadPacket(t *testing.T) {
+	stream, _ := parserStream(t)
+
+	packets, err := NewParser(0, nil).Feed(stream)
+	require.NoError(t, err)
+
+	r := bytes.NewReader(stream)
+	v := Version(0)
+	for _, cp := range packets {
+		read, err := ReadPacket(r, v)
+		require.NoError(t, err)
+		assert.Equal(t, read, cp)
+		v = MQTTv5
+	}
+}
+
+func TestParserDoesNotAliasInput(t *testing.T) {
+	stream, _ := parserStream(t)
+	data := append([]byte(nil), stream...)
+
+	packets, err := NewParser(0, nil).Feed(data)
+	require.NoError(t, err)
+	for i := range data {
+		data[i] = 0
+	}
+	assert.Equal(t, bytes.Repeat([]byte("x"), 300), packets[2].Content.(*Publish).Payload)
+}
+
+func TestParserBuffersPartialPacket(t *testing.T) {
+	stream, _ := parserStream(t)
+	connectLen := frameLen(stream)
+
+	p := NewParser(0, nil)
+	packets, err := p.Feed(stream[:connectLen+3])
+	require.NoError(t, err)
+	assert.Len(t, packets, 1)
+	assert.Equal(t, 3, p.Buffered())
+
+	packets, err = p.Feed(stream[connectLen+3:])
+	require.NoError(t, err)
+	assert.Len(t, packets, 3)
+	assert.Zero(t, p.Buffered())
+}
+
+func TestParserMaxPacketSize(t *testing.T) {
+	p := NewParser(MQTTv311, &ReaderOptions{MaxPacketSize: 100})
+
+	// only the fixed header of a 1000 byte PUBLISH has arrived
+	packets, err := p.Feed([]byte{0x30, 0xE5, 0x07})
+	assert.Empty(t, packets)
+	var tooLarge *PacketTooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	assert.Equal(t, 1000, tooLarge.Size)
+	assert.Zero(t, p.Buffered())
+
+	_, err2 := p.Feed([]byte{0xC0, 0})
+	assert.Equal(t, err, err2)
+}
+
+func TestParserDefaultMaxPacketSize(t *testing.T) {
+	// the fixed header of a PUBLISH with the largest remaining length
+	packets, err := NewParser(MQTTv311, nil).Feed([]byte{0x30, 0xFF, 0xFF, 0xFF, 0x7F})
+	assert.Empty(t, packets)
+	var tooLarge *PacketTooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	assert.Equal(t, DefaultParserMaxPacketSize, tooLarge.MaxSize)
+
+	// a packet of the default maximum size is buffered in chunks, 4 bytes of
+	// fixed header and 3 bytes of topic
+	publish := NewControlPacket(PUBLISH, MQTTv311)
+	publish.Content.(*Publish).Topic = "a"
+	publish.Content.(*Publish).Payload = make([]byte, DefaultParserMaxPacketSize-7)
+	frame, err := publish.AppendTo(nil)
+	require.NoError(t, err)
+	require.Len(t, frame, DefaultParserMaxPacketSize)
+
+	for _, opts := range []*ReaderOptions{nil, {MaxUserProperties: 1}, {MaxPacketSize: 2 * DefaultParserMaxPacketSize}} {
+		p := NewParser(MQTTv311, opts)
+		var decoded []*ControlPacket
+		for chunk := frame; len(chunk) > 0; {
+			n := 64 << 10
+			if n > len(chunk) {
+				n = len(chunk)
+			}
+			packets, err := p.Feed(chunk[:n])
+			require.NoError(t, err)
+			assert.LessOrEqual(t, p.Buffered(), DefaultParserMaxPacketSize)
+			decoded = append(decoded, packets...)
+			chunk = chunk[n:]
+		}
+		require.Len(t, decoded, 1)
+		assert.Equal(t, publish.Content, decoded[0].Content)
+	}
+
+	// a larger MaxPacketSize overrides the default
+	publish.Content.(*Publish).Payload = make([]byte, DefaultParserMaxPacketSize)
+	frame, err = publish.AppendTo(nil)
+	require.NoError(t, err)
+	_, err = NewParser(MQTTv311, nil).Feed(frame)
+	require.True(t, errors.As(err, &tooLarge))
+	packets, err = NewParser(MQTTv311, &ReaderOptions{MaxPacketSize: 2 * DefaultParserMaxPacketSize}).Feed(frame)
+	require.NoError(t, err)
+	assert.Len(t, packets, 1)
+}
+
+func TestParserErrors(t *testing.T) {
+	pingreq := []byte{0xC0, 0}
+
+	packets, err := NewParser(MQTTv311, nil).Feed(append(pingreq, 0x00, 0x00))
+	assert.Len(t, packets, 1)
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, "PacketType", malformedErr.Field)
+
+	_, err = NewParser(MQTTv311, nil).Feed([]byte{0xC0, 0xFF, 0xFF, 0xFF, 0xFF})
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, "RemainingLength", malformedErr.Field)
+}
@@ -20,6 +20,14 @@ func (p *Pingreq) Buffers() net.Buffers {
 	return nil
 }
 
+func (p *Pingreq) size() int {
+	return 0
+}
+
+func (p *Pingreq) appendTo(dst []byte) []byte {
+	return dst
+}
+
 // WriteTo is the implementation of the interface required function for a packet
 func (p *Pingreq) WriteTo(w io.Writer) (int64, error) {
 	cp := &ControlPacket{FixedHeader: FixedHeader{Type: PINGREQ}}
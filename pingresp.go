@@ -20,6 +20,14 @@ func (p *Pingresp) Buffers() net.Buffers {
 	return nil
 }
 
+func (p *Pingresp) size() int {
+	return 0
+}
+
+func (p *Pingresp) appendTo(dst []byte) []byte {
+	return dst
+}
+
 // WriteTo is the implementation of the interface required function for a packet
 func (p *Pingresp) WriteTo(w io.Writer) (int64, error) {
 	cp := &ControlPacket{FixedHeader: FixedHeader{Type: PINGRESP}}
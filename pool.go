@@ -0,0 +1,237 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBufferSize is the largest buffer that is returned to a pool, so
+// a single large packet doesn't keep its memory alive
+const maxPooledBufferSize = 1 << 20
+
+// packetPools holds the released packets by packet type
+var packetPools [AUTH + 1]sync.Pool
+
+// bufferPool holds the buffers of packet bodies and encoded packets
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(b)
+	}
+}
+
+// PacketReader reads packets like ReadPacket does, but takes the packets,
+// content structs, properties and body buffers from pools. Packets that are
+// passed to Release are reused by later reads.
+//
+// A packet returned by ReadPacket belongs to the caller until it is passed
+// to Release. Its binary fields, like Payload, Password and CorrelationData,
+// alias a pooled buffer, so neither the packet nor any slice taken from it
+// may be used after Release. Strings are copied and stay valid. Packets that
+// are never released are simply garbage collected.
+type PacketReader struct {
+	// Options are the limits enforced on read packets, nil enforces none
+	Options *ReaderOptions
+
+	r       io.Reader
+	version Version
+	scratch [1]byte
+}
+
+// NewPacketReader returns a PacketReader reading packets of protocol version
+// v from r. With version 0 the version is taken from the first CONNECT packet.
+func NewPacketReader(r io.Reader, v Version) *PacketReader {
+	return &PacketReader{r: r, version: v}
+}
+
+// ReadPacket reads the next packet, see PacketReader for who owns it
+func (pr *PacketReader) ReadPacket() (*ControlPacket, error) {
+	if _, err := io.ReadFull(pr.r, pr.scratch[:1]); err != nil {
+		return nil, err
+	}
+
+	cp, err := pooledPacket(pr.scratch[0], pr.version)
+	if err != nil {
+		return nil, err
+	}
+
+	headerLen := 1
+	for multiplier := 0; ; multiplier += 7 {
+		if multiplier == 7*maxVBILen {
+			pr.Release(cp)
+			return nil, remainingLengthError(cp.Type, errMalformedVBI)
+		}
+		if _, err = io.ReadFull(pr.r, pr.scratch[:1]); err != nil {
+			pr.Release(cp)
+			return nil, err
+		}
+		headerLen++
+		cp.remainingLength |= int(pr.scratch[0]&0x7f) << multiplier
+		if pr.scratch[0] < 0x80 {
+			break
+		}
+	}
+	if err = pr.Options.checkSize(cp.Type, headerLen+cp.remainingLength); err != nil {
+		pr.Release(cp)
+		return nil, err
+	}
+
+	cp.body = getBuffer()
+	if cp.remainingLength <= maxPreallocSize {
+		cp.body.Grow(cp.remainingLength)
+	}
+	if _, err = io.CopyN(cp.body, pr.r, int64(cp.remainingLength)); err != nil {
+		pr.Release(cp)
+		return nil, err
+	}
+	if err = pr.Options.unpack(cp, cp.body); err != nil {
+		pr.Release(cp)
+		return nil, setErrorOffset(err, headerLen+cp.remainingLength)
+	}
+	if err = pr.Options.checkPacket(cp, pr.version); err != nil {
+		pr.Release(cp)
+		return nil, err
+	}
+
+	if c, ok := cp.Content.(*Connect); ok && pr.version == 0 {
+		pr.version = c.ProtocolVersion
+	}
+
+	return cp, nil
+}
+
+// Release returns cp to the pools, it must not be used or released again
+// afterwards. Packets that were not read by a PacketReader can be released
+// too, as long as their content matches their type.
+func (pr *PacketReader) Release(cp *ControlPacket) {
+	if cp == nil || !cp.Type.IsValid() || cp.Content == nil {
+		return
+	}
+
+	if cp.body != nil {
+		putBuffer(cp.body)
+		cp.body = nil
+	}
+	packetPools[cp.Type].Put(cp)
+}
+
+// pooledPacket works like newPacketFromHeader but reuses a released packet
+func pooledPacket(header byte, v Version) (*ControlPacket, error) {
+	pt := PacketType(header >> 4)
+	if !pt.IsValid() {
+		return newPacketFromHeader(header, v)
+	}
+
+	cp, ok := packetPools[pt].Get().(*ControlPacket)
+	if !ok {
+		return newPacketFromHeader(header, v)
+	}
+
+	resetContent(cp.Content, v)
+	cp.remainingLength = 0
+	cp.Flags = header & 0xF
+	if p, ok := cp.Content.(*Publish); ok {
+		p.QoS = (cp.Flags & 0x6) >> 1
+		p.Duplicate = cp.Flags&0x8 > 0
+		p.Retain = cp.Flags&0x1 > 0
+	}
+
+	return cp, nil
+}
+
+// resetProperties clears props for reuse, it returns nil before v5
+func resetProperties(props *Properties, v Version) *Properties {
+	if v != MQTTv5 {
+		return nil
+	}
+	if props == nil {
+		return &Properties{}
+	}
+
+	*props = Properties{}
+	return props
+}
+
+// resetContent resets a released packet to the state NewControlPacket
+// creates it in, keeping its properties and slices allocated
+func resetContent(content Packet, v Version) {
+	switch p := content.(type) {
+	case *Connect:
+		*p = Connect{
+			ProtocolName:    "MQTT",
+			ProtocolVersion: v,
+			Properties:      resetProperties(p.Properties, v),
+		}
+		if v == MQTTv31 {
+			p.ProtocolName = "MQIsdp"
+		}
+	case *Connack:
+		*p = Connack{Properties: resetProperties(p.Properties, v)}
+	case *Publish:
+		*p = Publish{Properties: resetProperties(p.Properties, v)}
+	case *Puback:
+		*p = Puback{Properties: resetProperties(p.Properties, v)}
+	case *Pubrec:
+		*p = Pubrec{Properties: resetProperties(p.Properties, v)}
+	case *Pubrel:
+		*p = Pubrel{Properties: resetProperties(p.Properties, v)}
+	case *Pubcomp:
+		*p = Pubcomp{Properties: resetProperties(p.Properties, v)}
+	case *Subscribe:
+		*p = Subscribe{Properties: resetProperties(p.Properties, v), Subscriptions: p.Subscriptions[:0]}
+	case *Suback:
+		*p = Suback{Properties: resetProperties(p.Properties, v)}
+	case *Unsubscribe:
+		*p = Unsubscribe{Properties: resetProperties(p.Properties, v), Topics: p.Topics[:0]}
+	case *Unsuback:
+		*p = Unsuback{Properties: resetProperties(p.Properties, v)}
+	case *Pingreq:
+		*p = Pingreq{}
+	case *Pingresp:
+		*p = Pingresp{}
+	case *Disconnect:
+		*p = Disconnect{Properties: resetProperties(p.Properties, v)}
+	case *Auth:
+		*p = Auth{Properties: resetProperties(p.Properties, v)}
+	}
+}
+
+// PacketWriter writes packets through a pooled buffer, so every packet is
+// written with a single Write call.
+type PacketWriter struct {
+	w io.Writer
+}
+
+// NewPacketWriter returns a PacketWriter writing packets to w
+func NewPacketWriter(w io.Writer) *PacketWriter {
+	return &PacketWriter{w: w}
+}
+
+// WritePacket encodes cp and writes it, nothing is written if Encode fails.
+// cp is not retained and can be released once WritePacket returns.
+func (pw *PacketWriter) WritePacket(cp *ControlPacket) error {
+	b := getBuffer()
+	defer putBuffer(b)
+
+	// AppendTo encodes into the grown buffer without reallocating it
+	b.Grow(cp.Size())
+	frame, err := cp.AppendTo(b.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = pw.w.Write(frame)
+
+	return err
+}
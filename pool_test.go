@@ -0,0 +1,99 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketReader(t *testing.T) {
+	stream, want := parserStream(t)
+
+	// read the stream twice, the second time with released packets
+	pr := NewPacketReader(io.MultiReader(bytes.NewReader(stream), bytes.NewReader(stream)), 0)
+	for round := 0; round < 2; round++ {
+		for _, w := range want {
+			cp, err := pr.ReadPacket()
+			require.NoError(t, err)
+			assert.Equal(t, w.Content, cp.Content)
+			pr.Release(cp)
+		}
+	}
+
+	_, err := pr.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestPacketReaderResetsReleasedPackets(t *testing.T) {
+	full := NewControlPacket(PUBLISH, MQTTv5)
+	p := full.Content.(*Publish)
+	p.Topic = "a"
+	p.QoS = 1
+	p.PacketID = 2
+	p.Retain = true
+	p.Payload = []byte("payload")
+	p.Properties.ContentType = "text/plain"
+	p.Properties.User = []User{{Key: "k", Value: "v"}}
+
+	empty := NewControlPacket(PUBLISH, MQTTv5)
+	empty.Content.(*Publish).Topic = "b"
+
+	var b bytes.Buffer
+	for i := 0; i < 10; i++ {
+		_, err := full.WriteTo(&b)
+		require.NoError(t, err)
+		_, err = empty.WriteTo(&b)
+		require.NoError(t, err)
+	}
+
+	pr := NewPacketReader(&b, MQTTv5)
+	for i := 0; i < 10; i++ {
+		cp, err := pr.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, p, cp.Content)
+		pr.Release(cp)
+
+		cp, err = pr.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, &Publish{Topic: "b", Properties: &Properties{}, Payload: []byte{}}, cp.Content)
+		pr.Release(cp)
+	}
+}
+
+func TestPacketReaderErrors(t *testing.T) {
+	pr := NewPacketReader(bytes.NewReader([]byte{0x30, 3, 0, 5, 'a'}), MQTTv311)
+	_, err := pr.ReadPacket()
+	var malformedErr *MalformedPacketError
+	require.ErrorAs(t, err, &malformedErr)
+	assert.Equal(t, 2, malformedErr.Offset)
+
+	pr = NewPacketReader(bytes.NewReader([]byte{0x30, 100, 0}), MQTTv311)
+	pr.Options = &ReaderOptions{MaxPacketSize: 10}
+	_, err = pr.ReadPacket()
+	var tooLarge *PacketTooLargeError
+	require.ErrorAs(t, err, &tooLarge)
+
+	// releasing nil or foreign packets is safe
+	pr.Release(nil)
+	pr.Release(&ControlPacket{})
+	pr.Release(NewControlPacket(PUBACK, MQTTv5))
+}
+
+func TestPacketWriter(t *testing.T) {
+	stream, want := parserStream(t)
+
+	var b bytes.Buffer
+	pw := NewPacketWriter(&b)
+	for _, cp := range want {
+		require.NoError(t, pw.WritePacket(cp))
+	}
+	assert.Equal(t, stream, b.Bytes())
+
+	bad := NewControlPacket(PUBLISH, MQTTv5)
+	bad.Content.(*Publish).QoS = 3
+	assert.ErrorIs(t, pw.WritePacket(bad), ErrInvalidQoS)
+	assert.Equal(t, len(stream), b.Len())
+}
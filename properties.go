@@ -2,8 +2,8 @@ package mqttpackets
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"io"
 )
 
 // PropPayloadFormat, etc are the list of property codes for the
@@ -42,7 +42,8 @@ const (
 // then it was pointed out that user properties are allowed to appear
 // more than once
 type User struct {
-	Key, Value string
+	Key   string `json:"key"`
+	Value string `json:"value"`
 }
 
 // Properties is a struct representing the all the described properties
@@ -53,47 +54,48 @@ type Properties struct {
 	// PayloadFormat indicates the format of the payload of the message
 	// 0 is unspecified bytes
 	// 1 is UTF8 encoded character data
-	PayloadFormat *byte
+	PayloadFormat *byte `json:"payloadFormat,omitempty"`
 	// MessageExpiry is the lifetime of the message in seconds
-	MessageExpiry *uint32
+	MessageExpiry *uint32 `json:"messageExpiry,omitempty"`
 	// ContentType is a UTF8 string describing the content of the message
 	// for example it could be a MIME type
-	ContentType string
+	ContentType string `json:"contentType,omitempty"`
 	// ResponseTopic is a UTF8 string indicating the topic name to which any
 	// response to this message should be sent
-	ResponseTopic string
+	ResponseTopic string `json:"responseTopic,omitempty"`
 	// CorrelationData is binary data used to associate future response
 	// messages with the original request message
-	CorrelationData []byte
+	CorrelationData []byte `json:"correlationData,omitempty"`
 	// SubscriptionIdentifier is an identifier of the subscription to which
-	// the Publish matched
-	SubscriptionIdentifier *int
+	// the Publish matched. A PUBLISH packet can carry several of them, only
+	// the last one is kept.
+	SubscriptionIdentifier *int `json:"subscriptionIdentifier,omitempty"`
 	// SessionExpiryInterval is the time in seconds after a client disconnects
 	// that the server should retain the session information (subscriptions etc)
-	SessionExpiryInterval *uint32
+	SessionExpiryInterval *uint32 `json:"sessionExpiryInterval,omitempty"`
 	// AssignedClientID is the server assigned client identifier in the case
 	// that a client connected without specifying a clientID the server
 	// generates one and returns it in the Connack
-	AssignedClientID string
+	AssignedClientID string `json:"assignedClientID,omitempty"`
 	// ServerKeepAlive allows the server to specify in the Connack packet
 	// the time in seconds to be used as the keep alive value
-	ServerKeepAlive *uint16
+	ServerKeepAlive *uint16 `json:"serverKeepAlive,omitempty"`
 	// AuthMethod is a UTF8 string containing the name of the authentication
 	// method to be used for extended authentication
-	AuthMethod string
+	AuthMethod string `json:"authMethod,omitempty"`
 	// AuthData is binary data containing authentication data
-	AuthData []byte
+	AuthData []byte `json:"authData,omitempty"`
 	// RequestProblemInfo is used by the Client to indicate to the server to
 	// include the Reason String and/or User Properties in case of failures
-	RequestProblemInfo *byte
+	RequestProblemInfo *byte `json:"requestProblemInfo,omitempty"`
 	// WillDelayInterval is the number of seconds the server waits after the
 	// point at which it would otherwise send the will message before sending
 	// it. The client reconnecting before that time expires causes the server
 	// to cancel sending the will
-	WillDelayInterval *uint32
+	WillDelayInterval *uint32 `json:"willDelayInterval,omitempty"`
 	// RequestResponseInfo is used by the Client to request the Server provide
 	// Response Information in the Connack
-	RequestResponseInfo *byte
+	RequestResponseInfo *byte `json:"requestResponseInfo,omitempty"`
 	// ResponseInfo is a UTF8 encoded string that can be used as the basis for
 	// createing a Response Topic. The way in which the Client creates a
 	// Response Topic from the Response Information is not defined. A common
@@ -105,581 +107,672 @@ type Properties struct {
 	// return this information, it normally needs to be correctly configured.
 	// Using this mechanism allows this configuration to be done once in the
 	// Server rather than in each Client
-	ResponseInfo string
+	ResponseInfo string `json:"responseInfo,omitempty"`
 	// ServerReference is a UTF8 string indicating another server the client
 	// can use
-	ServerReference string
+	ServerReference string `json:"serverReference,omitempty"`
 	// ReasonString is a UTF8 string representing the reason associated with
 	// this response, intended to be human readable for diagnostic purposes
-	ReasonString string
+	ReasonString string `json:"reasonString,omitempty"`
 	// ReceiveMaximum is the maximum number of QOS1 & 2 messages allowed to be
 	// 'inflight' (not having received a PUBACK/PUBCOMP response for)
-	ReceiveMaximum *uint16
+	ReceiveMaximum *uint16 `json:"receiveMaximum,omitempty"`
 	// TopicAliasMaximum is the highest value permitted as a Topic Alias
-	TopicAliasMaximum *uint16
+	TopicAliasMaximum *uint16 `json:"topicAliasMaximum,omitempty"`
 	// TopicAlias is used in place of the topic string to reduce the size of
 	// packets for repeated messages on a topic
-	TopicAlias *uint16
+	TopicAlias *uint16 `json:"topicAlias,omitempty"`
 	// MaximumQOS is the highest QOS level permitted for a Publish
-	MaximumQOS *byte
+	MaximumQOS *byte `json:"maximumQOS,omitempty"`
 	// RetainAvailable indicates whether the server supports messages with the
 	// retain flag set
-	RetainAvailable *byte
+	RetainAvailable *byte `json:"retainAvailable,omitempty"`
 	// User is a slice of user provided properties (key and value)
-	User []User
+	User []User `json:"user,omitempty"`
 	// MaximumPacketSize allows the client or server to specify the maximum packet
 	// size in bytes that they support
-	MaximumPacketSize *uint32
+	MaximumPacketSize *uint32 `json:"maximumPacketSize,omitempty"`
 	// WildcardSubAvailable indicates whether wildcard subscriptions are permitted
-	WildcardSubAvailable *byte
+	WildcardSubAvailable *byte `json:"wildcardSubAvailable,omitempty"`
 	// SubIDAvailable indicates whether subscription identifiers are supported
-	SubIDAvailable *byte
+	SubIDAvailable *byte `json:"subIDAvailable,omitempty"`
 	// SharedSubAvailable indicates whether shared subscriptions are supported
-	SharedSubAvailable *byte
+	SharedSubAvailable *byte `json:"sharedSubAvailable,omitempty"`
+
+	// duplicates holds the IDs of single value properties that appeared more
+	// than once when the properties were unpacked
+	duplicates []byte
+	// limits counts the user properties while the properties are unpacked
+	// by a reader with ReaderOptions
+	limits *decodeLimits
 }
 
 // Pack takes all the defined properties for an Properties and produces
 // a slice of bytes representing the wire format for the information
-func (i *Properties) Pack(p byte) []byte {
-	var b bytes.Buffer
-
+func (i *Properties) Pack(p PacketType) []byte {
 	if i == nil {
 		return nil
 	}
 
-	if p == PUBLISH {
-		if i.PayloadFormat != nil {
-			b.WriteByte(PropPayloadFormat)
-			b.WriteByte(*i.PayloadFormat)
-		}
-
-		if i.MessageExpiry != nil {
-			b.WriteByte(PropMessageExpiry)
-			writeUint32(*i.MessageExpiry, &b)
-		}
-
-		if i.ContentType != "" {
-			b.WriteByte(PropContentType)
-			writeString(i.ContentType, &b)
-		}
+	n := i.packedLen(p)
+	if n == 0 {
+		return nil
+	}
+	return i.appendPacked(make([]byte, 0, n), p)
+}
 
-		if i.ResponseTopic != "" {
-			b.WriteByte(PropResponseTopic)
-			writeString(i.ResponseTopic, &b)
-		}
+// PackBuf will create a bytes.Buffer of the packed properties, it
+// will only pack the properties appropriate to the packet type p
+// even though other properties may exist, it will silently ignore
+// them
+func (i *Properties) PackBuf(p PacketType) *bytes.Buffer {
+	if i == nil {
+		return nil
+	}
 
-		if i.CorrelationData != nil && len(i.CorrelationData) > 0 {
-			b.WriteByte(PropCorrelationData)
-			writeBinary(i.CorrelationData, &b)
-		}
+	return bytes.NewBuffer(i.Pack(p))
+}
 
-		if i.TopicAlias != nil {
-			b.WriteByte(PropTopicAlias)
-			writeUint16(*i.TopicAlias, &b)
-		}
+// packedLen returns the length of Pack(p) without packing the properties
+func (i *Properties) packedLen(p PacketType) int {
+	if i == nil {
+		return 0
 	}
 
-	if p == PUBLISH || p == SUBSCRIBE {
-		if i.SubscriptionIdentifier != nil {
-			b.WriteByte(PropSubscriptionIdentifier)
-			encodeVBIdirect(*i.SubscriptionIdentifier, &b)
+	var n int
+	for _, id := range propertyOrder {
+		if size := i.encodedLen(id); size > 0 && ValidateID(p, id) {
+			n += size
 		}
 	}
 
-	if p == CONNECT || p == CONNACK {
-		if i.ReceiveMaximum != nil {
-			b.WriteByte(PropReceiveMaximum)
-			writeUint16(*i.ReceiveMaximum, &b)
-		}
-
-		if i.TopicAliasMaximum != nil {
-			b.WriteByte(PropTopicAliasMaximum)
-			writeUint16(*i.TopicAliasMaximum, &b)
-		}
+	return n
+}
 
-		if i.MaximumQOS != nil {
-			b.WriteByte(PropMaximumQOS)
-			b.WriteByte(*i.MaximumQOS)
-		}
+// appendPacked appends the properties appropriate to packet type p to dst,
+// like Pack does
+func (i *Properties) appendPacked(dst []byte, p PacketType) []byte {
+	if i == nil {
+		return dst
+	}
 
-		if i.MaximumPacketSize != nil {
-			b.WriteByte(PropMaximumPacketSize)
-			writeUint32(*i.MaximumPacketSize, &b)
+	for _, id := range propertyOrder {
+		if i.encodedLen(id) > 0 && ValidateID(p, id) {
+			dst = i.appendProperty(dst, id)
 		}
 	}
 
-	if p == CONNACK {
-		if i.AssignedClientID != "" {
-			b.WriteByte(PropAssignedClientID)
-			writeString(i.AssignedClientID, &b)
-		}
+	return dst
+}
 
-		if i.ServerKeepAlive != nil {
-			b.WriteByte(PropServerKeepAlive)
-			writeUint16(*i.ServerKeepAlive, &b)
-		}
+// prefixedLen returns the length of the properties for packet type p
+// including their length prefix, nil properties take no bytes
+func (i *Properties) prefixedLen(p PacketType) int {
+	if i == nil {
+		return 0
+	}
 
-		if i.WildcardSubAvailable != nil {
-			b.WriteByte(PropWildcardSubAvailable)
-			b.WriteByte(*i.WildcardSubAvailable)
-		}
+	n := i.packedLen(p)
+	return vbiLen(n) + n
+}
 
-		if i.SubIDAvailable != nil {
-			b.WriteByte(PropSubIDAvailable)
-			b.WriteByte(*i.SubIDAvailable)
-		}
+// appendPrefixed appends the length prefixed properties for packet type p
+// to dst, nil properties append nothing
+func (i *Properties) appendPrefixed(dst []byte, p PacketType) []byte {
+	if i == nil {
+		return dst
+	}
 
-		if i.SharedSubAvailable != nil {
-			b.WriteByte(PropSharedSubAvailable)
-			b.WriteByte(*i.SharedSubAvailable)
-		}
+	return i.appendPacked(appendVBI(dst, i.packedLen(p)), p)
+}
 
-		if i.RetainAvailable != nil {
-			b.WriteByte(PropRetainAvailable)
-			b.WriteByte(*i.RetainAvailable)
+// appendProperty appends property id to dst if it is set
+func (i *Properties) appendProperty(dst []byte, id byte) []byte {
+	byteProp := func(dst []byte, v *byte) []byte {
+		if v == nil {
+			return dst
 		}
-
-		if i.ResponseInfo != "" {
-			b.WriteByte(PropResponseInfo)
-			writeString(i.ResponseInfo, &b)
+		return append(dst, id, *v)
+	}
+	uint16Prop := func(dst []byte, v *uint16) []byte {
+		if v == nil {
+			return dst
 		}
+		return appendUint16(append(dst, id), *v)
 	}
-
-	if p == CONNECT {
-		if i.RequestProblemInfo != nil {
-			b.WriteByte(PropRequestProblemInfo)
-			b.WriteByte(*i.RequestProblemInfo)
+	uint32Prop := func(dst []byte, v *uint32) []byte {
+		if v == nil {
+			return dst
 		}
-
-		if i.WillDelayInterval != nil {
-			b.WriteByte(PropWillDelayInterval)
-			writeUint32(*i.WillDelayInterval, &b)
+		return appendUint32(append(dst, id), *v)
+	}
+	stringProp := func(dst []byte, v string) []byte {
+		if v == "" {
+			return dst
 		}
-
-		if i.RequestResponseInfo != nil {
-			b.WriteByte(PropRequestResponseInfo)
-			b.WriteByte(*i.RequestResponseInfo)
+		return appendString(append(dst, id), v)
+	}
+	binaryProp := func(dst []byte, v []byte) []byte {
+		if len(v) == 0 {
+			return dst
 		}
+		return appendBinary(append(dst, id), v)
 	}
 
-	if p == CONNECT || p == CONNACK || p == DISCONNECT {
-		if i.SessionExpiryInterval != nil {
-			b.WriteByte(PropSessionExpiryInterval)
-			writeUint32(*i.SessionExpiryInterval, &b)
+	switch id {
+	case PropPayloadFormat:
+		dst = byteProp(dst, i.PayloadFormat)
+	case PropMessageExpiry:
+		dst = uint32Prop(dst, i.MessageExpiry)
+	case PropContentType:
+		dst = stringProp(dst, i.ContentType)
+	case PropResponseTopic:
+		dst = stringProp(dst, i.ResponseTopic)
+	case PropCorrelationData:
+		dst = binaryProp(dst, i.CorrelationData)
+	case PropTopicAlias:
+		dst = uint16Prop(dst, i.TopicAlias)
+	case PropSubscriptionIdentifier:
+		if i.SubscriptionIdentifier != nil {
+			dst = appendVBI(append(dst, id), *i.SubscriptionIdentifier)
+		}
+	case PropReceiveMaximum:
+		dst = uint16Prop(dst, i.ReceiveMaximum)
+	case PropTopicAliasMaximum:
+		dst = uint16Prop(dst, i.TopicAliasMaximum)
+	case PropMaximumQOS:
+		dst = byteProp(dst, i.MaximumQOS)
+	case PropMaximumPacketSize:
+		dst = uint32Prop(dst, i.MaximumPacketSize)
+	case PropAssignedClientID:
+		dst = stringProp(dst, i.AssignedClientID)
+	case PropServerKeepAlive:
+		dst = uint16Prop(dst, i.ServerKeepAlive)
+	case PropWildcardSubAvailable:
+		dst = byteProp(dst, i.WildcardSubAvailable)
+	case PropSubIDAvailable:
+		dst = byteProp(dst, i.SubIDAvailable)
+	case PropSharedSubAvailable:
+		dst = byteProp(dst, i.SharedSubAvailable)
+	case PropRetainAvailable:
+		dst = byteProp(dst, i.RetainAvailable)
+	case PropResponseInfo:
+		dst = stringProp(dst, i.ResponseInfo)
+	case PropRequestProblemInfo:
+		dst = byteProp(dst, i.RequestProblemInfo)
+	case PropWillDelayInterval:
+		dst = uint32Prop(dst, i.WillDelayInterval)
+	case PropRequestResponseInfo:
+		dst = byteProp(dst, i.RequestResponseInfo)
+	case PropSessionExpiryInterval:
+		dst = uint32Prop(dst, i.SessionExpiryInterval)
+	case PropAuthMethod:
+		dst = stringProp(dst, i.AuthMethod)
+	case PropAuthData:
+		dst = binaryProp(dst, i.AuthData)
+	case PropServerReference:
+		dst = stringProp(dst, i.ServerReference)
+	case PropReasonString:
+		dst = stringProp(dst, i.ReasonString)
+	case PropUser:
+		for _, v := range i.User {
+			dst = appendString(appendString(append(dst, PropUser), v.Key), v.Value)
 		}
 	}
 
-	if p == CONNECT || p == CONNACK || p == AUTH {
-		if i.AuthMethod != "" {
-			b.WriteByte(PropAuthMethod)
-			writeString(i.AuthMethod, &b)
-		}
+	return dst
+}
 
-		if i.AuthData != nil && len(i.AuthData) > 0 {
-			b.WriteByte(PropAuthData)
-			writeBinary(i.AuthData, &b)
-		}
+// Unpack takes a buffer of bytes and reads out the defined properties
+// filling in the appropriate entries in the struct, it returns the number
+// of bytes used to store the Prop data and any error in decoding them
+func (i *Properties) Unpack(r *bytes.Buffer, p PacketType) error {
+	t, field := p, "Properties"
+	if p == will {
+		t, field = CONNECT, "WillProperties"
+	}
+	fail := func(remaining int, err error) error {
+		return malformed(t, field, remaining, err)
 	}
 
-	if p == CONNACK || p == DISCONNECT {
-		if i.ServerReference != "" {
-			b.WriteByte(PropServerReference)
-			writeString(i.ServerReference, &b)
-		}
+	start := r.Len()
+	vbi, err := getVBI(r)
+	if err != nil {
+		return fail(start, err)
+	}
+	size, err := decodeVBI(vbi)
+	if err != nil {
+		return fail(start, err)
+	}
+	if size > r.Len() {
+		return fail(start, errPropertyOverflow)
 	}
 
-	if p != CONNECT {
-		if i.ReasonString != "" {
-			b.WriteByte(PropReasonString)
-			writeString(i.ReasonString, &b)
+	var seen [256]bool
+	end := r.Len() - size
+	for r.Len() > end {
+		start := r.Len()
+		propType, _ := r.ReadByte()
+		if !ValidateID(p, propType) {
+			return fail(start, fmt.Errorf("invalid Prop type %d for packet %d", propType, p))
+		}
+		if seen[propType] && propType != PropUser && (propType != PropSubscriptionIdentifier || p != PUBLISH) {
+			i.duplicates = append(i.duplicates, propType)
+		}
+		seen[propType] = true
+		if propType == PropUser {
+			if err := i.limits.userProperty(); err != nil {
+				return err
+			}
+		}
+		if err := i.unpackProperty(r, propType); err != nil {
+			return fail(start, err)
 		}
-	}
 
-	for _, v := range i.User {
-		b.WriteByte(PropUser)
-		writeString(v.Key, &b)
-		writeString(v.Value, &b)
+		if r.Len() < end {
+			return fail(start, errPropertyOverflow)
+		}
 	}
 
-	return b.Bytes()
+	return nil
 }
 
-// PackBuf will create a bytes.Buffer of the packed properties, it
-// will only pack the properties appropriate to the packet type p
-// even though other properties may exist, it will silently ignore
-// them
-func (i *Properties) PackBuf(p byte) *bytes.Buffer {
-	var b bytes.Buffer
-
-	if i == nil {
-		return nil
-	}
-
-	if p == PUBLISH {
-		if i.PayloadFormat != nil {
-			b.WriteByte(PropPayloadFormat)
-			b.WriteByte(*i.PayloadFormat)
+// unpackProperty reads the value of property propType, whose identifier was
+// already read from r
+func (i *Properties) unpackProperty(r *bytes.Buffer, propType byte) error {
+	switch propType {
+	case PropPayloadFormat:
+		pf, err := r.ReadByte()
+		if err != nil {
+			return err
 		}
-
-		if i.MessageExpiry != nil {
-			b.WriteByte(PropMessageExpiry)
-			writeUint32(*i.MessageExpiry, &b)
+		i.PayloadFormat = &pf
+	case PropMessageExpiry:
+		pe, err := readUint32(r)
+		if err != nil {
+			return err
 		}
-
-		if i.ContentType != "" {
-			b.WriteByte(PropContentType)
-			writeString(i.ContentType, &b)
+		i.MessageExpiry = &pe
+	case PropContentType:
+		ct, err := readString(r)
+		if err != nil {
+			return err
 		}
-
-		if i.ResponseTopic != "" {
-			b.WriteByte(PropResponseTopic)
-			writeString(i.ResponseTopic, &b)
+		i.ContentType = ct
+	case PropResponseTopic:
+		tr, err := readString(r)
+		if err != nil {
+			return err
 		}
-
-		if i.CorrelationData != nil && len(i.CorrelationData) > 0 {
-			b.WriteByte(PropCorrelationData)
-			writeBinary(i.CorrelationData, &b)
+		i.ResponseTopic = tr
+	case PropCorrelationData:
+		cd, err := readBinary(r)
+		if err != nil {
+			return err
 		}
-
-		if i.TopicAlias != nil {
-			b.WriteByte(PropTopicAlias)
-			writeUint16(*i.TopicAlias, &b)
+		i.CorrelationData = cd
+	case PropSubscriptionIdentifier:
+		si, err := decodeVBI(r)
+		if err != nil {
+			return err
 		}
-	}
-
-	if p == PUBLISH || p == SUBSCRIBE {
-		if i.SubscriptionIdentifier != nil {
-			b.WriteByte(PropSubscriptionIdentifier)
-			encodeVBIdirect(*i.SubscriptionIdentifier, &b)
+		i.SubscriptionIdentifier = &si
+	case PropSessionExpiryInterval:
+		se, err := readUint32(r)
+		if err != nil {
+			return err
 		}
-	}
-
-	if p == CONNECT || p == CONNACK {
-		if i.ReceiveMaximum != nil {
-			b.WriteByte(PropReceiveMaximum)
-			writeUint16(*i.ReceiveMaximum, &b)
+		i.SessionExpiryInterval = &se
+	case PropAssignedClientID:
+		ac, err := readString(r)
+		if err != nil {
+			return err
 		}
-
-		if i.TopicAliasMaximum != nil {
-			b.WriteByte(PropTopicAliasMaximum)
-			writeUint16(*i.TopicAliasMaximum, &b)
+		i.AssignedClientID = ac
+	case PropServerKeepAlive:
+		sk, err := readUint16(r)
+		if err != nil {
+			return err
 		}
-
-		if i.MaximumQOS != nil {
-			b.WriteByte(PropMaximumQOS)
-			b.WriteByte(*i.MaximumQOS)
+		i.ServerKeepAlive = &sk
+	case PropAuthMethod:
+		am, err := readString(r)
+		if err != nil {
+			return err
 		}
-
-		if i.MaximumPacketSize != nil {
-			b.WriteByte(PropMaximumPacketSize)
-			writeUint32(*i.MaximumPacketSize, &b)
+		i.AuthMethod = am
+	case PropAuthData:
+		ad, err := readBinary(r)
+		if err != nil {
+			return err
 		}
-	}
-
-	if p == CONNACK {
-		if i.AssignedClientID != "" {
-			b.WriteByte(PropAssignedClientID)
-			writeString(i.AssignedClientID, &b)
+		i.AuthData = ad
+	case PropRequestProblemInfo:
+		rp, err := r.ReadByte()
+		if err != nil {
+			return err
 		}
-
-		if i.ServerKeepAlive != nil {
-			b.WriteByte(PropServerKeepAlive)
-			writeUint16(*i.ServerKeepAlive, &b)
+		i.RequestProblemInfo = &rp
+	case PropWillDelayInterval:
+		wd, err := readUint32(r)
+		if err != nil {
+			return err
 		}
-
-		if i.WildcardSubAvailable != nil {
-			b.WriteByte(PropWildcardSubAvailable)
-			b.WriteByte(*i.WildcardSubAvailable)
+		i.WillDelayInterval = &wd
+	case PropRequestResponseInfo:
+		rp, err := r.ReadByte()
+		if err != nil {
+			return err
 		}
-
-		if i.SubIDAvailable != nil {
-			b.WriteByte(PropSubIDAvailable)
-			b.WriteByte(*i.SubIDAvailable)
+		i.RequestResponseInfo = &rp
+	case PropResponseInfo:
+		ri, err := readString(r)
+		if err != nil {
+			return err
 		}
-
-		if i.SharedSubAvailable != nil {
-			b.WriteByte(PropSharedSubAvailable)
-			b.WriteByte(*i.SharedSubAvailable)
+		i.ResponseInfo = ri
+	case PropServerReference:
+		sr, err := readString(r)
+		if err != nil {
+			return err
 		}
-
-		if i.RetainAvailable != nil {
-			b.WriteByte(PropRetainAvailable)
-			b.WriteByte(*i.RetainAvailable)
+		i.ServerReference = sr
+	case PropReasonString:
+		rs, err := readString(r)
+		if err != nil {
+			return err
 		}
-
-		if i.ResponseInfo != "" {
-			b.WriteByte(PropResponseInfo)
-			writeString(i.ResponseInfo, &b)
+		i.ReasonString = rs
+	case PropReceiveMaximum:
+		rm, err := readUint16(r)
+		if err != nil {
+			return err
 		}
-	}
-
-	if p == CONNECT {
-		if i.RequestProblemInfo != nil {
-			b.WriteByte(PropRequestProblemInfo)
-			b.WriteByte(*i.RequestProblemInfo)
+		i.ReceiveMaximum = &rm
+	case PropTopicAliasMaximum:
+		ta, err := readUint16(r)
+		if err != nil {
+			return err
 		}
-
-		if i.WillDelayInterval != nil {
-			b.WriteByte(PropWillDelayInterval)
-			writeUint32(*i.WillDelayInterval, &b)
+		i.TopicAliasMaximum = &ta
+	case PropTopicAlias:
+		ta, err := readUint16(r)
+		if err != nil {
+			return err
 		}
-
-		if i.RequestResponseInfo != nil {
-			b.WriteByte(PropRequestResponseInfo)
-			b.WriteByte(*i.RequestResponseInfo)
+		i.TopicAlias = &ta
+	case PropMaximumQOS:
+		mq, err := r.ReadByte()
+		if err != nil {
+			return err
 		}
-	}
-
-	if p == CONNECT || p == CONNACK || p == DISCONNECT {
-		if i.SessionExpiryInterval != nil {
-			b.WriteByte(PropSessionExpiryInterval)
-			writeUint32(*i.SessionExpiryInterval, &b)
+		i.MaximumQOS = &mq
+	case PropRetainAvailable:
+		ra, err := r.ReadByte()
+		if err != nil {
+			return err
 		}
-	}
-
-	if p == CONNECT || p == CONNACK || p == AUTH {
-		if i.AuthMethod != "" {
-			b.WriteByte(PropAuthMethod)
-			writeString(i.AuthMethod, &b)
+		i.RetainAvailable = &ra
+	case PropUser:
+		k, err := readString(r)
+		if err != nil {
+			return err
 		}
-
-		if i.AuthData != nil && len(i.AuthData) > 0 {
-			b.WriteByte(PropAuthData)
-			writeBinary(i.AuthData, &b)
+		v, err := readString(r)
+		if err != nil {
+			return err
 		}
-	}
-
-	if p == CONNACK || p == DISCONNECT {
-		if i.ServerReference != "" {
-			b.WriteByte(PropServerReference)
-			writeString(i.ServerReference, &b)
+		i.User = append(i.User, User{k, v})
+	case PropMaximumPacketSize:
+		mp, err := readUint32(r)
+		if err != nil {
+			return err
 		}
-	}
-
-	if p != CONNECT {
-		if i.ReasonString != "" {
-			b.WriteByte(PropReasonString)
-			writeString(i.ReasonString, &b)
+		i.MaximumPacketSize = &mp
+	case PropWildcardSubAvailable:
+		ws, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		i.WildcardSubAvailable = &ws
+	case PropSubIDAvailable:
+		si, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		i.SubIDAvailable = &si
+	case PropSharedSubAvailable:
+		ss, err := r.ReadByte()
+		if err != nil {
+			return err
 		}
+		i.SharedSubAvailable = &ss
+	default:
+		return fmt.Errorf("unknown Prop type %d", propType)
 	}
 
-	for _, v := range i.User {
-		b.WriteByte(PropUser)
-		writeString(v.Key, &b)
-		writeString(v.Value, &b)
-	}
+	return nil
+}
 
-	return &b
+// propertyNames maps property identifiers to the names of the Properties
+// fields holding them
+var propertyNames = map[byte]string{
+	PropPayloadFormat:          "PayloadFormat",
+	PropMessageExpiry:          "MessageExpiry",
+	PropContentType:            "ContentType",
+	PropResponseTopic:          "ResponseTopic",
+	PropCorrelationData:        "CorrelationData",
+	PropSubscriptionIdentifier: "SubscriptionIdentifier",
+	PropSessionExpiryInterval:  "SessionExpiryInterval",
+	PropAssignedClientID:       "AssignedClientID",
+	PropServerKeepAlive:        "ServerKeepAlive",
+	PropAuthMethod:             "AuthMethod",
+	PropAuthData:               "AuthData",
+	PropRequestProblemInfo:     "RequestProblemInfo",
+	PropWillDelayInterval:      "WillDelayInterval",
+	PropRequestResponseInfo:    "RequestResponseInfo",
+	PropResponseInfo:           "ResponseInfo",
+	PropServerReference:        "ServerReference",
+	PropReasonString:           "ReasonString",
+	PropReceiveMaximum:         "ReceiveMaximum",
+	PropTopicAliasMaximum:      "TopicAliasMaximum",
+	PropTopicAlias:             "TopicAlias",
+	PropMaximumQOS:             "MaximumQOS",
+	PropRetainAvailable:        "RetainAvailable",
+	PropUser:                   "User",
+	PropMaximumPacketSize:      "MaximumPacketSize",
+	PropWildcardSubAvailable:   "WildcardSubAvailable",
+	PropSubIDAvailable:         "SubIDAvailable",
+	PropSharedSubAvailable:     "SharedSubAvailable",
 }
 
-// Unpack takes a buffer of bytes and reads out the defined properties
-// filling in the appropriate entries in the struct, it returns the number
-// of bytes used to store the Prop data and any error in decoding them
-func (i *Properties) Unpack(r *bytes.Buffer, p byte) error {
-	vbi, err := getVBI(r)
-	if err != nil {
-		return err
-	}
-	size, err := decodeVBI(vbi)
-	if err != nil {
-		return err
-	}
-	if size == 0 {
-		return nil
+// DataType is the data type of a property value on the wire
+type DataType byte
+
+// TypeByte, etc are the data types of property values
+const (
+	TypeByte DataType = iota + 1
+	TypeUint16
+	TypeUint32
+	TypeVBI
+	TypeString
+	TypeBinary
+	TypeStringPair
+)
+
+// String returns the name the specification uses for the data type
+func (d DataType) String() string {
+	switch d {
+	case TypeByte:
+		return "Byte"
+	case TypeUint16:
+		return "Two Byte Integer"
+	case TypeUint32:
+		return "Four Byte Integer"
+	case TypeVBI:
+		return "Variable Byte Integer"
+	case TypeString:
+		return "UTF-8 Encoded String"
+	case TypeBinary:
+		return "Binary Data"
+	case TypeStringPair:
+		return "UTF-8 String Pair"
 	}
+	return fmt.Sprintf("data type %d", byte(d))
+}
 
-	buf := bytes.NewBuffer(r.Next(size))
-	for {
-		PropType, err := buf.ReadByte()
-		if err != nil && err != io.EOF {
-			return err
-		}
-		if err == io.EOF {
-			break
-		}
-		if !ValidateID(p, PropType) {
-			return fmt.Errorf("invalid Prop type %d for packet %d", PropType, p)
+// propertyTypes maps property identifiers to the data type of their values
+var propertyTypes = map[byte]DataType{
+	PropPayloadFormat:          TypeByte,
+	PropMessageExpiry:          TypeUint32,
+	PropContentType:            TypeString,
+	PropResponseTopic:          TypeString,
+	PropCorrelationData:        TypeBinary,
+	PropSubscriptionIdentifier: TypeVBI,
+	PropSessionExpiryInterval:  TypeUint32,
+	PropAssignedClientID:       TypeString,
+	PropServerKeepAlive:        TypeUint16,
+	PropAuthMethod:             TypeString,
+	PropAuthData:               TypeBinary,
+	PropRequestProblemInfo:     TypeByte,
+	PropWillDelayInterval:      TypeUint32,
+	PropRequestResponseInfo:    TypeByte,
+	PropResponseInfo:           TypeString,
+	PropServerReference:        TypeString,
+	PropReasonString:           TypeString,
+	PropReceiveMaximum:         TypeUint16,
+	PropTopicAliasMaximum:      TypeUint16,
+	PropTopicAlias:             TypeUint16,
+	PropMaximumQOS:             TypeByte,
+	PropRetainAvailable:        TypeByte,
+	PropUser:                   TypeStringPair,
+	PropMaximumPacketSize:      TypeUint32,
+	PropWildcardSubAvailable:   TypeByte,
+	PropSubIDAvailable:         TypeByte,
+	PropSharedSubAvailable:     TypeByte,
+}
+
+// propertyOrder is the order in which Pack writes the properties
+var propertyOrder = [...]byte{
+	PropPayloadFormat,
+	PropMessageExpiry,
+	PropContentType,
+	PropResponseTopic,
+	PropCorrelationData,
+	PropTopicAlias,
+	PropSubscriptionIdentifier,
+	PropReceiveMaximum,
+	PropTopicAliasMaximum,
+	PropMaximumQOS,
+	PropMaximumPacketSize,
+	PropAssignedClientID,
+	PropServerKeepAlive,
+	PropWildcardSubAvailable,
+	PropSubIDAvailable,
+	PropSharedSubAvailable,
+	PropRetainAvailable,
+	PropResponseInfo,
+	PropRequestProblemInfo,
+	PropWillDelayInterval,
+	PropRequestResponseInfo,
+	PropSessionExpiryInterval,
+	PropAuthMethod,
+	PropAuthData,
+	PropServerReference,
+	PropReasonString,
+	PropUser,
+}
+
+// encodedLen returns the number of bytes property id takes on the wire,
+// including the identifier, or 0 if the property is not set
+func (i *Properties) encodedLen(id byte) int {
+	prefixed := func(n int) int {
+		if n == 0 {
+			return 0
 		}
-		switch PropType {
-		case PropPayloadFormat:
-			pf, err := buf.ReadByte()
-			if err != nil {
-				return err
-			}
-			i.PayloadFormat = &pf
-		case PropMessageExpiry:
-			pe, err := readUint32(buf)
-			if err != nil {
-				return err
-			}
-			i.MessageExpiry = &pe
-		case PropContentType:
-			ct, err := readString(buf)
-			if err != nil {
-				return err
-			}
-			i.ContentType = ct
-		case PropResponseTopic:
-			tr, err := readString(buf)
-			if err != nil {
-				return err
-			}
-			i.ResponseTopic = tr
-		case PropCorrelationData:
-			cd, err := readBinary(buf)
-			if err != nil {
-				return err
-			}
-			i.CorrelationData = cd
-		case PropSubscriptionIdentifier:
-			si, err := decodeVBI(buf)
-			if err != nil {
-				return err
-			}
-			i.SubscriptionIdentifier = &si
-		case PropSessionExpiryInterval:
-			se, err := readUint32(buf)
-			if err != nil {
-				return err
-			}
-			i.SessionExpiryInterval = &se
-		case PropAssignedClientID:
-			ac, err := readString(buf)
-			if err != nil {
-				return err
-			}
-			i.AssignedClientID = ac
-		case PropServerKeepAlive:
-			sk, err := readUint16(buf)
-			if err != nil {
-				return err
-			}
-			i.ServerKeepAlive = &sk
-		case PropAuthMethod:
-			am, err := readString(buf)
-			if err != nil {
-				return err
-			}
-			i.AuthMethod = am
-		case PropAuthData:
-			ad, err := readBinary(buf)
-			if err != nil {
-				return err
-			}
-			i.AuthData = ad
-		case PropRequestProblemInfo:
-			rp, err := buf.ReadByte()
-			if err != nil {
-				return err
-			}
-			i.RequestProblemInfo = &rp
-		case PropWillDelayInterval:
-			wd, err := readUint32(buf)
-			if err != nil {
-				return err
-			}
-			i.WillDelayInterval = &wd
-		case PropRequestResponseInfo:
-			rp, err := buf.ReadByte()
-			if err != nil {
-				return err
-			}
-			i.RequestResponseInfo = &rp
-		case PropResponseInfo:
-			ri, err := readString(buf)
-			if err != nil {
-				return err
-			}
-			i.ResponseInfo = ri
-		case PropServerReference:
-			sr, err := readString(buf)
-			if err != nil {
-				return err
-			}
-			i.ServerReference = sr
-		case PropReasonString:
-			rs, err := readString(buf)
-			if err != nil {
-				return err
-			}
-			i.ReasonString = rs
-		case PropReceiveMaximum:
-			rm, err := readUint16(buf)
-			if err != nil {
-				return err
-			}
-			i.ReceiveMaximum = &rm
-		case PropTopicAliasMaximum:
-			ta, err := readUint16(buf)
-			if err != nil {
-				return err
-			}
-			i.TopicAliasMaximum = &ta
-		case PropTopicAlias:
-			ta, err := readUint16(buf)
-			if err != nil {
-				return err
-			}
-			i.TopicAlias = &ta
-		case PropMaximumQOS:
-			mq, err := buf.ReadByte()
-			if err != nil {
-				return err
-			}
-			i.MaximumQOS = &mq
-		case PropRetainAvailable:
-			ra, err := buf.ReadByte()
-			if err != nil {
-				return err
-			}
-			i.RetainAvailable = &ra
-		case PropUser:
-			k, err := readString(buf)
-			if err != nil {
-				return err
-			}
-			v, err := readString(buf)
-			if err != nil {
-				return err
-			}
-			i.User = append(i.User, User{k, v})
-		case PropMaximumPacketSize:
-			mp, err := readUint32(buf)
-			if err != nil {
-				return err
-			}
-			i.MaximumPacketSize = &mp
-		case PropWildcardSubAvailable:
-			ws, err := buf.ReadByte()
-			if err != nil {
-				return err
-			}
-			i.WildcardSubAvailable = &ws
-		case PropSubIDAvailable:
-			si, err := buf.ReadByte()
-			if err != nil {
-				return err
-			}
-			i.SubIDAvailable = &si
-		case PropSharedSubAvailable:
-			ss, err := buf.ReadByte()
-			if err != nil {
-				return err
-			}
-			i.SharedSubAvailable = &ss
-		default:
-			return fmt.Errorf("unknown Prop type %d", PropType)
+		return 3 + n
+	}
+	ptr := func(set bool, size int) int {
+		if !set {
+			return 0
 		}
+		return 1 + size
 	}
 
-	return nil
+	switch id {
+	case PropPayloadFormat:
+		return ptr(i.PayloadFormat != nil, 1)
+	case PropMessageExpiry:
+		return ptr(i.MessageExpiry != nil, 4)
+	case PropContentType:
+		return prefixed(len(i.ContentType))
+	case PropResponseTopic:
+		return prefixed(len(i.ResponseTopic))
+	case PropCorrelationData:
+		return prefixed(len(i.CorrelationData))
+	case PropTopicAlias:
+		return ptr(i.TopicAlias != nil, 2)
+	case PropSubscriptionIdentifier:
+		if i.SubscriptionIdentifier == nil {
+			return 0
+		}
+		return 1 + vbiLen(*i.SubscriptionIdentifier)
+	case PropReceiveMaximum:
+		return ptr(i.ReceiveMaximum != nil, 2)
+	case PropTopicAliasMaximum:
+		return ptr(i.TopicAliasMaximum != nil, 2)
+	case PropMaximumQOS:
+		return ptr(i.MaximumQOS != nil, 1)
+	case PropMaximumPacketSize:
+		return ptr(i.MaximumPacketSize != nil, 4)
+	case PropAssignedClientID:
+		return prefixed(len(i.AssignedClientID))
+	case PropServerKeepAlive:
+		return ptr(i.ServerKeepAlive != nil, 2)
+	case PropWildcardSubAvailable:
+		return ptr(i.WildcardSubAvailable != nil, 1)
+	case PropSubIDAvailable:
+		return ptr(i.SubIDAvailable != nil, 1)
+	case PropSharedSubAvailable:
+		return ptr(i.SharedSubAvailable != nil, 1)
+	case PropRetainAvailable:
+		return ptr(i.RetainAvailable != nil, 1)
+	case PropResponseInfo:
+		return prefixed(len(i.ResponseInfo))
+	case PropRequestProblemInfo:
+		return ptr(i.RequestProblemInfo != nil, 1)
+	case PropWillDelayInterval:
+		return ptr(i.WillDelayInterval != nil, 4)
+	case PropRequestResponseInfo:
+		return ptr(i.RequestResponseInfo != nil, 1)
+	case PropSessionExpiryInterval:
+		return ptr(i.SessionExpiryInterval != nil, 4)
+	case PropAuthMethod:
+		return prefixed(len(i.AuthMethod))
+	case PropAuthData:
+		return prefixed(len(i.AuthData))
+	case PropServerReference:
+		return prefixed(len(i.ServerReference))
+	case PropReasonString:
+		return prefixed(len(i.ReasonString))
+	case PropUser:
+		var n int
+		for _, u := range i.User {
+			n += 5 + len(u.Key) + len(u.Value)
+		}
+		return n
+	}
+
+	return 0
 }
 
-const will = byte(200)
+const will = PacketType(200)
+
+var errPropertyOverflow = errors.New("property exceeds the property length")
 
 // ValidProperties is a map of the various properties and the
 // PacketTypes that property is valid for.
-var ValidProperties = map[byte]map[byte]struct{}{
+var ValidProperties = map[byte]map[PacketType]struct{}{
 	PropPayloadFormat:          {PUBLISH: {}, will: {}},
 	PropMessageExpiry:          {PUBLISH: {}, will: {}},
 	PropContentType:            {PUBLISH: {}, will: {}},
@@ -712,7 +805,7 @@ var ValidProperties = map[byte]map[byte]struct{}{
 // ValidateID takes a PacketType and a property name and returns
 // a boolean indicating if that property is valid for that
 // PacketType
-func ValidateID(p byte, i byte) bool {
+func ValidateID(p PacketType, i byte) bool {
 	_, ok := ValidProperties[i][p]
 	return ok
 }
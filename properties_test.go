@@ -154,5 +154,5 @@ func BenchmarkPropertyCreationStruct(b *testing.B) {
 			CorrelationData: []byte("corelid"),
 		}
 	}
-	fmt.Sprintln(p)
+	_ = fmt.Sprintln(p)
 }
@@ -0,0 +1,314 @@
+package mqttpackets
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrPropertyValue is returned by Set for values that don't match the data
+// type of the property
+var ErrPropertyValue = errors.New("invalid property value")
+
+// PropertyValue is the value of a property, Type selects which of the other
+// fields holds it
+type PropertyValue struct {
+	Type DataType
+	// Uint holds the value of Byte, Two Byte Integer, Four Byte Integer and
+	// Variable Byte Integer properties
+	Uint uint32
+	// Text holds the value of UTF-8 Encoded String properties
+	Text string
+	// Bytes holds the value of Binary Data properties
+	Bytes []byte
+	// Pair holds the value of UTF-8 String Pair properties
+	Pair User
+}
+
+// ByteValue returns a Byte property value
+func ByteValue(b byte) PropertyValue {
+	return PropertyValue{Type: TypeByte, Uint: uint32(b)}
+}
+
+// Uint16Value returns a Two Byte Integer property value
+func Uint16Value(u uint16) PropertyValue {
+	return PropertyValue{Type: TypeUint16, Uint: uint32(u)}
+}
+
+// Uint32Value returns a Four Byte Integer property value
+func Uint32Value(u uint32) PropertyValue {
+	return PropertyValue{Type: TypeUint32, Uint: u}
+}
+
+// VBIValue returns a Variable Byte Integer property value
+func VBIValue(n int) PropertyValue {
+	return PropertyValue{Type: TypeVBI, Uint: uint32(n)}
+}
+
+// StringValue returns a UTF-8 Encoded String property value
+func StringValue(s string) PropertyValue {
+	return PropertyValue{Type: TypeString, Text: s}
+}
+
+// BinaryValue returns a Binary Data property value, b is not copied
+func BinaryValue(b []byte) PropertyValue {
+	return PropertyValue{Type: TypeBinary, Bytes: b}
+}
+
+// StringPairValue returns a UTF-8 String Pair property value
+func StringPairValue(key, value string) PropertyValue {
+	return PropertyValue{Type: TypeStringPair, Pair: User{Key: key, Value: value}}
+}
+
+// PropertyInfo describes a property
+type PropertyInfo struct {
+	ID byte
+	// Name is the name of the Properties field holding the property
+	Name string
+	Type DataType
+	// PacketTypes are the packet types the property is valid for, as listed
+	// in ValidProperties
+	PacketTypes []PacketType
+	// Will is set for properties that are valid in the will properties of
+	// a CONNECT packet
+	Will bool
+	// Repeatable is set for properties that may appear more than once in a
+	// packet, the Subscription Identifier only in PUBLISH
+	Repeatable bool
+}
+
+// propertyTable holds the PropertyInfo of every property, indexed by
+// identifier
+var propertyTable = newPropertyTable()
+
+// LookupProperty returns the PropertyInfo of property id and whether the
+// identifier is known, the returned info is a copy
+func LookupProperty(id byte) (PropertyInfo, bool) {
+	info, ok := propertyTable[id]
+	info.PacketTypes = append([]PacketType(nil), info.PacketTypes...)
+	return info, ok
+}
+
+// PropertyInfos returns the PropertyInfo of every property in the order Pack
+// writes them, the returned infos are copies
+func PropertyInfos() []PropertyInfo {
+	infos := make([]PropertyInfo, len(propertyOrder))
+	for i, id := range propertyOrder {
+		infos[i], _ = LookupProperty(id)
+	}
+	return infos
+}
+
+func newPropertyTable() map[byte]PropertyInfo {
+	table := make(map[byte]PropertyInfo, len(propertyNames))
+	for id, name := range propertyNames {
+		info := PropertyInfo{
+			ID:         id,
+			Name:       name,
+			Type:       propertyTypes[id],
+			Repeatable: id == PropUser || id == PropSubscriptionIdentifier,
+		}
+		for t := range ValidProperties[id] {
+			if t == will {
+				info.Will = true
+				continue
+			}
+			info.PacketTypes = append(info.PacketTypes, t)
+		}
+		sort.Slice(info.PacketTypes, func(i, j int) bool { return info.PacketTypes[i] < info.PacketTypes[j] })
+		table[id] = info
+	}
+	return table
+}
+
+// field returns a pointer to the field holding property id, or nil if the
+// identifier is unknown
+func (i *Properties) field(id byte) interface{} {
+	switch id {
+	case PropPayloadFormat:
+		return &i.PayloadFormat
+	case PropMessageExpiry:
+		return &i.MessageExpiry
+	case PropContentType:
+		return &i.ContentType
+	case PropResponseTopic:
+		return &i.ResponseTopic
+	case PropCorrelationData:
+		return &i.CorrelationData
+	case PropSubscriptionIdentifier:
+		return &i.SubscriptionIdentifier
+	case PropSessionExpiryInterval:
+		return &i.SessionExpiryInterval
+	case PropAssignedClientID:
+		return &i.AssignedClientID
+	case PropServerKeepAlive:
+		return &i.ServerKeepAlive
+	case PropAuthMethod:
+		return &i.AuthMethod
+	case PropAuthData:
+		return &i.AuthData
+	case PropRequestProblemInfo:
+		return &i.RequestProblemInfo
+	case PropWillDelayInterval:
+		return &i.WillDelayInterval
+	case PropRequestResponseInfo:
+		return &i.RequestResponseInfo
+	case PropResponseInfo:
+		return &i.ResponseInfo
+	case PropServerReference:
+		return &i.ServerReference
+	case PropReasonString:
+		return &i.ReasonString
+	case PropReceiveMaximum:
+		return &i.ReceiveMaximum
+	case PropTopicAliasMaximum:
+		return &i.TopicAliasMaximum
+	case PropTopicAlias:
+		return &i.TopicAlias
+	case PropMaximumQOS:
+		return &i.MaximumQOS
+	case PropRetainAvailable:
+		return &i.RetainAvailable
+	case PropUser:
+		return &i.User
+	case PropMaximumPacketSize:
+		return &i.MaximumPacketSize
+	case PropWildcardSubAvailable:
+		return &i.WildcardSubAvailable
+	case PropSubIDAvailable:
+		return &i.SubIDAvailable
+	case PropSharedSubAvailable:
+		return &i.SharedSubAvailable
+	}
+
+	return nil
+}
+
+// Get returns the value of property id and whether it is set, empty strings
+// and binary data count as not set like they do for Pack. For User
+// properties the first one is returned, use Each to get all of them.
+func (i *Properties) Get(id byte) (PropertyValue, bool) {
+	switch f := i.field(id).(type) {
+	case **byte:
+		if *f != nil {
+			return ByteValue(**f), true
+		}
+	case **uint16:
+		if *f != nil {
+			return Uint16Value(**f), true
+		}
+	case **uint32:
+		if *f != nil {
+			return Uint32Value(**f), true
+		}
+	case **int:
+		if *f != nil {
+			return VBIValue(**f), true
+		}
+	case *string:
+		if *f != "" {
+			return StringValue(*f), true
+		}
+	case *[]byte:
+		if len(*f) > 0 {
+			return BinaryValue(*f), true
+		}
+	case *[]User:
+		if len(*f) > 0 {
+			return StringPairValue((*f)[0].Key, (*f)[0].Value), true
+		}
+	}
+
+	return PropertyValue{}, false
+}
+
+// Set sets property id to v, User properties are added to the ones already
+// set. The returned error wraps ErrInvalidProperty if the identifier is
+// unknown, ErrPropertyValue if v doesn't fit the property's data type and
+// ErrFieldTooLong or ErrVBIOutOfRange if v can't be encoded.
+func (i *Properties) Set(id byte, v PropertyValue) error {
+	info, ok := propertyTable[id]
+	if !ok {
+		return fmt.Errorf("%w: unknown property %d", ErrInvalidProperty, id)
+	}
+	if v.Type != info.Type {
+		return fmt.Errorf("%w: %s is a %s property, got %s", ErrPropertyValue, info.Name, info.Type, v.Type)
+	}
+
+	switch f := i.field(id).(type) {
+	case **byte:
+		if v.Uint > 0xFF {
+			return fmt.Errorf("%w: %s value %d exceeds a byte", ErrPropertyValue, info.Name, v.Uint)
+		}
+		b := byte(v.Uint)
+		*f = &b
+	case **uint16:
+		if v.Uint > 0xFFFF {
+			return fmt.Errorf("%w: %s value %d exceeds two bytes", ErrPropertyValue, info.Name, v.Uint)
+		}
+		u := uint16(v.Uint)
+		*f = &u
+	case **uint32:
+		u := v.Uint
+		*f = &u
+	case **int:
+		if v.Uint > maxRemainingLength {
+			return ErrVBIOutOfRange
+		}
+		n := int(v.Uint)
+		*f = &n
+	case *string:
+		if len(v.Text) > 65535 {
+			return ErrFieldTooLong
+		}
+		*f = v.Text
+	case *[]byte:
+		if len(v.Bytes) > 65535 {
+			return ErrFieldTooLong
+		}
+		*f = v.Bytes
+	case *[]User:
+		if len(v.Pair.Key) > 65535 || len(v.Pair.Value) > 65535 {
+			return ErrFieldTooLong
+		}
+		*f = append(*f, v.Pair)
+	}
+
+	return nil
+}
+
+// Delete unsets property id, for User properties all of them are removed
+func (i *Properties) Delete(id byte) {
+	switch f := i.field(id).(type) {
+	case **byte:
+		*f = nil
+	case **uint16:
+		*f = nil
+	case **uint32:
+		*f = nil
+	case **int:
+		*f = nil
+	case *string:
+		*f = ""
+	case *[]byte:
+		*f = nil
+	case *[]User:
+		*f = nil
+	}
+}
+
+// Each calls fn for every set property in the order Pack writes them, once
+// for each User property
+func (i *Properties) Each(fn func(id byte, v PropertyValue)) {
+	for _, id := range propertyOrder {
+		if id == PropUser {
+			for _, u := range i.User {
+				fn(id, StringPairValue(u.Key, u.Value))
+			}
+			continue
+		}
+		if v, ok := i.Get(id); ok {
+			fn(id, v)
+		}
+	}
+}
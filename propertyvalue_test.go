@@ -0,0 +1,169 @@
+package mqttpackets
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// propertyValues returns a value for every property
+func propertyValues() map[byte]PropertyValue {
+	values := make(map[byte]PropertyValue)
+	for _, id := range propertyOrder {
+		info, _ := LookupProperty(id)
+		switch info.Type {
+		case TypeByte:
+			values[id] = ByteValue(1)
+		case TypeUint16:
+			values[id] = Uint16Value(uint16(id) << 8)
+		case TypeUint32:
+			values[id] = Uint32Value(uint32(id) << 24)
+		case TypeVBI:
+			values[id] = VBIValue(maxRemainingLength)
+		case TypeString:
+			values[id] = StringValue(info.Name)
+		case TypeBinary:
+			values[id] = BinaryValue([]byte(info.Name))
+		case TypeStringPair:
+			values[id] = StringPairValue("k", "v")
+		}
+	}
+	return values
+}
+
+func TestPropertiesGetSet(t *testing.T) {
+	values := propertyValues()
+	require.Len(t, values, len(propertyOrder))
+
+	props := &Properties{}
+	for id, v := range values {
+		_, ok := props.Get(id)
+		assert.False(t, ok, propertyNames[id])
+		require.NoError(t, props.Set(id, v), propertyNames[id])
+
+		got, ok := props.Get(id)
+		assert.True(t, ok, propertyNames[id])
+		assert.Equal(t, v, got)
+	}
+	assert.Equal(t, uint16(PropTopicAlias)<<8, *props.TopicAlias)
+	assert.Equal(t, "ContentType", props.ContentType)
+	assert.Equal(t, maxRemainingLength, *props.SubscriptionIdentifier)
+
+	// the wire format is unchanged by going through Each and Set
+	copied := &Properties{}
+	var ids []byte
+	props.Each(func(id byte, v PropertyValue) {
+		ids = append(ids, id)
+		require.NoError(t, copied.Set(id, v))
+	})
+	assert.Equal(t, propertyOrder[:], ids)
+	assert.Equal(t, props, copied)
+
+	for id := range values {
+		props.Delete(id)
+		_, ok := props.Get(id)
+		assert.False(t, ok, propertyNames[id])
+	}
+	assert.Equal(t, &Properties{}, props)
+}
+
+func TestPropertiesUser(t *testing.T) {
+	props := &Properties{}
+	require.NoError(t, props.Set(PropUser, StringPairValue("a", "1")))
+	require.NoError(t, props.Set(PropUser, StringPairValue("a", "2")))
+	assert.Equal(t, []User{{Key: "a", Value: "1"}, {Key: "a", Value: "2"}}, props.User)
+
+	v, ok := props.Get(PropUser)
+	assert.True(t, ok)
+	assert.Equal(t, StringPairValue("a", "1"), v)
+
+	var values []PropertyValue
+	props.Each(func(id byte, v PropertyValue) {
+		assert.Equal(t, PropUser, id)
+		values = append(values, v)
+	})
+	assert.Equal(t, []PropertyValue{StringPairValue("a", "1"), StringPairValue("a", "2")}, values)
+
+	props.Delete(PropUser)
+	assert.Nil(t, props.User)
+}
+
+func TestPropertiesSetErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		id   byte
+		v    PropertyValue
+		err  error
+	}{
+		{name: "unknown property", id: 0, v: ByteValue(1), err: ErrInvalidProperty},
+		{name: "wrong data type", id: PropTopicAlias, v: Uint32Value(1), err: ErrPropertyValue},
+		{name: "byte out of range", id: PropMaximumQOS, v: PropertyValue{Type: TypeByte, Uint: 256}, err: ErrPropertyValue},
+		{name: "uint16 out of range", id: PropReceiveMaximum, v: PropertyValue{Type: TypeUint16, Uint: 65536}, err: ErrPropertyValue},
+		{name: "VBI out of range", id: PropSubscriptionIdentifier, v: VBIValue(maxRemainingLength + 1), err: ErrVBIOutOfRange},
+		{name: "string too long", id: PropReasonString, v: StringValue(strings.Repeat("a", 65536)), err: ErrFieldTooLong},
+		{name: "binary too long", id: PropAuthData, v: BinaryValue(make([]byte, 65536)), err: ErrFieldTooLong},
+		{name: "user key too long", id: PropUser, v: StringPairValue(strings.Repeat("a", 65536), ""), err: ErrFieldTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			props := &Properties{}
+			err := props.Set(tt.id, tt.v)
+			assert.True(t, errors.Is(err, tt.err), "%v", err)
+			assert.Equal(t, &Properties{}, props)
+		})
+	}
+}
+
+func TestLookupProperty(t *testing.T) {
+	info, ok := LookupProperty(PropSubscriptionIdentifier)
+	assert.True(t, ok)
+	assert.Equal(t, PropertyInfo{
+		ID:          PropSubscriptionIdentifier,
+		Name:        "SubscriptionIdentifier",
+		Type:        TypeVBI,
+		PacketTypes: []PacketType{PUBLISH, SUBSCRIBE},
+		Repeatable:  true,
+	}, info)
+	info, ok = LookupProperty(PropWillDelayInterval)
+	assert.True(t, ok)
+	assert.Equal(t, PropertyInfo{
+		ID:   PropWillDelayInterval,
+		Name: "WillDelayInterval",
+		Type: TypeUint32,
+		Will: true,
+	}, info)
+	info, _ = LookupProperty(PropUser)
+	assert.True(t, info.Repeatable)
+	assert.True(t, info.Will)
+	assert.Equal(t, "UTF-8 String Pair", info.Type.String())
+
+	_, ok = LookupProperty(0)
+	assert.False(t, ok)
+
+	// changing the returned info doesn't affect Set
+	info, _ = LookupProperty(PropTopicAlias)
+	info.Type = TypeUint32
+	info.PacketTypes[0] = CONNECT
+	require.Error(t, (&Properties{}).Set(PropTopicAlias, Uint32Value(1)))
+	info, _ = LookupProperty(PropTopicAlias)
+	assert.Equal(t, []PacketType{PUBLISH}, info.PacketTypes)
+	PropertyInfos()[0].PacketTypes[0] = CONNECT
+	assert.Equal(t, []PacketType{PUBLISH}, PropertyInfos()[0].PacketTypes)
+
+	infos := PropertyInfos()
+	require.Len(t, infos, len(propertyOrder))
+	for i, id := range propertyOrder {
+		info, ok := LookupProperty(id)
+		require.True(t, ok)
+		assert.Equal(t, info, infos[i])
+		assert.Equal(t, id, info.ID)
+		for _, pt := range info.PacketTypes {
+			assert.True(t, ValidateID(pt, id), "%s in %s", info.Name, pt)
+		}
+		assert.Equal(t, len(ValidProperties[id]), len(info.PacketTypes)+map[bool]int{true: 1}[info.Will], info.Name)
+	}
+}
@@ -8,9 +8,9 @@ import (
 
 // Puback is the Variable Header definition for a Puback control packet
 type Puback struct {
-	Properties *Properties
-	PacketID   uint16
-	ReasonCode byte
+	Properties *Properties `json:"properties,omitempty"`
+	PacketID   uint16      `json:"packetID,omitempty"`
+	ReasonCode byte        `json:"reasonCode,omitempty"`
 }
 
 // PubackSuccess, etc are the list of valid puback reason codes.
@@ -26,29 +26,24 @@ const (
 	PubackPayloadFormatInvalid        = 0x99
 )
 
-//Unpack is the implementation of the interface required function for a packet
+// Unpack is the implementation of the interface required function for a packet
 func (p *Puback) Unpack(r *bytes.Buffer) error {
 	var err error
-	success := r.Len() == 2
-	noProps := r.Len() == 3
 	p.PacketID, err = readUint16(r)
 	if err != nil {
-		return err
+		return malformed(PUBACK, "PacketID", r.Len(), err)
+	}
+	// v5 packets can leave out the reason code and the properties
+	if p.Properties == nil || r.Len() == 0 {
+		return nil
 	}
-	if !success && p.Properties != nil {
-		p.ReasonCode, err = r.ReadByte()
-		if err != nil {
-			return err
-		}
 
-		if !noProps {
-			err = p.Properties.Unpack(r, PUBACK)
-			if err != nil {
-				return err
-			}
-		}
+	p.ReasonCode, _ = r.ReadByte()
+	if r.Len() == 0 {
+		return nil
 	}
-	return nil
+
+	return p.Properties.Unpack(r, PUBACK)
 }
 
 // Buffers is the implementation of the interface required function for a packet
@@ -59,14 +54,56 @@ func (p *Puback) Buffers() net.Buffers {
 		return net.Buffers{b.Bytes()}
 	}
 
-	b.WriteByte(p.ReasonCode)
 	idvp := p.Properties.Pack(PUBACK)
-	propLen := encodeVBI(len(idvp))
-	n := net.Buffers{b.Bytes(), propLen}
-	if len(idvp) > 0 {
-		n = append(n, idvp)
+	if len(idvp) == 0 {
+		if p.ReasonCode != PubackSuccess {
+			b.WriteByte(p.ReasonCode)
+		}
+		return net.Buffers{b.Bytes()}
+	}
+
+	b.WriteByte(p.ReasonCode)
+	encodeVBIdirect(len(idvp), &b)
+	return net.Buffers{b.Bytes(), idvp}
+}
+
+func (p *Puback) size() int {
+	return ackLen(PUBACK, p.ReasonCode, p.Properties)
+}
+
+func (p *Puback) appendTo(dst []byte) []byte {
+	return appendAck(dst, PUBACK, p.PacketID, p.ReasonCode, p.Properties)
+}
+
+// ackLen returns the length of the body of a PUBACK, PUBREC, PUBREL or
+// PUBCOMP, which leave out a success reason code without properties
+func ackLen(t PacketType, reasonCode byte, props *Properties) int {
+	if props == nil {
+		return 2
+	}
+
+	idvp := props.packedLen(t)
+	if idvp == 0 {
+		if reasonCode != PubackSuccess {
+			return 3
+		}
+		return 2
+	}
+
+	return 3 + vbiLen(idvp) + idvp
+}
+
+// appendAck appends the body of a PUBACK, PUBREC, PUBREL or PUBCOMP to dst
+func appendAck(dst []byte, t PacketType, packetID uint16, reasonCode byte, props *Properties) []byte {
+	dst = appendUint16(dst, packetID)
+	switch n := ackLen(t, reasonCode, props); {
+	case n == 2:
+		return dst
+	case n == 3:
+		return append(dst, reasonCode)
 	}
-	return n
+
+	return props.appendPrefixed(append(dst, reasonCode), t)
 }
 
 // WriteTo is the implementation of the interface required function for a packet
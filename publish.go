@@ -3,19 +3,18 @@ package mqttpackets
 import (
 	"bytes"
 	"io"
-	"io/ioutil"
 	"net"
 )
 
 // Publish is the Variable Header definition for a publish control packet
 type Publish struct {
-	Payload    []byte
-	Topic      string
-	Properties *Properties
-	PacketID   uint16
-	QoS        byte
-	Duplicate  bool
-	Retain     bool
+	Payload    []byte      `json:"payload,omitempty"`
+	Topic      string      `json:"topic,omitempty"`
+	Properties *Properties `json:"properties,omitempty"`
+	PacketID   uint16      `json:"packetID,omitempty"`
+	QoS        byte        `json:"qos,omitempty"`
+	Duplicate  bool        `json:"duplicate,omitempty"`
+	Retain     bool        `json:"retain,omitempty"`
 }
 
 //Unpack is the implementation of the interface required function for a packet
@@ -23,12 +22,12 @@ func (p *Publish) Unpack(r *bytes.Buffer) error {
 	var err error
 	p.Topic, err = readString(r)
 	if err != nil {
-		return err
+		return malformed(PUBLISH, "Topic", r.Len(), err)
 	}
 	if p.QoS > 0 {
 		p.PacketID, err = readUint16(r)
 		if err != nil {
-			return err
+			return malformed(PUBLISH, "PacketID", r.Len(), err)
 		}
 	}
 
@@ -39,10 +38,7 @@ func (p *Publish) Unpack(r *bytes.Buffer) error {
 		}
 	}
 
-	p.Payload, err = ioutil.ReadAll(r)
-	if err != nil {
-		return err
-	}
+	p.Payload = r.Next(r.Len())
 
 	return nil
 }
@@ -63,8 +59,33 @@ func (p *Publish) Buffers() net.Buffers {
 	return net.Buffers{b.Bytes(), idvp, p.Payload}
 }
 
+func (p *Publish) size() int {
+	n := 2 + len(p.Topic) + p.Properties.prefixedLen(PUBLISH) + len(p.Payload)
+	if p.QoS > 0 {
+		n += 2
+	}
+	return n
+}
+
+func (p *Publish) appendTo(dst []byte) []byte {
+	dst = appendString(dst, p.Topic)
+	if p.QoS > 0 {
+		dst = appendUint16(dst, p.PacketID)
+	}
+	dst = p.Properties.appendPrefixed(dst, PUBLISH)
+	return append(dst, p.Payload...)
+}
+
 // WriteTo is the implementation of the interface required function for a packet
 func (p *Publish) WriteTo(w io.Writer) (int64, error) {
+	cp := &ControlPacket{FixedHeader: FixedHeader{Type: PUBLISH, Flags: p.flags()}}
+	cp.Content = p
+
+	return cp.WriteTo(w)
+}
+
+// flags returns the fixed header flags for the publish
+func (p *Publish) flags() byte {
 	f := p.QoS << 1
 	if p.Duplicate {
 		f |= 1 << 3
@@ -73,8 +94,5 @@ func (p *Publish) WriteTo(w io.Writer) (int64, error) {
 		f |= 1
 	}
 
-	cp := &ControlPacket{FixedHeader: FixedHeader{Type: PUBLISH, Flags: f}}
-	cp.Content = p
-
-	return cp.WriteTo(w)
+	return f
 }
@@ -0,0 +1,271 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"io"
+	"net"
+)
+
+// PublishView is a lazily decoded PUBLISH packet for routing. The topic,
+// flags and packet ID are decoded up front, the properties and payload only
+// when they are requested. The view is read only and aliases the frame it
+// was created from, it can be written out again without being re-encoded.
+type PublishView struct {
+	topic    string
+	fixed    []byte
+	body     []byte
+	props    *Properties
+	propsErr error
+	// propsStart and payloadStart are offsets in body
+	propsStart   int
+	payloadStart int
+	version      Version
+	packetID     uint16
+	decoded      bool
+}
+
+// DecodePublishView decodes the PUBLISH packet at the start of frame into
+// a view and returns it together with the number of bytes consumed.
+// io.ErrUnexpectedEOF is returned if frame does not hold a complete packet.
+func DecodePublishView(frame []byte, v Version) (*PublishView, int, error) {
+	if len(frame) == 0 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if PacketType(frame[0]>>4) != PUBLISH {
+		return nil, 0, &MalformedPacketError{
+			PacketType: PacketType(frame[0] >> 4),
+			Field:      "PacketType",
+			ReasonCode: reasonMalformedPacket,
+			Err:        ErrInvalidPacketType,
+		}
+	}
+
+	n := frameLen(frame)
+	if n == 0 || n > len(frame) {
+		// let DecodePacket report a malformed or incomplete fixed header
+		_, _, err := DecodePacket(frame, v)
+		return nil, 0, err
+	}
+	headerLen := 2
+	for frame[headerLen-1]&0x80 != 0 {
+		headerLen++
+	}
+
+	pv, err := newPublishView(frame[:headerLen], frame[headerLen:n], v)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return pv, n, nil
+}
+
+// PublishView decodes the frame into a PublishView, the view aliases Body
+func (f *RawFrame) PublishView(v Version) (*PublishView, error) {
+	if f.Type() != PUBLISH {
+		return nil, &MalformedPacketError{
+			PacketType: f.Type(),
+			Field:      "PacketType",
+			ReasonCode: reasonMalformedPacket,
+			Err:        ErrInvalidPacketType,
+		}
+	}
+
+	fixed := append([]byte{f.Header}, encodeVBI(len(f.Body))...)
+	return newPublishView(fixed, f.Body, v)
+}
+
+func newPublishView(fixed, body []byte, v Version) (*PublishView, error) {
+	pv := &PublishView{fixed: fixed, body: body, version: v}
+	frameLen := len(fixed) + len(body)
+	fail := func(field string, remaining int, err error) error {
+		return setErrorOffset(malformed(PUBLISH, field, remaining, err), frameLen)
+	}
+
+	r := bytes.NewBuffer(body)
+	var err error
+	if pv.topic, err = readString(r); err != nil {
+		return nil, fail("Topic", r.Len(), err)
+	}
+	if pv.QoS() > 0 {
+		if pv.packetID, err = readUint16(r); err != nil {
+			return nil, fail("PacketID", r.Len(), err)
+		}
+	}
+
+	pv.propsStart = len(body) - r.Len()
+	if v == MQTTv5 {
+		start := r.Len()
+		vbi, err := getVBI(r)
+		if err != nil {
+			return nil, fail("Properties", start, err)
+		}
+		size, err := decodeVBI(vbi)
+		if err != nil {
+			return nil, fail("Properties", start, err)
+		}
+		if size > r.Len() {
+			return nil, fail("Properties", start, errPropertyOverflow)
+		}
+		r.Next(size)
+	}
+	pv.payloadStart = len(body) - r.Len()
+
+	return pv, nil
+}
+
+// Topic returns the topic name, it is empty if a v5 topic alias is used
+func (p *PublishView) Topic() string {
+	return p.topic
+}
+
+// QoS returns the QoS of the packet
+func (p *PublishView) QoS() byte {
+	return p.fixed[0] >> 1 & 3
+}
+
+// Retain returns the retain flag of the packet
+func (p *PublishView) Retain() bool {
+	return p.fixed[0]&0x01 > 0
+}
+
+// Duplicate returns the DUP flag of the packet
+func (p *PublishView) Duplicate() bool {
+	return p.fixed[0]&0x08 > 0
+}
+
+// PacketID returns the packet identifier, 0 for QoS 0 packets
+func (p *PublishView) PacketID() uint16 {
+	return p.packetID
+}
+
+// Properties decodes and returns the properties of the packet, they are
+// decoded once and nil for versions before v5
+func (p *PublishView) Properties() (*Properties, error) {
+	if p.version != MQTTv5 {
+		return nil, nil
+	}
+	if !p.decoded {
+		p.decoded = true
+		props := &Properties{}
+		err := props.Unpack(bytes.NewBuffer(p.body[p.propsStart:p.payloadStart]), PUBLISH)
+		if err != nil {
+			p.propsErr = setErrorOffset(err, len(p.fixed)+p.payloadStart)
+		} else {
+			p.props = props
+		}
+	}
+
+	return p.props, p.propsErr
+}
+
+// Payload returns the payload of the packet, it aliases the frame
+func (p *PublishView) Payload() []byte {
+	return p.body[p.payloadStart:]
+}
+
+// Publish decodes the whole packet, modify the returned Publish to change it
+func (p *PublishView) Publish() (*Publish, error) {
+	props, err := p.Properties()
+	if err != nil {
+		return nil, err
+	}
+	if props != nil {
+		copied := *props
+		props = &copied
+	}
+
+	return &Publish{
+		Payload:    p.Payload(),
+		Topic:      p.topic,
+		Properties: props,
+		PacketID:   p.packetID,
+		QoS:        p.QoS(),
+		Duplicate:  p.Duplicate(),
+		Retain:     p.Retain(),
+	}, nil
+}
+
+// Buffers returns the original frame, the fixed header and the body
+func (p *PublishView) Buffers() net.Buffers {
+	return net.Buffers{p.fixed, p.body}
+}
+
+// WriteTo writes the original frame to w
+func (p *PublishView) WriteTo(w io.Writer) (int64, error) {
+	buffers := p.Buffers()
+	return buffers.WriteTo(w)
+}
+
+// PublishHeader holds the fields of a PUBLISH packet that come before the
+// payload, see PublishView.Rewrite
+type PublishHeader struct {
+	// Properties replaces the properties of a v5 packet, nil keeps the
+	// original properties without decoding them
+	Properties *Properties
+	Topic      string
+	PacketID   uint16
+	QoS        byte
+	Duplicate  bool
+	Retain     bool
+}
+
+// Header returns the header fields of the packet, Properties is nil
+func (p *PublishView) Header() PublishHeader {
+	return PublishHeader{
+		Topic:     p.topic,
+		PacketID:  p.packetID,
+		QoS:       p.QoS(),
+		Duplicate: p.Duplicate(),
+		Retain:    p.Retain(),
+	}
+}
+
+// Rewrite returns a new frame with the header fields of h and the payload of
+// the packet. Only the headers are encoded, the payload and the properties,
+// unless h replaces them, are reused from the original frame. The packet ID
+// is left out when the QoS is 0. An *EncodeError is returned if h can't be
+// encoded.
+func (p *PublishView) Rewrite(h PublishHeader) (net.Buffers, error) {
+	ec := &encodeChecker{t: PUBLISH}
+	ec.qos("QoS", h.QoS)
+	ec.length("Topic", len(h.Topic))
+	if h.Properties != nil && p.version != MQTTv5 {
+		ec.fail("Properties", ErrInvalidProperty)
+	}
+	ec.properties(PUBLISH, "Properties", h.Properties)
+	if ec.err != nil {
+		return nil, ec.err
+	}
+
+	var vh bytes.Buffer
+	writeString(h.Topic, &vh)
+	if h.QoS > 0 {
+		writeUint16(h.PacketID, &vh)
+	}
+	props := p.body[p.propsStart:p.payloadStart]
+	if h.Properties != nil {
+		idvp := h.Properties.Pack(PUBLISH)
+		encodeVBIdirect(len(idvp), &vh)
+		vh.Write(idvp)
+		props = nil
+	}
+
+	payload := p.Payload()
+	remaining := vh.Len() + len(props) + len(payload)
+	if remaining > maxRemainingLength {
+		return nil, &EncodeError{PacketType: PUBLISH, Field: "RemainingLength", Err: ErrVBIOutOfRange}
+	}
+
+	flags := (&Publish{QoS: h.QoS, Duplicate: h.Duplicate, Retain: h.Retain}).flags()
+	header := make([]byte, 1, 1+maxVBILen+vh.Len())
+	header[0] = byte(PUBLISH)<<4 | flags
+	header = append(header, encodeVBI(remaining)...)
+	header = append(header, vh.Bytes()...)
+
+	buffers := net.Buffers{header}
+	if len(props) > 0 {
+		buffers = append(buffers, props)
+	}
+
+	return append(buffers, payload), nil
+}
@@ -0,0 +1,187 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishView(t *testing.T) {
+	for _, v := range []Version{MQTTv311, MQTTv5} {
+		cp := NewControlPacket(PUBLISH, v)
+		p := cp.Content.(*Publish)
+		p.Topic = "a/b"
+		p.QoS = 1
+		p.PacketID = 12
+		p.Retain = true
+		p.Payload = []byte("payload")
+		if v == MQTTv5 {
+			p.Properties.ContentType = "text/plain"
+			p.Properties.User = []User{{Key: "k", Value: "v"}}
+		}
+
+		var b bytes.Buffer
+		_, err := cp.WriteTo(&b)
+		require.NoError(t, err)
+		frame := append(b.Bytes(), 0xC0, 0)
+
+		pv, n, err := DecodePublishView(frame, v)
+		require.NoError(t, err)
+		assert.Equal(t, b.Len(), n)
+		assert.Equal(t, "a/b", pv.Topic())
+		assert.Equal(t, byte(1), pv.QoS())
+		assert.Equal(t, uint16(12), pv.PacketID())
+		assert.True(t, pv.Retain())
+		assert.False(t, pv.Duplicate())
+		assert.Equal(t, []byte("payload"), pv.Payload())
+
+		props, err := pv.Properties()
+		require.NoError(t, err)
+		assert.Equal(t, p.Properties, props)
+
+		publish, err := pv.Publish()
+		require.NoError(t, err)
+		assert.Equal(t, p, publish)
+
+		var out bytes.Buffer
+		_, err = pv.WriteTo(&out)
+		require.NoError(t, err)
+		assert.Equal(t, frame[:n], out.Bytes())
+	}
+}
+
+func TestPublishViewKeepsOriginalBytes(t *testing.T) {
+	// the remaining length uses two bytes where one would do
+	frame := []byte{0x30, 0x85, 0x00, 0, 1, 'a', 'x', 'y'}
+
+	pv, n, err := DecodePublishView(frame, MQTTv311)
+	require.NoError(t, err)
+	assert.Equal(t, len(frame), n)
+	assert.Equal(t, []byte("xy"), pv.Payload())
+	assert.Equal(t, frame, bytes.Join(pv.Buffers(), nil))
+}
+
+func TestPublishViewLazyProperties(t *testing.T) {
+	// the unknown property ID 0x30 is only found once the properties are
+	// decoded
+	frame := []byte{0x30, 7, 0, 1, 'a', 2, 0x30, 0, 'x'}
+
+	pv, _, err := DecodePublishView(frame, MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, "a", pv.Topic())
+	assert.Equal(t, []byte("x"), pv.Payload())
+
+	_, err = pv.Properties()
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, 6, malformedErr.Offset)
+
+	_, err = pv.Publish()
+	assert.Error(t, err)
+}
+
+func TestPublishViewErrors(t *testing.T) {
+	_, _, err := DecodePublishView([]byte{0xC0, 0}, MQTTv5)
+	assert.True(t, errors.Is(err, ErrInvalidPacketType))
+
+	_, _, err = DecodePublishView([]byte{0x30, 5, 0, 1}, MQTTv5)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, _, err = DecodePublishView([]byte{0x30, 4, 0, 1, 'a', 9}, MQTTv5)
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, "Properties", malformedErr.Field)
+	assert.Equal(t, 5, malformedErr.Offset)
+
+	f := RawFrame{Header: 0x32, Body: []byte{0, 1, 'a', 0, 9, 0, 'x'}}
+	pv, err := f.PublishView(MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(9), pv.PacketID())
+	assert.Equal(t, []byte("x"), pv.Payload())
+}
+
+func TestPublishViewRewrite(t *testing.T) {
+	cp := NewControlPacket(PUBLISH, MQTTv5)
+	p := cp.Content.(*Publish)
+	p.Topic = "sensors/temp"
+	p.QoS = 1
+	p.PacketID = 3
+	p.Retain = true
+	p.Payload = bytes.Repeat([]byte("x"), 200)
+	p.Properties.ContentType = "text/plain"
+
+	var b bytes.Buffer
+	_, err := cp.WriteTo(&b)
+	require.NoError(t, err)
+	frame := b.Bytes()
+
+	pv, _, err := DecodePublishView(frame, MQTTv5)
+	require.NoError(t, err)
+
+	h := pv.Header()
+	h.Topic = "tenant-a/" + h.Topic
+	h.QoS = 0
+	h.Retain = false
+	buffers, err := pv.Rewrite(h)
+	require.NoError(t, err)
+
+	// the payload is not copied
+	payload := buffers[len(buffers)-1]
+	assert.Same(t, &frame[len(frame)-200], &payload[0])
+
+	decoded, n, err := DecodePacket(bytes.Join(buffers, nil), MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, len(bytes.Join(buffers, nil)), n)
+	assert.Equal(t, &Publish{
+		Topic:      "tenant-a/sensors/temp",
+		Payload:    p.Payload,
+		Properties: &Properties{ContentType: "text/plain"},
+	}, decoded.Content)
+
+	// replace the properties and raise the QoS again
+	h.QoS = 2
+	h.PacketID = 9
+	h.Properties = &Properties{User: []User{{Key: "tenant", Value: "a"}}}
+	buffers, err = pv.Rewrite(h)
+	require.NoError(t, err)
+
+	decoded, _, err = DecodePacket(bytes.Join(buffers, nil), MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, &Publish{
+		Topic:      "tenant-a/sensors/temp",
+		QoS:        2,
+		PacketID:   9,
+		Payload:    p.Payload,
+		Properties: &Properties{User: []User{{Key: "tenant", Value: "a"}}},
+	}, decoded.Content)
+
+	// the view still holds the original packet
+	assert.Equal(t, "sensors/temp", pv.Topic())
+	assert.Equal(t, frame, bytes.Join(pv.Buffers(), nil))
+}
+
+func TestPublishViewRewriteErrors(t *testing.T) {
+	pv, _, err := DecodePublishView([]byte{0x30, 4, 0, 1, 'a', 'x'}, MQTTv311)
+	require.NoError(t, err)
+
+	h := pv.Header()
+	h.QoS = 3
+	_, err = pv.Rewrite(h)
+	assert.True(t, errors.Is(err, ErrInvalidQoS))
+
+	h = pv.Header()
+	h.Properties = &Properties{}
+	_, err = pv.Rewrite(h)
+	assert.True(t, errors.Is(err, ErrInvalidProperty))
+
+	h = pv.Header()
+	h.Topic = string(make([]byte, 70000))
+	_, err = pv.Rewrite(h)
+	var encErr *EncodeError
+	require.True(t, errors.As(err, &encErr))
+	assert.Equal(t, "Topic", encErr.Field)
+}
@@ -8,9 +8,9 @@ import (
 
 // Pubrec is the Variable Header definition for a Pubrec control packet
 type Pubrec struct {
-	Properties *Properties
-	PacketID   uint16
-	ReasonCode byte
+	Properties *Properties `json:"properties,omitempty"`
+	PacketID   uint16      `json:"packetID,omitempty"`
+	ReasonCode byte        `json:"reasonCode,omitempty"`
 }
 
 // PubrecSuccess, etc are the list of valid Pubrec reason codes
@@ -26,30 +26,24 @@ const (
 	PubrecPayloadFormatInvalid        = 0x99
 )
 
-//Unpack is the implementation of the interface required function for a packet
+// Unpack is the implementation of the interface required function for a packet
 func (p *Pubrec) Unpack(r *bytes.Buffer) error {
 	var err error
-	success := r.Len() == 2
-	noProps := r.Len() == 3
 	p.PacketID, err = readUint16(r)
 	if err != nil {
-		return err
+		return malformed(PUBREC, "PacketID", r.Len(), err)
+	}
+	// v5 packets can leave out the reason code and the properties
+	if p.Properties == nil || r.Len() == 0 {
+		return nil
 	}
-	if !success && p.Properties != nil {
-		p.ReasonCode, err = r.ReadByte()
-		if err != nil {
-			return err
-		}
 
-		if !noProps {
-			err = p.Properties.Unpack(r, PUBACK)
-			if err != nil {
-				return err
-			}
-		}
+	p.ReasonCode, _ = r.ReadByte()
+	if r.Len() == 0 {
+		return nil
 	}
 
-	return nil
+	return p.Properties.Unpack(r, PUBREC)
 }
 
 // Buffers is the implementation of the interface required function for a packet
@@ -60,15 +54,25 @@ func (p *Pubrec) Buffers() net.Buffers {
 		return net.Buffers{b.Bytes()}
 	}
 
-	b.WriteByte(p.ReasonCode)
-	n := net.Buffers{b.Bytes()}
 	idvp := p.Properties.Pack(PUBREC)
-	propLen := encodeVBI(len(idvp))
-	if len(idvp) > 0 {
-		n = append(n, propLen)
-		n = append(n, idvp)
+	if len(idvp) == 0 {
+		if p.ReasonCode != PubrecSuccess {
+			b.WriteByte(p.ReasonCode)
+		}
+		return net.Buffers{b.Bytes()}
 	}
-	return n
+
+	b.WriteByte(p.ReasonCode)
+	encodeVBIdirect(len(idvp), &b)
+	return net.Buffers{b.Bytes(), idvp}
+}
+
+func (p *Pubrec) size() int {
+	return ackLen(PUBREC, p.ReasonCode, p.Properties)
+}
+
+func (p *Pubrec) appendTo(dst []byte) []byte {
+	return appendAck(dst, PUBREC, p.PacketID, p.ReasonCode, p.Properties)
 }
 
 // WriteTo is the implementation of the interface required function for a packet
@@ -8,34 +8,35 @@ import (
 
 // Pubrel is the Variable Header definition for a Pubrel control packet
 type Pubrel struct {
-	Properties *Properties
-	PacketID   uint16
-	ReasonCode byte
+	Properties *Properties `json:"properties,omitempty"`
+	PacketID   uint16      `json:"packetID,omitempty"`
+	ReasonCode byte        `json:"reasonCode,omitempty"`
 }
 
-//Unpack is the implementation of the interface required function for a packet
+// PubrelSuccess, etc are the list of valid pubrel reason codes.
+const (
+	PubrelSuccess                  = 0x00
+	PubrelPacketIdentifierNotFound = 0x92
+)
+
+// Unpack is the implementation of the interface required function for a packet
 func (p *Pubrel) Unpack(r *bytes.Buffer) error {
 	var err error
-	success := r.Len() == 2
-	noProps := r.Len() == 3
 	p.PacketID, err = readUint16(r)
 	if err != nil {
-		return err
+		return malformed(PUBREL, "PacketID", r.Len(), err)
+	}
+	// v5 packets can leave out the reason code and the properties
+	if p.Properties == nil || r.Len() == 0 {
+		return nil
 	}
-	if !success && p.Properties != nil {
-		p.ReasonCode, err = r.ReadByte()
-		if err != nil {
-			return err
-		}
 
-		if !noProps {
-			err = p.Properties.Unpack(r, PUBACK)
-			if err != nil {
-				return err
-			}
-		}
+	p.ReasonCode, _ = r.ReadByte()
+	if r.Len() == 0 {
+		return nil
 	}
-	return nil
+
+	return p.Properties.Unpack(r, PUBREL)
 }
 
 // Buffers is the implementation of the interface required function for a packet
@@ -46,15 +47,25 @@ func (p *Pubrel) Buffers() net.Buffers {
 		return net.Buffers{b.Bytes()}
 	}
 
-	b.WriteByte(p.ReasonCode)
-	n := net.Buffers{b.Bytes()}
 	idvp := p.Properties.Pack(PUBREL)
-	propLen := encodeVBI(len(idvp))
-	if len(idvp) > 0 {
-		n = append(n, propLen)
-		n = append(n, idvp)
+	if len(idvp) == 0 {
+		if p.ReasonCode != PubrelSuccess {
+			b.WriteByte(p.ReasonCode)
+		}
+		return net.Buffers{b.Bytes()}
 	}
-	return n
+
+	b.WriteByte(p.ReasonCode)
+	encodeVBIdirect(len(idvp), &b)
+	return net.Buffers{b.Bytes(), idvp}
+}
+
+func (p *Pubrel) size() int {
+	return ackLen(PUBREL, p.ReasonCode, p.Properties)
+}
+
+func (p *Pubrel) appendTo(dst []byte) []byte {
+	return appendAck(dst, PUBREL, p.PacketID, p.ReasonCode, p.Properties)
 }
 
 // WriteTo is the implementation of the interface required function for a packet
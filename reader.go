@@ -0,0 +1,199 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ReaderOptions holds the limits enforced by ReadPacketWithOptions and
+// DecodePacketWithOptions. A limit set to 0 is not enforced.
+type ReaderOptions struct {
+	// MaxPacketSize is the maximum size of a whole packet in bytes, including
+	// the fixed header. It has the same meaning as the v5 Maximum Packet Size
+	// property, so the value sent in CONNECT or CONNACK can be used directly.
+	MaxPacketSize int
+	// MaxUserProperties is the maximum number of user properties allowed in
+	// a single packet, counting both the packet and will properties of CONNECT.
+	MaxUserProperties int
+	// MaxSubscriptions is the maximum number of topic filters allowed in a
+	// single SUBSCRIBE or UNSUBSCRIBE packet.
+	MaxSubscriptions int
+	// Strict enables validation of every packet against the normative
+	// statements of the specification, see ControlPacket.Validate.
+	Strict bool
+}
+
+// PacketTooLargeError is returned when a packet is larger than
+// ReaderOptions.MaxPacketSize. A server should respond to it with the
+// carried ReasonCode and close the connection, as the body of the packet is
+// not read.
+type PacketTooLargeError struct {
+	Size       int
+	MaxSize    int
+	PacketType PacketType
+	ReasonCode byte
+}
+
+func (e *PacketTooLargeError) Error() string {
+	return fmt.Sprintf("packet size %d exceeds maximum packet size %d", e.Size, e.MaxSize)
+}
+
+// LimitExceededError is returned when a packet holds more user properties or
+// subscriptions than ReaderOptions allows. Decoding stops at the first entry
+// over the limit.
+type LimitExceededError struct {
+	// Limit is the name of the exceeded ReaderOptions field
+	Limit string
+	// Count is the number of entries counted when decoding stopped, which
+	// is one more than Max
+	Count      int
+	Max        int
+	PacketType PacketType
+	ReasonCode byte
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s exceeded, got %d, maximum is %d", e.Limit, e.Count, e.Max)
+}
+
+// checkSize checks the size of a whole packet against MaxPacketSize.
+func (o *ReaderOptions) checkSize(t PacketType, size int) error {
+	if o == nil || o.MaxPacketSize <= 0 || size <= o.MaxPacketSize {
+		return nil
+	}
+
+	return &PacketTooLargeError{
+		Size:       size,
+		MaxSize:    o.MaxPacketSize,
+		PacketType: t,
+		ReasonCode: reasonPacketTooLarge,
+	}
+}
+
+// checkPacket validates a decoded packet in strict mode, the entry limits
+// are enforced by unpack while the packet is decoded.
+func (o *ReaderOptions) checkPacket(cp *ControlPacket, v Version) error {
+	if o != nil && o.Strict {
+		return cp.Validate(v)
+	}
+
+	return nil
+}
+
+// unpack unpacks the body of cp from r. User properties and subscriptions
+// are counted while they are read, so a packet exceeding the limits is
+// rejected before all of its entries are allocated.
+func (o *ReaderOptions) unpack(cp *ControlPacket, r *bytes.Buffer) error {
+	if o == nil || (o.MaxUserProperties <= 0 && o.MaxSubscriptions <= 0) {
+		return cp.Content.Unpack(r)
+	}
+
+	l := &decodeLimits{opts: o, t: cp.Type}
+	setDecodeLimits(cp, l)
+	err := cp.Content.Unpack(r)
+	setDecodeLimits(cp, nil)
+	return err
+}
+
+// decodeLimits counts the entries of a packet being unpacked
+type decodeLimits struct {
+	opts  *ReaderOptions
+	t     PacketType
+	users int
+}
+
+// setDecodeLimits hands l to the parts of cp that count entries, properties
+// created by Unpack get it from the packet
+func setDecodeLimits(cp *ControlPacket, l *decodeLimits) {
+	for _, p := range packetProperties(cp) {
+		p.limits = l
+	}
+	switch p := cp.Content.(type) {
+	case *Connect:
+		p.limits = l
+	case *Subscribe:
+		p.limits = l
+	case *Unsubscribe:
+		p.limits = l
+	case *Auth:
+		p.limits = l
+	}
+}
+
+// userProperty counts a user property about to be read, it counts the
+// packet and will properties of CONNECT together
+func (l *decodeLimits) userProperty() error {
+	if l == nil || l.opts.MaxUserProperties <= 0 {
+		return nil
+	}
+
+	l.users++
+	if l.users > l.opts.MaxUserProperties {
+		return &LimitExceededError{
+			Limit:      "MaxUserProperties",
+			Count:      l.users,
+			Max:        l.opts.MaxUserProperties,
+			PacketType: l.t,
+			ReasonCode: reasonQuotaExceeded,
+		}
+	}
+	return nil
+}
+
+// subscription checks that the count-th topic filter about to be read is
+// within the limit
+func (l *decodeLimits) subscription(count int) error {
+	if l == nil || l.opts.MaxSubscriptions <= 0 || count <= l.opts.MaxSubscriptions {
+		return nil
+	}
+
+	return &LimitExceededError{
+		Limit:      "MaxSubscriptions",
+		Count:      count,
+		Max:        l.opts.MaxSubscriptions,
+		PacketType: l.t,
+		ReasonCode: reasonQuotaExceeded,
+	}
+}
+
+// packetProperties returns all non nil property sets of a packet.
+func packetProperties(cp *ControlPacket) []*Properties {
+	var props []*Properties
+	switch p := cp.Content.(type) {
+	case *Connect:
+		props = []*Properties{p.Properties, p.WillProperties}
+	case *Connack:
+		props = []*Properties{p.Properties}
+	case *Publish:
+		props = []*Properties{p.Properties}
+	case *Puback:
+		props = []*Properties{p.Properties}
+	case *Pubrec:
+		props = []*Properties{p.Properties}
+	case *Pubrel:
+		props = []*Properties{p.Properties}
+	case *Pubcomp:
+		props = []*Properties{p.Properties}
+	case *Subscribe:
+		props = []*Properties{p.Properties}
+	case *Suback:
+		props = []*Properties{p.Properties}
+	case *Unsubscribe:
+		props = []*Properties{p.Properties}
+	case *Unsuback:
+		props = []*Properties{p.Properties}
+	case *Disconnect:
+		props = []*Properties{p.Properties}
+	case *Auth:
+		props = []*Properties{p.Properties}
+	}
+
+	ret := props[:0]
+	for _, p := range props {
+		if p != nil {
+			ret = append(ret, p)
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,150 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPacketWithOptionsTooLarge(t *testing.T) {
+	// PUBLISH claiming a 256MB body without sending it
+	p := []byte{0x30, 0xff, 0xff, 0xff, 0x7f}
+
+	_, err := ReadPacketWithOptions(bytes.NewReader(p), MQTTv5, &ReaderOptions{MaxPacketSize: 1024})
+
+	var tooLarge *PacketTooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	assert.Equal(t, 268435460, tooLarge.Size)
+	assert.Equal(t, 1024, tooLarge.MaxSize)
+}
+
+func TestReadPacketWithOptionsExactSize(t *testing.T) {
+	var b bytes.Buffer
+	x := NewControlPacket(PUBLISH, MQTTv311)
+	x.Content.(*Publish).Topic = "a/b"
+	x.Content.(*Publish).Payload = []byte("payload")
+	_, err := x.WriteTo(&b)
+	require.Nil(t, err)
+
+	_, err = ReadPacketWithOptions(bytes.NewReader(b.Bytes()), MQTTv311, &ReaderOptions{MaxPacketSize: b.Len()})
+	require.Nil(t, err)
+
+	_, err = ReadPacketWithOptions(bytes.NewReader(b.Bytes()), MQTTv311, &ReaderOptions{MaxPacketSize: b.Len() - 1})
+	var tooLarge *PacketTooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+
+	_, _, err = DecodePacketWithOptions(b.Bytes(), MQTTv311, &ReaderOptions{MaxPacketSize: b.Len() - 1})
+	require.True(t, errors.As(err, &tooLarge))
+}
+
+func TestReadPacketVBITooLong(t *testing.T) {
+	p := []byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01}
+
+	_, err := ReadPacket(bytes.NewReader(p), MQTTv311)
+	assert.True(t, errors.Is(err, errMalformedVBI))
+
+	_, _, err = DecodePacket(p, MQTTv311)
+	assert.True(t, errors.Is(err, errMalformedVBI))
+}
+
+func TestDecodeVBITooLong(t *testing.T) {
+	_, err := decodeVBI(bytes.NewBuffer([]byte{0xff, 0xff, 0xff, 0xff, 0x01}))
+
+	assert.Equal(t, errMalformedVBI, err)
+}
+
+func TestReadPacketWithOptionsUserProperties(t *testing.T) {
+	var b bytes.Buffer
+	x := NewControlPacket(CONNECT, MQTTv5)
+	c := x.Content.(*Connect)
+	c.ClientID = "test"
+	c.Properties.User = []User{{"a", "1"}, {"b", "2"}}
+	c.WillFlag = true
+	c.WillTopic = "will"
+	c.WillProperties = &Properties{User: []User{{"c", "3"}}}
+	_, err := x.WriteTo(&b)
+	require.Nil(t, err)
+
+	_, err = ReadPacketWithOptions(bytes.NewReader(b.Bytes()), 0, &ReaderOptions{MaxUserProperties: 3})
+	require.Nil(t, err)
+
+	_, err = ReadPacketWithOptions(bytes.NewReader(b.Bytes()), 0, &ReaderOptions{MaxUserProperties: 2})
+	var limit *LimitExceededError
+	require.True(t, errors.As(err, &limit))
+	assert.Equal(t, "MaxUserProperties", limit.Limit)
+	assert.Equal(t, 3, limit.Count)
+}
+
+func TestReadPacketWithOptionsSubscriptions(t *testing.T) {
+	var b bytes.Buffer
+	x := NewControlPacket(SUBSCRIBE, MQTTv311)
+	x.Content.(*Subscribe).PacketID = 1
+	x.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "a"}, {Topic: "b"}, {Topic: "c"}}
+	_, err := x.WriteTo(&b)
+	require.Nil(t, err)
+
+	_, err = ReadPacketWithOptions(bytes.NewReader(b.Bytes()), MQTTv311, &ReaderOptions{MaxSubscriptions: 2})
+	var limit *LimitExceededError
+	require.True(t, errors.As(err, &limit))
+	assert.Equal(t, "MaxSubscriptions", limit.Limit)
+
+	b.Reset()
+	x = NewControlPacket(UNSUBSCRIBE, MQTTv311)
+	x.Content.(*Unsubscribe).PacketID = 1
+	x.Content.(*Unsubscribe).Topics = []string{"a", "b"}
+	_, err = x.WriteTo(&b)
+	require.Nil(t, err)
+
+	_, err = ReadPacketWithOptions(bytes.NewReader(b.Bytes()), MQTTv311, &ReaderOptions{MaxSubscriptions: 2})
+	require.Nil(t, err)
+}
+
+func TestDecodeLimitsStopEarly(t *testing.T) {
+	publish := NewControlPacket(PUBLISH, MQTTv5)
+	publish.Content.(*Publish).Topic = "a"
+	for i := 0; i < 10000; i++ {
+		publish.Content.(*Publish).Properties.User = append(publish.Content.(*Publish).Properties.User, User{"k", "v"})
+	}
+	subscribe := NewControlPacket(SUBSCRIBE, MQTTv311)
+	subscribe.Content.(*Subscribe).PacketID = 1
+	unsubscribe := NewControlPacket(UNSUBSCRIBE, MQTTv311)
+	unsubscribe.Content.(*Unsubscribe).PacketID = 1
+	for i := 0; i < 10000; i++ {
+		subscribe.Content.(*Subscribe).Subscriptions = append(subscribe.Content.(*Subscribe).Subscriptions, Subscription{Topic: "a"})
+		unsubscribe.Content.(*Unsubscribe).Topics = append(unsubscribe.Content.(*Unsubscribe).Topics, "a")
+	}
+
+	opts := &ReaderOptions{MaxUserProperties: 10, MaxSubscriptions: 10}
+	for _, tt := range []struct {
+		cp    *ControlPacket
+		v     Version
+		limit string
+	}{
+		{publish, MQTTv5, "MaxUserProperties"},
+		{subscribe, MQTTv311, "MaxSubscriptions"},
+		{unsubscribe, MQTTv311, "MaxSubscriptions"},
+	} {
+		frame, err := tt.cp.AppendTo(nil)
+		require.NoError(t, err)
+
+		var limit *LimitExceededError
+		_, _, err = DecodePacketWithOptions(frame, tt.v, opts)
+		require.True(t, errors.As(err, &limit), "%v", err)
+		assert.Equal(t, tt.limit, limit.Limit)
+		assert.Equal(t, 11, limit.Count)
+		assert.Equal(t, tt.cp.Type, limit.PacketType)
+
+		_, err = ReadPacketWithOptions(bytes.NewReader(frame), tt.v, opts)
+		require.True(t, errors.As(err, &limit), "%v", err)
+
+		// decoding stops at the first entry over the limit instead of
+		// allocating all of them
+		allocs := testing.AllocsPerRun(10, func() {
+			_, _, _ = DecodePacketWithOptions(frame, tt.v, opts)
+		})
+		assert.Less(t, allocs, 100.0, tt.limit)
+	}
+}
@@ -0,0 +1,275 @@
+package mqttpackets
+
+import "fmt"
+
+// ReasonCode is a v5 reason code as carried by CONNACK, PUBACK, PUBREC,
+// PUBREL, PUBCOMP, SUBACK, UNSUBACK, DISCONNECT and AUTH packets. The per
+// packet constants such as PubackSuccess or SubackGrantedQoS1 can be
+// converted to it.
+type ReasonCode byte
+
+// ReasonSuccess, etc are the v5 reason codes shared by all packet types.
+const (
+	ReasonSuccess                             ReasonCode = 0x00
+	ReasonGrantedQoS1                         ReasonCode = 0x01
+	ReasonGrantedQoS2                         ReasonCode = 0x02
+	ReasonDisconnectWithWillMessage           ReasonCode = 0x04
+	ReasonNoMatchingSubscribers               ReasonCode = 0x10
+	ReasonNoSubscriptionExisted               ReasonCode = 0x11
+	ReasonContinueAuthentication              ReasonCode = 0x18
+	ReasonReauthenticate                      ReasonCode = 0x19
+	ReasonUnspecifiedError                    ReasonCode = 0x80
+	ReasonMalformedPacket                     ReasonCode = 0x81
+	ReasonProtocolError                       ReasonCode = 0x82
+	ReasonImplementationSpecificError         ReasonCode = 0x83
+	ReasonUnsupportedProtocolVersion          ReasonCode = 0x84
+	ReasonClientIdentifierNotValid            ReasonCode = 0x85
+	ReasonBadUserNameOrPassword               ReasonCode = 0x86
+	ReasonNotAuthorized                       ReasonCode = 0x87
+	ReasonServerUnavailable                   ReasonCode = 0x88
+	ReasonServerBusy                          ReasonCode = 0x89
+	ReasonBanned                              ReasonCode = 0x8A
+	ReasonServerShuttingDown                  ReasonCode = 0x8B
+	ReasonBadAuthenticationMethod             ReasonCode = 0x8C
+	ReasonKeepAliveTimeout                    ReasonCode = 0x8D
+	ReasonSessionTakenOver                    ReasonCode = 0x8E
+	ReasonTopicFilterInvalid                  ReasonCode = 0x8F
+	ReasonTopicNameInvalid                    ReasonCode = 0x90
+	ReasonPacketIdentifierInUse               ReasonCode = 0x91
+	ReasonPacketIdentifierNotFound            ReasonCode = 0x92
+	ReasonReceiveMaximumExceeded              ReasonCode = 0x93
+	ReasonTopicAliasInvalid                   ReasonCode = 0x94
+	ReasonPacketTooLarge                      ReasonCode = 0x95
+	ReasonMessageRateTooHigh                  ReasonCode = 0x96
+	ReasonQuotaExceeded                       ReasonCode = 0x97
+	ReasonAdministrativeAction                ReasonCode = 0x98
+	ReasonPayloadFormatInvalid                ReasonCode = 0x99
+	ReasonRetainNotSupported                  ReasonCode = 0x9A
+	ReasonQoSNotSupported                     ReasonCode = 0x9B
+	ReasonUseAnotherServer                    ReasonCode = 0x9C
+	ReasonServerMoved                         ReasonCode = 0x9D
+	ReasonSharedSubscriptionsNotSupported     ReasonCode = 0x9E
+	ReasonConnectionRateExceeded              ReasonCode = 0x9F
+	ReasonMaximumConnectTime                  ReasonCode = 0xA0
+	ReasonSubscriptionIdentifiersNotSupported ReasonCode = 0xA1
+	ReasonWildcardSubscriptionsNotSupported   ReasonCode = 0xA2
+)
+
+// v3.1.1 CONNACK return codes
+const (
+	connackAccepted byte = iota
+	connackUnacceptableProtocolVersion
+	connackIdentifierRejected
+	connackServerUnavailable
+	connackBadUserNameOrPassword
+	connackNotAuthorized
+)
+
+// v3SubackFailure is the v3.1.1 SUBACK return code of a failed subscription
+const v3SubackFailure = 0x80
+
+type reasonCodeInfo struct {
+	name        string
+	description string
+	// packets are the packet types the code may be sent in
+	packets []PacketType
+}
+
+// reasonCodes holds the names, meanings and packet types of the v5 reason
+// codes, see table 2-6 of the v5 specification
+var reasonCodes = map[ReasonCode]reasonCodeInfo{
+	ReasonSuccess: {"Success", "The operation completed successfully.",
+		[]PacketType{CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, SUBACK, UNSUBACK, DISCONNECT, AUTH}},
+	ReasonGrantedQoS1: {"Granted QoS 1", "The subscription is accepted with a maximum QoS of 1.",
+		[]PacketType{SUBACK}},
+	ReasonGrantedQoS2: {"Granted QoS 2", "The subscription is accepted with a maximum QoS of 2.",
+		[]PacketType{SUBACK}},
+	ReasonDisconnectWithWillMessage: {"Disconnect with Will Message", "The Client wishes to disconnect but requires that the Server also publishes its Will Message.",
+		[]PacketType{DISCONNECT}},
+	ReasonNoMatchingSubscribers: {"No matching subscribers", "The message is accepted but there are no subscribers. This is sent only by the Server.",
+		[]PacketType{PUBACK, PUBREC}},
+	ReasonNoSubscriptionExisted: {"No subscription existed", "No matching Topic Filter is being used by the Client.",
+		[]PacketType{UNSUBACK}},
+	ReasonContinueAuthentication: {"Continue authentication", "Continue the authentication with another step.",
+		[]PacketType{AUTH}},
+	ReasonReauthenticate: {"Re-authenticate", "Initiate a re-authentication.",
+		[]PacketType{AUTH}},
+	ReasonUnspecifiedError: {"Unspecified error", "The sender does not wish to reveal the reason for the failure, or none of the other Reason Codes apply.",
+		[]PacketType{CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT}},
+	ReasonMalformedPacket: {"Malformed Packet", "The received packet does not conform to the specification.",
+		[]PacketType{CONNACK, DISCONNECT}},
+	ReasonProtocolError: {"Protocol Error", "An unexpected or out of order packet was received.",
+		[]PacketType{CONNACK, DISCONNECT}},
+	ReasonImplementationSpecificError: {"Implementation specific error", "The packet is valid but is not accepted by this implementation.",
+		[]PacketType{CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT}},
+	ReasonUnsupportedProtocolVersion: {"Unsupported Protocol Version", "The Server does not support the version of the MQTT protocol requested by the Client.",
+		[]PacketType{CONNACK}},
+	ReasonClientIdentifierNotValid: {"Client Identifier not valid", "The Client Identifier is a valid string but is not allowed by the Server.",
+		[]PacketType{CONNACK}},
+	ReasonBadUserNameOrPassword: {"Bad User Name or Password", "The Server does not accept the User Name or Password specified by the Client.",
+		[]PacketType{CONNACK}},
+	ReasonNotAuthorized: {"Not authorized", "The request is not authorized.",
+		[]PacketType{CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT}},
+	ReasonServerUnavailable: {"Server unavailable", "The MQTT Server is not available.",
+		[]PacketType{CONNACK}},
+	ReasonServerBusy: {"Server busy", "The Server is busy, try again later.",
+		[]PacketType{CONNACK, DISCONNECT}},
+	ReasonBanned: {"Banned", "The Client has been banned by administrative action.",
+		[]PacketType{CONNACK}},
+	ReasonServerShuttingDown: {"Server shutting down", "The Server is shutting down.",
+		[]PacketType{DISCONNECT}},
+	ReasonBadAuthenticationMethod: {"Bad authentication method", "The authentication method is not supported or does not match the method currently in use.",
+		[]PacketType{CONNACK}},
+	ReasonKeepAliveTimeout: {"Keep Alive timeout", "No packet has been received for 1.5 times the Keep Alive time.",
+		[]PacketType{DISCONNECT}},
+	ReasonSessionTakenOver: {"Session taken over", "Another Connection using the same Client Identifier has connected.",
+		[]PacketType{DISCONNECT}},
+	ReasonTopicFilterInvalid: {"Topic Filter invalid", "The Topic Filter is correctly formed but is not accepted.",
+		[]PacketType{SUBACK, UNSUBACK, DISCONNECT}},
+	ReasonTopicNameInvalid: {"Topic Name invalid", "The Topic Name is correctly formed but is not accepted.",
+		[]PacketType{CONNACK, PUBACK, PUBREC, DISCONNECT}},
+	ReasonPacketIdentifierInUse: {"Packet Identifier in use", "The Packet Identifier is already in use. This might indicate a mismatch in the Session State between the Client and Server.",
+		[]PacketType{PUBACK, PUBREC, SUBACK, UNSUBACK}},
+	ReasonPacketIdentifierNotFound: {"Packet Identifier not found", "The Packet Identifier is not known. This is not an error during recovery, but at other times indicates a mismatch in the Session State between the Client and Server.",
+		[]PacketType{PUBREL, PUBCOMP}},
+	ReasonReceiveMaximumExceeded: {"Receive Maximum exceeded", "More than Receive Maximum publications were received without being acknowledged.",
+		[]PacketType{DISCONNECT}},
+	ReasonTopicAliasInvalid: {"Topic Alias invalid", "The Topic Alias is 0 or greater than the Topic Alias Maximum.",
+		[]PacketType{DISCONNECT}},
+	ReasonPacketTooLarge: {"Packet too large", "The packet size is greater than the Maximum Packet Size.",
+		[]PacketType{CONNACK, DISCONNECT}},
+	ReasonMessageRateTooHigh: {"Message rate too high", "The received data rate is too high.",
+		[]PacketType{DISCONNECT}},
+	ReasonQuotaExceeded: {"Quota exceeded", "An implementation or administrative imposed limit has been exceeded.",
+		[]PacketType{CONNACK, PUBACK, PUBREC, SUBACK, DISCONNECT}},
+	ReasonAdministrativeAction: {"Administrative action", "The Connection is closed due to an administrative action.",
+		[]PacketType{DISCONNECT}},
+	ReasonPayloadFormatInvalid: {"Payload format invalid", "The payload format does not match the Payload Format Indicator.",
+		[]PacketType{CONNACK, PUBACK, PUBREC, DISCONNECT}},
+	ReasonRetainNotSupported: {"Retain not supported", "The Server does not support retained messages.",
+		[]PacketType{CONNACK, DISCONNECT}},
+	ReasonQoSNotSupported: {"QoS not supported", "The QoS is greater than the Maximum QoS of the Server.",
+		[]PacketType{CONNACK, DISCONNECT}},
+	ReasonUseAnotherServer: {"Use another server", "The Client should temporarily use another server.",
+		[]PacketType{CONNACK, DISCONNECT}},
+	ReasonServerMoved: {"Server moved", "The Client should permanently use another server.",
+		[]PacketType{CONNACK, DISCONNECT}},
+	ReasonSharedSubscriptionsNotSupported: {"Shared Subscriptions not supported", "The Server does not support Shared Subscriptions.",
+		[]PacketType{SUBACK, DISCONNECT}},
+	ReasonConnectionRateExceeded: {"Connection rate exceeded", "The connection rate limit has been exceeded.",
+		[]PacketType{CONNACK, DISCONNECT}},
+	ReasonMaximumConnectTime: {"Maximum connect time", "The maximum connection time authorized for this connection has been exceeded.",
+		[]PacketType{DISCONNECT}},
+	ReasonSubscriptionIdentifiersNotSupported: {"Subscription Identifiers not supported", "The Server does not support Subscription Identifiers.",
+		[]PacketType{SUBACK, DISCONNECT}},
+	ReasonWildcardSubscriptionsNotSupported: {"Wildcard Subscriptions not supported", "The Server does not support Wildcard Subscriptions.",
+		[]PacketType{SUBACK, DISCONNECT}},
+}
+
+// packetReasonNames holds the reason code names that depend on the packet
+// type, see table 2-6 of the v5 specification
+var packetReasonNames = map[PacketType]map[ReasonCode]string{
+	SUBACK:     {ReasonSuccess: "Granted QoS 0"},
+	DISCONNECT: {ReasonSuccess: "Normal disconnection"},
+}
+
+// String returns the name of the reason code as used in the specification,
+// code 0 is named Success, use NameFor for the name in a given packet type
+func (r ReasonCode) String() string {
+	if info, ok := reasonCodes[r]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("reason code 0x%02X", byte(r))
+}
+
+// NameFor returns the name of the reason code in packets of type t, code 0
+// is Granted QoS 0 in SUBACK and Normal disconnection in DISCONNECT packets
+func (r ReasonCode) NameFor(t PacketType) string {
+	if name, ok := packetReasonNames[t][r]; ok {
+		return name
+	}
+	return r.String()
+}
+
+// Description returns the meaning of the reason code, it is empty for
+// unknown codes
+func (r ReasonCode) Description() string {
+	return reasonCodes[r].description
+}
+
+// IsError reports whether the reason code indicates a failure, all codes
+// from 0x80 up are errors
+func (r ReasonCode) IsError() bool {
+	return r >= 0x80
+}
+
+// PacketTypes returns the packet types the reason code may be sent in
+func (r ReasonCode) PacketTypes() []PacketType {
+	return append([]PacketType(nil), reasonCodes[r].packets...)
+}
+
+// ValidFor reports whether the reason code may be sent in packets of type t
+func (r ReasonCode) ValidFor(t PacketType) bool {
+	for _, p := range reasonCodes[r].packets {
+		if p == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnackReasonCode converts a v3.1.1 CONNACK return code to a v5 reason
+// code, unknown return codes become ReasonUnspecifiedError
+func ConnackReasonCode(returnCode byte) ReasonCode {
+	switch returnCode {
+	case connackAccepted:
+		return ReasonSuccess
+	case connackUnacceptableProtocolVersion:
+		return ReasonUnsupportedProtocolVersion
+	case connackIdentifierRejected:
+		return ReasonClientIdentifierNotValid
+	case connackServerUnavailable:
+		return ReasonServerUnavailable
+	case connackBadUserNameOrPassword:
+		return ReasonBadUserNameOrPassword
+	case connackNotAuthorized:
+		return ReasonNotAuthorized
+	}
+	return ReasonUnspecifiedError
+}
+
+// ConnackReturnCode converts the reason code to the closest v3.1.1 CONNACK
+// return code, errors without an equivalent become Server unavailable
+func (r ReasonCode) ConnackReturnCode() byte {
+	switch r {
+	case ReasonSuccess:
+		return connackAccepted
+	case ReasonUnsupportedProtocolVersion:
+		return connackUnacceptableProtocolVersion
+	case ReasonClientIdentifierNotValid:
+		return connackIdentifierRejected
+	case ReasonBadUserNameOrPassword, ReasonBadAuthenticationMethod:
+		return connackBadUserNameOrPassword
+	case ReasonNotAuthorized, ReasonBanned:
+		return connackNotAuthorized
+	}
+	return connackServerUnavailable
+}
+
+// SubackReasonCode converts a v3.1.1 SUBACK return code to a v5 reason code,
+// the failure code 0x80 maps to ReasonUnspecifiedError
+func SubackReasonCode(returnCode byte) ReasonCode {
+	if returnCode <= 2 {
+		return ReasonCode(returnCode)
+	}
+	return ReasonUnspecifiedError
+}
+
+// SubackReturnCode converts the reason code to a v3.1.1 SUBACK return code,
+// all errors become the failure code 0x80
+func (r ReasonCode) SubackReturnCode() byte {
+	if r <= ReasonGrantedQoS2 {
+		return byte(r)
+	}
+	return v3SubackFailure
+}
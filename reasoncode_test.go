@@ -0,0 +1,126 @@
+package mqttpackets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReasonCode(t *testing.T) {
+	assert.Equal(t, "Server busy", ReasonServerBusy.String())
+	assert.Equal(t, "Server busy", ReasonCode(DisconnectServerBusy).String())
+	assert.Equal(t, "reason code 0x03", ReasonCode(3).String())
+	assert.Equal(t, "The Server is busy, try again later.", ReasonServerBusy.Description())
+	assert.Empty(t, ReasonCode(3).Description())
+
+	assert.False(t, ReasonSuccess.IsError())
+	assert.False(t, ReasonCode(SubackGrantedQoS2).IsError())
+	assert.True(t, ReasonCode(SubackUnspecifiederror).IsError())
+
+	assert.Equal(t, []PacketType{CONNACK, DISCONNECT}, ReasonServerBusy.PacketTypes())
+	assert.True(t, ReasonPacketIdentifierNotFound.ValidFor(PUBREL))
+	assert.False(t, ReasonPacketIdentifierNotFound.ValidFor(PUBACK))
+	assert.False(t, ReasonCode(3).ValidFor(CONNACK))
+}
+
+func TestReasonCodePacketConstants(t *testing.T) {
+	tests := map[PacketType][]byte{
+		PUBACK: {PubackSuccess, PubackNoMatchingSubscribers, PubackUnspecifiedError, PubackImplementationSpecificError,
+			PubackNotAuthorized, PubackTopicNameInvalid, PubackPacketIdentifierInUse, PubackQuotaExceeded,
+			PubackPayloadFormatInvalid},
+		PUBREC: {PubrecSuccess, PubrecNoMatchingSubscribers, PubrecUnspecifiedError, PubrecImplementationSpecificError,
+			PubrecNotAuthorized, PubrecTopicNameInvalid, PubrecPacketIdentifierInUse, PubrecQuotaExceeded,
+			PubrecPayloadFormatInvalid},
+		PUBREL:  {PubrelSuccess, PubrelPacketIdentifierNotFound},
+		PUBCOMP: {PubcompSuccess, PubcompPacketIdentifierNotFound},
+		SUBACK: {SubackGrantedQoS0, SubackGrantedQoS1, SubackGrantedQoS2, SubackUnspecifiederror,
+			SubackImplementationspecificerror, SubackNotauthorized, SubackTopicFilterinvalid, SubackPacketIdentifierinuse,
+			SubackQuotaexceeded, SubackSharedSubscriptionnotsupported, SubackSubscriptionIdentifiersnotsupported,
+			SubackWildcardsubscriptionsnotsupported},
+		UNSUBACK: {UnsubackSuccess, UnsubackNoSubscriptionFound, UnsubackUnspecifiedError,
+			UnsubackImplementationSpecificError, UnsubackNotAuthorized, UnsubackTopicFilterInvalid,
+			UnsubackPacketIdentifierInUse},
+		AUTH: {AuthSuccess, AuthContinueAuthentication, AuthReauthenticate},
+	}
+
+	for packetType, codes := range tests {
+		for _, code := range codes {
+			rc := ReasonCode(code)
+			assert.True(t, rc.ValidFor(packetType), "%s in %s", rc, packetTypeName(packetType))
+			assert.NotEmpty(t, rc.Description())
+		}
+	}
+}
+
+func TestPacketReason(t *testing.T) {
+	assert.Equal(t, "Server busy - The Server is busy. Try again later.",
+		(&Connack{ReasonCode: byte(ReasonServerBusy), Properties: &Properties{}}).Reason())
+	assert.Equal(t, "Normal disconnection - Close the connection normally. Do not send the Will Message.",
+		(&Disconnect{}).Reason())
+	assert.Equal(t, "An implementation or administrative imposed limit has been exceeded.",
+		(&Puback{ReasonCode: PubackQuotaExceeded}).Reason())
+	assert.Equal(t, "Quota exceeded - An implementation or administrative imposed limit has been exceeded.",
+		(&Pubrec{ReasonCode: PubrecQuotaExceeded}).Reason())
+	assert.Equal(t, "Success - Packet Identifier released. Publication of QoS 2 message is complete.",
+		(&Pubcomp{}).Reason())
+	assert.Empty(t, (&Puback{ReasonCode: 3}).Reason())
+
+	// v3 return codes are converted to reason codes
+	assert.Equal(t, "Client Identifier not valid - The Client Identifier is a valid string but is not allowed by the Server.",
+		(&Connack{ReasonCode: connackIdentifierRejected}).Reason())
+	assert.Equal(t, "Not authorized - The Client is not authorized to connect.",
+		(&Connack{ReasonCode: byte(ReasonNotAuthorized)}).Reason())
+
+	suback := &Suback{Reasons: []byte{SubackGrantedQoS0, SubackNotauthorized}}
+	assert.Equal(t, "Granted QoS 0 - The subscription is accepted and the maximum QoS sent will be QoS 0. This might be a lower QoS than was requested.",
+		suback.Reason(0))
+	assert.Equal(t, "Not authorized - The Client is not authorized to make this subscription.", suback.Reason(1))
+	assert.Equal(t, "Invalid Reason index", suback.Reason(2))
+	unsuback := &Unsuback{Reasons: []byte{UnsubackNoSubscriptionFound}}
+	assert.Equal(t, "No subscription found - No matching Topic Filter is being used by the Client.", unsuback.Reason(0))
+	assert.Equal(t, "Invalid Reason index", unsuback.Reason(-1))
+}
+
+func TestReasonCodeNameFor(t *testing.T) {
+	assert.Equal(t, "Success", ReasonSuccess.NameFor(CONNACK))
+	assert.Equal(t, "Granted QoS 0", ReasonSuccess.NameFor(SUBACK))
+	assert.Equal(t, "Normal disconnection", ReasonSuccess.NameFor(DISCONNECT))
+	assert.Equal(t, "Server busy", ReasonServerBusy.NameFor(DISCONNECT))
+	assert.Equal(t, "reason code 0x03", ReasonCode(3).NameFor(SUBACK))
+}
+
+func TestReasonCodeV3Conversions(t *testing.T) {
+	for rc := byte(0); rc <= 5; rc++ {
+		assert.Equal(t, rc, ConnackReasonCode(rc).ConnackReturnCode())
+		assert.True(t, ConnackReasonCode(rc).ValidFor(CONNACK))
+	}
+	assert.Equal(t, ReasonUnspecifiedError, ConnackReasonCode(6))
+	assert.Equal(t, byte(3), ReasonServerBusy.ConnackReturnCode())
+	assert.Equal(t, byte(5), ReasonBanned.ConnackReturnCode())
+
+	for rc := byte(0); rc <= 2; rc++ {
+		assert.Equal(t, rc, SubackReasonCode(rc).SubackReturnCode())
+	}
+	assert.Equal(t, ReasonUnspecifiedError, SubackReasonCode(0x80))
+	assert.Equal(t, byte(0x80), ReasonQuotaExceeded.SubackReturnCode())
+}
+
+func TestControlPacketReasonCodes(t *testing.T) {
+	connack := NewControlPacket(CONNACK, MQTTv311)
+	connack.Content.(*Connack).ReasonCode = 4
+	assert.Equal(t, []ReasonCode{ReasonBadUserNameOrPassword}, connack.ReasonCodes())
+
+	suback := NewControlPacket(SUBACK, MQTTv311)
+	suback.Content.(*Suback).Reasons = []byte{1, 0x80}
+	assert.Equal(t, []ReasonCode{ReasonGrantedQoS1, ReasonUnspecifiedError}, suback.ReasonCodes())
+
+	suback = NewControlPacket(SUBACK, MQTTv5)
+	suback.Content.(*Suback).Reasons = []byte{SubackQuotaexceeded}
+	assert.Equal(t, []ReasonCode{ReasonQuotaExceeded}, suback.ReasonCodes())
+
+	disconnect := NewControlPacket(DISCONNECT, MQTTv5)
+	disconnect.Content.(*Disconnect).ReasonCode = DisconnectServerShuttingDown
+	assert.Equal(t, []ReasonCode{ReasonServerShuttingDown}, disconnect.ReasonCodes())
+
+	assert.Nil(t, NewControlPacket(PINGREQ, MQTTv5).ReasonCodes())
+}
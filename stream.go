@@ -0,0 +1,165 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+)
+
+// ErrPayloadNotDrained is returned by StreamReader.ReadPacket when the
+// payload of the previous PUBLISH was not read completely
+var ErrPayloadNotDrained = errors.New("payload of the previous PUBLISH was not drained")
+
+// StreamReader reads packets without buffering the payloads of PUBLISH
+// packets, so memory use doesn't depend on the payload size. The headers of
+// a PUBLISH are decoded by Publish.Unpack and its payload is returned as a
+// reader, other packets are read like ReadPacket does.
+type StreamReader struct {
+	// Options are the limits enforced on read packets, nil enforces none
+	Options *ReaderOptions
+
+	r       io.Reader
+	payload *io.LimitedReader
+	version Version
+}
+
+// NewStreamReader returns a StreamReader reading packets of protocol version
+// v from r. With version 0 the version is taken from the first CONNECT packet.
+func NewStreamReader(r io.Reader, v Version) *StreamReader {
+	return &StreamReader{r: r, version: v}
+}
+
+// ReadPacket reads the next packet. For PUBLISH packets Payload is left nil
+// and the payload is returned as a reader limited to its length, whose N
+// field holds the number of unread bytes. The payload has to be read to the
+// end before the next call, which fails with ErrPayloadNotDrained otherwise.
+// The reader is nil for other packet types.
+func (s *StreamReader) ReadPacket() (*ControlPacket, *io.LimitedReader, error) {
+	if s.payload != nil && s.payload.N > 0 {
+		return nil, nil, ErrPayloadNotDrained
+	}
+	s.payload = nil
+
+	cp, headerLen, err := readFixedHeader(s.r, s.version, s.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cp.Type != PUBLISH {
+		if cp, err = readContent(s.r, cp, headerLen, s.version, s.Options); err != nil {
+			return nil, nil, err
+		}
+		if c, ok := cp.Content.(*Connect); ok && s.version == 0 {
+			s.version = c.ProtocolVersion
+		}
+		return cp, nil, nil
+	}
+
+	p := cp.Content.(*Publish)
+	lr := &io.LimitedReader{R: s.r, N: int64(cp.remainingLength)}
+	vh, err := readPublishHeader(lr, p.QoS, s.version)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = s.Options.unpack(cp, bytes.NewBuffer(vh)); err != nil {
+		return nil, nil, setErrorOffset(err, headerLen+len(vh))
+	}
+	p.Payload = nil
+	if err = s.Options.checkPacket(cp, s.version); err != nil {
+		return nil, nil, err
+	}
+
+	s.payload = lr
+	return cp, lr, nil
+}
+
+// readPublishHeader reads the variable header of a PUBLISH packet from lr,
+// which is limited to the remaining length. A variable header that doesn't
+// fit the packet is returned as far as it could be read, so Unpack reports
+// the error.
+func readPublishHeader(lr *io.LimitedReader, qos byte, v Version) ([]byte, error) {
+	var vh bytes.Buffer
+	read := func(n int) bool {
+		_, err := io.CopyN(&vh, lr, int64(n))
+		return err == nil
+	}
+	// fail tells a truncated packet from a connection that was closed
+	fail := func() ([]byte, error) {
+		if lr.N == 0 {
+			return vh.Bytes(), nil
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if !read(2) {
+		return fail()
+	}
+	topicLen := int(vh.Bytes()[0])<<8 | int(vh.Bytes()[1])
+	if !read(topicLen) {
+		return fail()
+	}
+	if qos > 0 && !read(2) {
+		return fail()
+	}
+	if v != MQTTv5 {
+		return vh.Bytes(), nil
+	}
+
+	start := vh.Len()
+	for i := 0; i < maxVBILen; i++ {
+		if !read(1) {
+			return fail()
+		}
+		if vh.Bytes()[vh.Len()-1] < 0x80 {
+			break
+		}
+	}
+	size, err := decodeVBI(bytes.NewBuffer(vh.Bytes()[start:]))
+	if err != nil {
+		return vh.Bytes(), nil
+	}
+	if !read(size) {
+		return fail()
+	}
+
+	return vh.Bytes(), nil
+}
+
+// WritePublishStream writes p with a payload of size bytes copied from
+// payload instead of p.Payload, so the payload doesn't have to be held in
+// memory. The headers are checked like Encode does. If payload ends early
+// io.ErrUnexpectedEOF is returned and the frame written to w is incomplete.
+func WritePublishStream(w io.Writer, p *Publish, payload io.Reader, size int64) (int64, error) {
+	headers := *p
+	headers.Payload = nil
+
+	ec := &encodeChecker{t: PUBLISH}
+	ec.packet(&headers)
+	if ec.err != nil {
+		return 0, ec.err
+	}
+
+	buffers := headers.Buffers()
+	remaining := size
+	for _, b := range buffers {
+		remaining += int64(len(b))
+	}
+	if size < 0 || remaining > maxRemainingLength {
+		return 0, &EncodeError{PacketType: PUBLISH, Field: "RemainingLength", Err: ErrVBIOutOfRange}
+	}
+
+	header := append([]byte{byte(PUBLISH)<<4 | headers.flags()}, encodeVBI(int(remaining))...)
+	buffers = append(net.Buffers{header}, buffers...)
+	n, err := buffers.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+
+	m, err := io.CopyN(w, payload, size)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n + m, err
+}
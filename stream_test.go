@@ -0,0 +1,149 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// patternReader produces size bytes without holding them in memory
+type patternReader struct {
+	size, pos int64
+}
+
+func (r *patternReader) Read(b []byte) (int, error) {
+	if r.pos == r.size {
+		return 0, io.EOF
+	}
+	if rest := r.size - r.pos; int64(len(b)) > rest {
+		b = b[:rest]
+	}
+	for i := range b {
+		b[i] = byte(r.pos + int64(i))
+	}
+	r.pos += int64(len(b))
+	return len(b), nil
+}
+
+func TestStreamReader(t *testing.T) {
+	var b bytes.Buffer
+	_, err := NewControlPacket(CONNECT, MQTTv5).WriteTo(&b)
+	require.NoError(t, err)
+
+	props := &Properties{ContentType: "application/octet-stream"}
+	publish := &Publish{Topic: "firmware", QoS: 1, PacketID: 5, Properties: props}
+	_, err = WritePublishStream(&b, publish, bytes.NewReader([]byte("image")), 5)
+	require.NoError(t, err)
+	_, err = NewControlPacket(PINGREQ, MQTTv5).WriteTo(&b)
+	require.NoError(t, err)
+
+	// the streamed frame decodes like any other
+	frames := append([]byte(nil), b.Bytes()...)
+	_, n, err := DecodePacket(frames, 0)
+	require.NoError(t, err)
+	decoded, _, err := DecodePacket(frames[n:], MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("image"), decoded.Content.(*Publish).Payload)
+
+	s := NewStreamReader(&b, 0)
+	cp, payload, err := s.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, CONNECT, cp.Type)
+	assert.Nil(t, payload)
+
+	cp, payload, err = s.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, &Publish{Topic: "firmware", QoS: 1, PacketID: 5, Properties: props}, cp.Content)
+	require.NotNil(t, payload)
+	assert.Equal(t, int64(5), payload.N)
+
+	_, _, err = s.ReadPacket()
+	assert.Equal(t, ErrPayloadNotDrained, err)
+
+	data, err := ioutil.ReadAll(payload)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("image"), data)
+
+	cp, payload, err = s.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, PINGREQ, cp.Type)
+	assert.Nil(t, payload)
+}
+
+func TestStreamLargePayload(t *testing.T) {
+	const size = 64 << 20
+
+	r, w := io.Pipe()
+	go func() {
+		publish := &Publish{Topic: "firmware", Properties: &Properties{}}
+		_, err := WritePublishStream(w, publish, &patternReader{size: size}, size)
+		w.CloseWithError(err)
+	}()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	cp, payload, err := NewStreamReader(r, MQTTv5).ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, "firmware", cp.Content.(*Publish).Topic)
+
+	h := &patternReader{size: size}
+	want, got := make([]byte, 32*1024), make([]byte, 32*1024)
+	var total int64
+	for {
+		n, err := io.ReadFull(payload, got)
+		if n > 0 {
+			_, _ = io.ReadFull(h, want[:n])
+			require.Equal(t, want[:n], got[:n])
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(size), total)
+
+	runtime.ReadMemStats(&after)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(4<<20))
+}
+
+func TestStreamReaderErrors(t *testing.T) {
+	// the topic length exceeds the packet
+	_, _, err := NewStreamReader(bytes.NewReader([]byte{0x30, 3, 0, 5, 'a'}), MQTTv311).ReadPacket()
+	var malformedErr *MalformedPacketError
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, "Topic", malformedErr.Field)
+	assert.Equal(t, 2, malformedErr.Offset)
+
+	// the property length exceeds the packet
+	_, _, err = NewStreamReader(bytes.NewReader([]byte{0x30, 4, 0, 1, 'a', 9}), MQTTv5).ReadPacket()
+	require.True(t, errors.As(err, &malformedErr))
+	assert.Equal(t, "Properties", malformedErr.Field)
+	assert.Equal(t, 5, malformedErr.Offset)
+
+	// the connection closes within the headers
+	_, _, err = NewStreamReader(bytes.NewReader([]byte{0x30, 10, 0, 5, 'a'}), MQTTv311).ReadPacket()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestWritePublishStreamErrors(t *testing.T) {
+	var b bytes.Buffer
+	_, err := WritePublishStream(&b, &Publish{Topic: "a", QoS: 3}, bytes.NewReader(nil), 0)
+	assert.True(t, errors.Is(err, ErrInvalidQoS))
+	assert.Zero(t, b.Len())
+
+	_, err = WritePublishStream(&b, &Publish{Topic: "a"}, bytes.NewReader(nil), maxRemainingLength)
+	assert.True(t, errors.Is(err, ErrVBIOutOfRange))
+
+	n, err := WritePublishStream(&b, &Publish{Topic: "a"}, bytes.NewReader([]byte("ab")), 4)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, int64(b.Len()), n)
+}
@@ -0,0 +1,339 @@
+package mqttpackets
+
+import (
+	"fmt"
+	"strings"
+)
+
+// redacted replaces passwords and authentication data in summaries
+const redacted = "<redacted>"
+
+// String returns a one line summary of the packet for logs and debugging,
+// e.g.
+//
+//	PUBLISH q1 id=42 r topic="a/b" 12B props{messageExpiry=60}
+//
+// Payloads are summarized by their size, passwords and authentication data
+// are redacted. The format is meant for people and may change, use
+// MarshalJSON for a stable format.
+func (c *ControlPacket) String() string {
+	if s, ok := c.Content.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return c.Type.String()
+}
+
+// summary collects the space separated parts of a packet summary
+type summary []string
+
+func (s *summary) add(format string, a ...interface{}) {
+	*s = append(*s, fmt.Sprintf(format, a...))
+}
+
+// reasonCode adds a reason code unless it is 0, which means success in every
+// packet type
+func (s *summary) reasonCode(code byte) {
+	if code != 0 {
+		s.add("rc=0x%02X", code)
+	}
+}
+
+// properties adds the properties unless there are none
+func (s *summary) properties(p *Properties) {
+	if props := p.String(); props != "{}" && props != "<nil>" {
+		s.add("props%s", props)
+	}
+}
+
+func (s summary) String() string {
+	return strings.Join(s, " ")
+}
+
+// String returns the set properties as {name=value ...}, using the names of
+// their JSON fields. The authentication data is redacted.
+func (i *Properties) String() string {
+	if i == nil {
+		return "<nil>"
+	}
+
+	var s summary
+	for _, id := range propertyOrder {
+		if i.encodedLen(id) == 0 {
+			continue
+		}
+		format := "%s=%s"
+		if id == PropUser {
+			format = "%s%s"
+		}
+		s.add(format, jsonName(propertyNames[id]), i.formatProperty(id))
+	}
+
+	return "{" + s.String() + "}"
+}
+
+// formatProperty returns the value of property id as shown by String
+func (i *Properties) formatProperty(id byte) string {
+	switch id {
+	case PropPayloadFormat:
+		return fmt.Sprintf("%d", *i.PayloadFormat)
+	case PropMessageExpiry:
+		return fmt.Sprintf("%d", *i.MessageExpiry)
+	case PropContentType:
+		return fmt.Sprintf("%q", i.ContentType)
+	case PropResponseTopic:
+		return fmt.Sprintf("%q", i.ResponseTopic)
+	case PropCorrelationData:
+		return fmt.Sprintf("%x", i.CorrelationData)
+	case PropTopicAlias:
+		return fmt.Sprintf("%d", *i.TopicAlias)
+	case PropSubscriptionIdentifier:
+		return fmt.Sprintf("%d", *i.SubscriptionIdentifier)
+	case PropReceiveMaximum:
+		return fmt.Sprintf("%d", *i.ReceiveMaximum)
+	case PropTopicAliasMaximum:
+		return fmt.Sprintf("%d", *i.TopicAliasMaximum)
+	case PropMaximumQOS:
+		return fmt.Sprintf("%d", *i.MaximumQOS)
+	case PropMaximumPacketSize:
+		return fmt.Sprintf("%d", *i.MaximumPacketSize)
+	case PropAssignedClientID:
+		return fmt.Sprintf("%q", i.AssignedClientID)
+	case PropServerKeepAlive:
+		return fmt.Sprintf("%d", *i.ServerKeepAlive)
+	case PropWildcardSubAvailable:
+		return fmt.Sprintf("%d", *i.WildcardSubAvailable)
+	case PropSubIDAvailable:
+		return fmt.Sprintf("%d", *i.SubIDAvailable)
+	case PropSharedSubAvailable:
+		return fmt.Sprintf("%d", *i.SharedSubAvailable)
+	case PropRetainAvailable:
+		return fmt.Sprintf("%d", *i.RetainAvailable)
+	case PropResponseInfo:
+		return fmt.Sprintf("%q", i.ResponseInfo)
+	case PropRequestProblemInfo:
+		return fmt.Sprintf("%d", *i.RequestProblemInfo)
+	case PropWillDelayInterval:
+		return fmt.Sprintf("%d", *i.WillDelayInterval)
+	case PropRequestResponseInfo:
+		return fmt.Sprintf("%d", *i.RequestResponseInfo)
+	case PropSessionExpiryInterval:
+		return fmt.Sprintf("%d", *i.SessionExpiryInterval)
+	case PropAuthMethod:
+		return fmt.Sprintf("%q", i.AuthMethod)
+	case PropAuthData:
+		return redacted
+	case PropServerReference:
+		return fmt.Sprintf("%q", i.ServerReference)
+	case PropReasonString:
+		return fmt.Sprintf("%q", i.ReasonString)
+	case PropUser:
+		users := make([]string, len(i.User))
+		for j, u := range i.User {
+			users[j] = fmt.Sprintf("%q:%q", u.Key, u.Value)
+		}
+		return "{" + strings.Join(users, ",") + "}"
+	}
+
+	return ""
+}
+
+// jsonName returns the JSON name of a Properties field
+func jsonName(field string) string {
+	return strings.ToLower(field[:1]) + field[1:]
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (c *Connect) String() string {
+	s := summary{"CONNECT"}
+	s.add("v%d", c.ProtocolVersion)
+	s.add("id=%q", c.ClientID)
+	if c.CleanStart {
+		s.add("clean")
+	}
+	s.add("keepalive=%d", c.KeepAlive)
+	if c.UsernameFlag {
+		s.add("username=%q", c.Username)
+	}
+	if c.PasswordFlag {
+		s.add("password=%s", redacted)
+	}
+	if c.WillFlag {
+		will := summary{fmt.Sprintf("q%d", c.WillQOS)}
+		if c.WillRetain {
+			will.add("r")
+		}
+		will.add("topic=%q", c.WillTopic)
+		will.add("%dB", len(c.WillMessage))
+		will.properties(c.WillProperties)
+		s.add("will{%s}", will)
+	}
+	s.properties(c.Properties)
+
+	return s.String()
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (c *Connack) String() string {
+	s := summary{"CONNACK"}
+	s.reasonCode(c.ReasonCode)
+	if c.SessionPresent {
+		s.add("session")
+	}
+	s.properties(c.Properties)
+
+	return s.String()
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (p *Publish) String() string {
+	s := summary{"PUBLISH"}
+	s.add("q%d", p.QoS)
+	if p.QoS > 0 {
+		s.add("id=%d", p.PacketID)
+	}
+	if p.Duplicate {
+		s.add("d")
+	}
+	if p.Retain {
+		s.add("r")
+	}
+	s.add("topic=%q", p.Topic)
+	s.add("%dB", len(p.Payload))
+	s.properties(p.Properties)
+
+	return s.String()
+}
+
+// ackString summarizes a PUBACK, PUBREC, PUBREL or PUBCOMP
+func ackString(t PacketType, packetID uint16, reasonCode byte, props *Properties) string {
+	s := summary{t.String()}
+	s.add("id=%d", packetID)
+	s.reasonCode(reasonCode)
+	s.properties(props)
+
+	return s.String()
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (p *Puback) String() string {
+	return ackString(PUBACK, p.PacketID, p.ReasonCode, p.Properties)
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (p *Pubrec) String() string {
+	return ackString(PUBREC, p.PacketID, p.ReasonCode, p.Properties)
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (p *Pubrel) String() string {
+	return ackString(PUBREL, p.PacketID, p.ReasonCode, p.Properties)
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (p *Pubcomp) String() string {
+	return ackString(PUBCOMP, p.PacketID, p.ReasonCode, p.Properties)
+}
+
+// String returns a one line summary like ControlPacket.String does, each
+// subscription is shown as topic:options
+func (s *Subscribe) String() string {
+	sum := summary{"SUBSCRIBE"}
+	sum.add("id=%d", s.PacketID)
+	subs := make([]string, len(s.Subscriptions))
+	for i, o := range s.Subscriptions {
+		subs[i] = fmt.Sprintf("%q:%s", o.Topic, o.optionsString())
+	}
+	sum.add("subs=[%s]", strings.Join(subs, " "))
+	sum.properties(s.Properties)
+
+	return sum.String()
+}
+
+// optionsString formats the options as q1,nl,rap,rh1, leaving out the
+// ones that are not set
+func (s *Subscription) optionsString() string {
+	options := []string{fmt.Sprintf("q%d", s.QoS)}
+	if s.NoLocal {
+		options = append(options, "nl")
+	}
+	if s.RetainAsPublished {
+		options = append(options, "rap")
+	}
+	if s.RetainHandling > 0 {
+		options = append(options, fmt.Sprintf("rh%d", s.RetainHandling))
+	}
+	return strings.Join(options, ",")
+}
+
+// reasonCodesString formats a list of reason codes as [0x00 0x80]
+func reasonCodesString(codes []byte) string {
+	hex := make([]string, len(codes))
+	for i, code := range codes {
+		hex[i] = fmt.Sprintf("0x%02X", code)
+	}
+	return "[" + strings.Join(hex, " ") + "]"
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (s *Suback) String() string {
+	sum := summary{"SUBACK"}
+	sum.add("id=%d", s.PacketID)
+	sum.add("rc=%s", reasonCodesString(s.Reasons))
+	sum.properties(s.Properties)
+
+	return sum.String()
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (u *Unsubscribe) String() string {
+	s := summary{"UNSUBSCRIBE"}
+	s.add("id=%d", u.PacketID)
+	topics := make([]string, len(u.Topics))
+	for i, t := range u.Topics {
+		topics[i] = fmt.Sprintf("%q", t)
+	}
+	s.add("topics=[%s]", strings.Join(topics, " "))
+	s.properties(u.Properties)
+
+	return s.String()
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (u *Unsuback) String() string {
+	s := summary{"UNSUBACK"}
+	s.add("id=%d", u.PacketID)
+	if len(u.Reasons) > 0 {
+		s.add("rc=%s", reasonCodesString(u.Reasons))
+	}
+	s.properties(u.Properties)
+
+	return s.String()
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (p *Pingreq) String() string {
+	return "PINGREQ"
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (p *Pingresp) String() string {
+	return "PINGRESP"
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (d *Disconnect) String() string {
+	s := summary{"DISCONNECT"}
+	s.reasonCode(d.ReasonCode)
+	s.properties(d.Properties)
+
+	return s.String()
+}
+
+// String returns a one line summary like ControlPacket.String does
+func (a *Auth) String() string {
+	s := summary{"AUTH"}
+	s.reasonCode(a.ReasonCode)
+	s.properties(a.Properties)
+
+	return s.String()
+}
@@ -0,0 +1,152 @@
+package mqttpackets
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	expiry := uint32(60)
+	alias := uint16(3)
+
+	tests := []struct {
+		name   string
+		packet func() *ControlPacket
+		want   string
+	}{
+		{
+			name: "publish",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv5)
+				p := cp.Content.(*Publish)
+				p.QoS = 1
+				p.PacketID = 42
+				p.Retain = true
+				p.Topic = "a/b"
+				p.Payload = make([]byte, 12)
+				p.Properties.MessageExpiry = &expiry
+				return cp
+			},
+			want: `PUBLISH q1 id=42 r topic="a/b" 12B props{messageExpiry=60}`,
+		},
+		{
+			name: "publish v3",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv311)
+				p := cp.Content.(*Publish)
+				p.Duplicate = true
+				p.Topic = "a"
+				return cp
+			},
+			want: `PUBLISH q0 d topic="a" 0B`,
+		},
+		{
+			name: "connect",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(CONNECT, MQTTv5)
+				c := cp.Content.(*Connect)
+				c.ClientID = "client"
+				c.CleanStart = true
+				c.KeepAlive = 30
+				c.UsernameFlag = true
+				c.Username = "bob"
+				c.PasswordFlag = true
+				c.Password = []byte("secret")
+				c.WillFlag = true
+				c.WillQOS = 1
+				c.WillTopic = "will"
+				c.WillMessage = []byte("bye")
+				c.WillProperties = &Properties{WillDelayInterval: &expiry}
+				c.Properties.AuthMethod = "SCRAM-SHA-1"
+				c.Properties.AuthData = []byte("secret")
+				return cp
+			},
+			want: `CONNECT v5 id="client" clean keepalive=30 username="bob" password=<redacted> ` +
+				`will{q1 topic="will" 3B props{willDelayInterval=60}} props{authMethod="SCRAM-SHA-1" authData=<redacted>}`,
+		},
+		{
+			name: "subscribe",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBSCRIBE, MQTTv5)
+				s := cp.Content.(*Subscribe)
+				s.PacketID = 1
+				s.Subscriptions = []Subscription{
+					{Topic: "a/#", QoS: 1},
+					{Topic: "b", QoS: 2, NoLocal: true, RetainAsPublished: true, RetainHandling: 2},
+				}
+				s.Properties.User = []User{{Key: "k", Value: "v"}}
+				return cp
+			},
+			want: `SUBSCRIBE id=1 subs=["a/#":q1 "b":q2,nl,rap,rh2] props{user{"k":"v"}}`,
+		},
+		{
+			name: "suback",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBACK, MQTTv311)
+				cp.Content.(*Suback).PacketID = 1
+				cp.Content.(*Suback).Reasons = []byte{1, 0x80}
+				return cp
+			},
+			want: `SUBACK id=1 rc=[0x01 0x80]`,
+		},
+		{
+			name: "puback",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBACK, MQTTv5)
+				p := cp.Content.(*Puback)
+				p.PacketID = 7
+				p.ReasonCode = PubackNoMatchingSubscribers
+				p.Properties.TopicAlias = &alias
+				p.Properties.ReasonString = "none"
+				return cp
+			},
+			want: `PUBACK id=7 rc=0x10 props{topicAlias=3 reasonString="none"}`,
+		},
+		{
+			name: "unsubscribe",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(UNSUBSCRIBE, MQTTv311)
+				cp.Content.(*Unsubscribe).PacketID = 2
+				cp.Content.(*Unsubscribe).Topics = []string{"a", "b"}
+				return cp
+			},
+			want: `UNSUBSCRIBE id=2 topics=["a" "b"]`,
+		},
+		{
+			name: "disconnect",
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(DISCONNECT, MQTTv5)
+				cp.Content.(*Disconnect).ReasonCode = DisconnectServerShuttingDown
+				return cp
+			},
+			want: `DISCONNECT rc=0x8B`,
+		},
+		{
+			name: "pingreq",
+			packet: func() *ControlPacket {
+				return NewControlPacket(PINGREQ, MQTTv311)
+			},
+			want: `PINGREQ`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := tt.packet()
+			assert.Equal(t, tt.want, cp.String())
+			assert.Equal(t, tt.want, fmt.Sprintf("%+v", cp))
+			assert.Equal(t, tt.want, fmt.Sprint(cp.Content))
+			assert.NotContains(t, cp.String(), "secret")
+		})
+	}
+}
+
+func TestPropertiesString(t *testing.T) {
+	format := byte(1)
+	p := &Properties{PayloadFormat: &format, CorrelationData: []byte{0xCA, 0xFE}}
+	assert.Equal(t, `{payloadFormat=1 correlationData=cafe}`, p.String())
+	assert.Equal(t, `{}`, (&Properties{}).String())
+	assert.Equal(t, `<nil>`, (*Properties)(nil).String())
+}
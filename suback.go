@@ -8,9 +8,9 @@ import (
 
 // Suback is the Variable Header definition for a Suback control packet
 type Suback struct {
-	Properties *Properties
-	Reasons    []byte
-	PacketID   uint16
+	Properties *Properties `json:"properties,omitempty"`
+	Reasons    []byte      `json:"reasons,omitempty"`
+	PacketID   uint16      `json:"packetID,omitempty"`
 }
 
 // SubackGrantedQoS0, etc are the list of valid suback reason codes.
@@ -34,7 +34,7 @@ func (s *Suback) Unpack(r *bytes.Buffer) error {
 	var err error
 	s.PacketID, err = readUint16(r)
 	if err != nil {
-		return err
+		return malformed(SUBACK, "PacketID", r.Len(), err)
 	}
 
 	if s.Properties != nil {
@@ -62,6 +62,16 @@ func (s *Suback) Buffers() net.Buffers {
 	return net.Buffers{b.Bytes(), propLen, idvp, s.Reasons}
 }
 
+func (s *Suback) size() int {
+	return 2 + s.Properties.prefixedLen(SUBACK) + len(s.Reasons)
+}
+
+func (s *Suback) appendTo(dst []byte) []byte {
+	dst = appendUint16(dst, s.PacketID)
+	dst = s.Properties.appendPrefixed(dst, SUBACK)
+	return append(dst, s.Reasons...)
+}
+
 // WriteTo is the implementation of the interface required function for a packet
 func (s *Suback) WriteTo(w io.Writer) (int64, error) {
 	cp := &ControlPacket{FixedHeader: FixedHeader{Type: SUBACK}}
@@ -2,68 +2,130 @@ package mqttpackets
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 )
 
+var errReservedOptions = errors.New("reserved subscription option bits must be 0")
+
 // Subscribe is the Variable Header definition for a Subscribe control packet
 type Subscribe struct {
-	Properties    *Properties
-	Subscriptions []Subscription
-	PacketID      uint16
+	Properties    *Properties    `json:"properties,omitempty"`
+	Subscriptions []Subscription `json:"subscriptions,omitempty"`
+	PacketID      uint16         `json:"packetID,omitempty"`
+
+	// limits counts the subscriptions while unpacking
+	limits *decodeLimits
 }
 
 // Subscription is the struct representing a subscription and its options
 type Subscription struct {
-	Topic             string
-	QoS               byte
-	RetainHandling    byte
-	NoLocal           bool
-	RetainAsPublished bool
+	Topic             string `json:"topic,omitempty"`
+	QoS               byte   `json:"qos,omitempty"`
+	RetainHandling    byte   `json:"retainHandling,omitempty"`
+	NoLocal           bool   `json:"noLocal,omitempty"`
+	RetainAsPublished bool   `json:"retainAsPublished,omitempty"`
 }
 
-// WriteTo writes a subscription to buffer
+// WriteTo writes a subscription to buffer using the v5 options format
 func (s *Subscription) WriteTo(b *bytes.Buffer) {
+	s.pack(b, MQTTv5)
+}
+
+// pack writes a subscription to buffer, the NoLocal, RetainAsPublished and
+// RetainHandling options only exist in v5 and are left out for older versions
+func (s *Subscription) pack(b *bytes.Buffer, v Version) {
 	writeString(s.Topic, b)
-	var ret byte
-	ret |= s.QoS & 0x03
-	if s.NoLocal {
-		ret |= 1 << 2
-	}
-	if s.RetainAsPublished {
-		ret |= 1 << 3
+	b.WriteByte(s.options(v))
+}
+
+// appendTo appends a subscription to dst like pack does
+func (s *Subscription) appendTo(dst []byte, v Version) []byte {
+	return append(appendString(dst, s.Topic), s.options(v))
+}
+
+// options returns the subscription options byte for version v
+func (s *Subscription) options(v Version) byte {
+	ret := s.QoS & 0x03
+	if v == MQTTv5 {
+		if s.NoLocal {
+			ret |= 1 << 2
+		}
+		if s.RetainAsPublished {
+			ret |= 1 << 3
+		}
+		ret |= (s.RetainHandling & 0x03) << 4
 	}
-	ret |= s.RetainHandling & 0x30
-	b.WriteByte(ret)
+	return ret
 }
 
-// Unpack is the implementation of the interface required function for a packet
+// Unpack reads a subscription using the v5 options format
 func (s *Subscription) Unpack(r *bytes.Buffer) error {
+	return s.unpack(r, MQTTv5)
+}
+
+// unpack reads a subscription, option bits that are reserved in version v
+// must be 0
+func (s *Subscription) unpack(r *bytes.Buffer, v Version) error {
 	topic, err := readString(r)
 	if err != nil {
-		return err
+		return malformed(SUBSCRIBE, "Topic", r.Len(), err)
 	}
 	s.Topic = topic
 
 	b, err := r.ReadByte()
 	if err != nil {
-		return err
+		return malformed(SUBSCRIBE, "Options", r.Len(), err)
+	}
+
+	if err = optionsError(b, v); err != nil {
+		return malformed(SUBSCRIBE, "Options", r.Len()+1, err)
 	}
 
+	s.unpackOptions(b)
+
+	return nil
+}
+
+// unpackOptions sets the options from the wire byte, ignoring reserved bits
+func (s *Subscription) unpackOptions(b byte) {
 	s.QoS = b & 0x03
-	s.NoLocal = (b & 1 << 2) == 1
-	s.RetainAsPublished = (b & 1 << 3) == 1
-	s.RetainHandling = b & 0x30
+	s.NoLocal = b&(1<<2) != 0
+	s.RetainAsPublished = b&(1<<3) != 0
+	s.RetainHandling = b >> 4 & 0x03
+}
 
+// optionsError checks a subscription options byte of version v, reserved
+// bits must be 0 and QoS and retain handling must not be 3
+func optionsError(b byte, v Version) error {
+	switch {
+	case b&reservedOptions(v) != 0:
+		return errReservedOptions
+	case b&0x03 == 3:
+		return ErrInvalidQoS
+	case b>>4&0x03 == 3:
+		return ErrInvalidRetainHandling
+	}
 	return nil
 }
 
+// reservedOptions returns the subscription option bits that are reserved in
+// version v
+func reservedOptions(v Version) byte {
+	if v == MQTTv5 {
+		return 0xC0
+	}
+	return 0xFC
+}
+
 // Unpack is the implementation of the interface required function for a packet
 func (s *Subscribe) Unpack(r *bytes.Buffer) error {
 	var err error
 	s.PacketID, err = readUint16(r)
 	if err != nil {
-		return err
+		return malformed(SUBSCRIBE, "PacketID", r.Len(), err)
 	}
 
 	if s.Properties != nil {
@@ -74,8 +136,14 @@ func (s *Subscribe) Unpack(r *bytes.Buffer) error {
 	}
 
 	for r.Len() > 0 {
+		if err = s.limits.subscription(len(s.Subscriptions) + 1); err != nil {
+			return err
+		}
 		var so Subscription
-		if err = so.Unpack(r); err != nil {
+		if err = so.unpack(r, s.version()); err != nil {
+			if e, ok := err.(*MalformedPacketError); ok {
+				e.Field = fmt.Sprintf("Subscriptions[%d].%s", len(s.Subscriptions), e.Field)
+			}
 			return err
 		}
 		s.Subscriptions = append(s.Subscriptions, so)
@@ -90,7 +158,7 @@ func (s *Subscribe) Buffers() net.Buffers {
 	writeUint16(s.PacketID, &b)
 	var subs bytes.Buffer
 	for _, o := range s.Subscriptions {
-		o.WriteTo(&subs)
+		o.pack(&subs, s.version())
 	}
 	if s.Properties == nil {
 		return net.Buffers{b.Bytes(), subs.Bytes()}
@@ -101,6 +169,32 @@ func (s *Subscribe) Buffers() net.Buffers {
 	return net.Buffers{b.Bytes(), propLen, idvp, subs.Bytes()}
 }
 
+func (s *Subscribe) size() int {
+	n := 2 + s.Properties.prefixedLen(SUBSCRIBE)
+	for _, o := range s.Subscriptions {
+		n += 2 + len(o.Topic) + 1
+	}
+	return n
+}
+
+func (s *Subscribe) appendTo(dst []byte) []byte {
+	dst = appendUint16(dst, s.PacketID)
+	dst = s.Properties.appendPrefixed(dst, SUBSCRIBE)
+	for _, o := range s.Subscriptions {
+		dst = o.appendTo(dst, s.version())
+	}
+	return dst
+}
+
+// version returns the protocol version of the packet, v5 packets always
+// have Properties set
+func (s *Subscribe) version() Version {
+	if s.Properties != nil {
+		return MQTTv5
+	}
+	return MQTTv311
+}
+
 // WriteTo is the implementation of the interface required function for a packet
 func (s *Subscribe) WriteTo(w io.Writer) (int64, error) {
 	cp := &ControlPacket{FixedHeader: FixedHeader{Type: SUBSCRIBE, Flags: 2}}
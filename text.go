@@ -0,0 +1,756 @@
+package mqttpackets
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidText is wrapped by the errors returned for malformed packet text
+var ErrInvalidText = errors.New("invalid packet text")
+
+// textAliases are short keys accepted for common properties
+var textAliases = map[string]byte{
+	"expiry": PropMessageExpiry,
+}
+
+// textProperties maps the text keys of properties, the JSON names of their
+// fields, to their identifiers
+var textProperties = func() map[string]byte {
+	keys := make(map[string]byte, len(propertyNames)+len(textAliases))
+	for id, name := range propertyNames {
+		keys[jsonName(name)] = id
+	}
+	for key, id := range textAliases {
+		keys[key] = id
+	}
+	return keys
+}()
+
+// MarshalText implements encoding.TextMarshaler. A packet is written as a
+// single line holding its type, the protocol version and its fields, e.g.
+//
+//	PUBLISH v5 topic=a/b qos=1 id=7 retain payload=hex:0102 messageExpiry=30 user:k=v
+//
+// The version is v3, v4 or v5 for CONNECT packets and v5 for other packets
+// with properties. Fields are written as key=value with the JSON name of
+// the field as key, packet IDs as id. Flags are written by their name alone
+// and fields with zero values are left out. Every user property is written
+// as user:key=value, subscriptions as sub=topic:options with the options
+// formatted like String does, e.g. sub=a/#:q1,nl, and unsubscribed topics as
+// repeated topic fields. Will properties are prefixed with "will.".
+//
+// Strings are quoted with Go syntax when they are empty, start with a quote
+// or contain spaces, '=' or unprintable characters. Binary values are
+// written as text if they are printable and as hex:0102 otherwise. Unlike
+// String, the text holds the password and authentication data.
+func (c *ControlPacket) MarshalText() ([]byte, error) {
+	if !c.Type.IsValid() || c.Content == nil {
+		return nil, &EncodeError{PacketType: c.Type, Field: "PacketType", Err: ErrInvalidPacketType}
+	}
+
+	w := textWriter{c.Type.String()}
+	switch p := c.Content.(type) {
+	case *Connect:
+		if err := checkVersion(p.ProtocolVersion); err != nil {
+			return nil, &EncodeError{PacketType: CONNECT, Field: "ProtocolVersion", Err: err}
+		}
+		w.add("v%d", p.ProtocolVersion)
+		if p.ProtocolName != NewControlPacket(CONNECT, p.ProtocolVersion).Content.(*Connect).ProtocolName {
+			w.string("protocolName", p.ProtocolName)
+		}
+		w.string("clientID", p.ClientID)
+		w.flag("cleanStart", p.CleanStart)
+		w.number("keepAlive", uint64(p.KeepAlive))
+		if p.UsernameFlag {
+			w.field("username", quoteText(p.Username))
+		}
+		if p.PasswordFlag {
+			w.field("password", binaryText(p.Password))
+		}
+		if p.WillFlag {
+			w.field("willTopic", quoteText(p.WillTopic))
+			w.field("willMessage", binaryText(p.WillMessage))
+			w.number("willQOS", uint64(p.WillQOS))
+			w.flag("willRetain", p.WillRetain)
+			w.properties("will.", p.WillProperties)
+		}
+		w.properties("", p.Properties)
+	case *Connack:
+		w.version(p.Properties)
+		w.flag("sessionPresent", p.SessionPresent)
+		w.reasonCode(p.ReasonCode)
+		w.properties("", p.Properties)
+	case *Publish:
+		w.version(p.Properties)
+		w.string("topic", p.Topic)
+		w.number("qos", uint64(p.QoS))
+		w.number("id", uint64(p.PacketID))
+		w.flag("duplicate", p.Duplicate)
+		w.flag("retain", p.Retain)
+		if len(p.Payload) > 0 {
+			w.field("payload", binaryText(p.Payload))
+		}
+		w.properties("", p.Properties)
+	case *Puback:
+		w.ack(p.PacketID, p.ReasonCode, p.Properties)
+	case *Pubrec:
+		w.ack(p.PacketID, p.ReasonCode, p.Properties)
+	case *Pubrel:
+		w.ack(p.PacketID, p.ReasonCode, p.Properties)
+	case *Pubcomp:
+		w.ack(p.PacketID, p.ReasonCode, p.Properties)
+	case *Subscribe:
+		w.version(p.Properties)
+		w.number("id", uint64(p.PacketID))
+		for _, o := range p.Subscriptions {
+			w.field("sub", quoteText(o.Topic+":"+o.optionsString()))
+		}
+		w.properties("", p.Properties)
+	case *Suback:
+		w.version(p.Properties)
+		w.number("id", uint64(p.PacketID))
+		w.reasons(p.Reasons)
+		w.properties("", p.Properties)
+	case *Unsubscribe:
+		w.version(p.Properties)
+		w.number("id", uint64(p.PacketID))
+		for _, topic := range p.Topics {
+			w.field("topic", quoteText(topic))
+		}
+		w.properties("", p.Properties)
+	case *Unsuback:
+		w.version(p.Properties)
+		w.number("id", uint64(p.PacketID))
+		w.reasons(p.Reasons)
+		w.properties("", p.Properties)
+	case *Disconnect:
+		w.version(p.Properties)
+		w.v5ReasonCode(p.ReasonCode, p.Properties)
+		w.properties("", p.Properties)
+	case *Auth:
+		w.version(p.Properties)
+		w.v5ReasonCode(p.ReasonCode, p.Properties)
+		w.properties("", p.Properties)
+	}
+
+	return []byte(strings.Join(w, " ")), nil
+}
+
+// textWriter collects the space separated tokens of a packet text
+type textWriter []string
+
+func (w *textWriter) add(format string, a ...interface{}) {
+	*w = append(*w, fmt.Sprintf(format, a...))
+}
+
+func (w *textWriter) field(key, value string) {
+	w.add("%s=%s", key, value)
+}
+
+// version adds v5 for packets with properties
+func (w *textWriter) version(props *Properties) {
+	if props != nil {
+		w.add("v%d", MQTTv5)
+	}
+}
+
+func (w *textWriter) flag(key string, set bool) {
+	if set {
+		w.add("%s", key)
+	}
+}
+
+func (w *textWriter) string(key, value string) {
+	if value != "" {
+		w.field(key, quoteText(value))
+	}
+}
+
+func (w *textWriter) number(key string, value uint64) {
+	if value != 0 {
+		w.field(key, strconv.FormatUint(value, 10))
+	}
+}
+
+func (w *textWriter) reasonCode(code byte) {
+	if code != 0 {
+		w.field("reasonCode", fmt.Sprintf("0x%02X", code))
+	}
+}
+
+// v5ReasonCode adds the reason code of an acknowledgement, DISCONNECT or
+// AUTH packet, v3 packets don't carry it
+func (w *textWriter) v5ReasonCode(code byte, props *Properties) {
+	if props != nil {
+		w.reasonCode(code)
+	}
+}
+
+func (w *textWriter) reasons(codes []byte) {
+	if len(codes) == 0 {
+		return
+	}
+
+	hex := make([]string, len(codes))
+	for i, code := range codes {
+		hex[i] = fmt.Sprintf("0x%02X", code)
+	}
+	w.field("reasons", strings.Join(hex, ","))
+}
+
+func (w *textWriter) ack(packetID uint16, reasonCode byte, props *Properties) {
+	w.version(props)
+	w.number("id", uint64(packetID))
+	w.v5ReasonCode(reasonCode, props)
+	w.properties("", props)
+}
+
+// properties adds the set properties in the order Pack writes them, with
+// their keys prefixed by prefix
+func (w *textWriter) properties(prefix string, props *Properties) {
+	if props == nil {
+		return
+	}
+
+	props.Each(func(id byte, v PropertyValue) {
+		key := prefix + jsonName(propertyNames[id])
+		switch v.Type {
+		case TypeByte, TypeUint16, TypeUint32, TypeVBI:
+			w.field(key, strconv.FormatUint(uint64(v.Uint), 10))
+		case TypeString:
+			w.field(key, quoteText(v.Text))
+		case TypeBinary:
+			w.field(key, binaryText(v.Bytes))
+		case TypeStringPair:
+			w.field(prefix+"user:"+quoteText(v.Pair.Key), quoteText(v.Pair.Value))
+		}
+	})
+}
+
+// quoteText quotes s if it can't be written as is
+func quoteText(s string) string {
+	if s == "" || strings.ContainsAny(s, `"#`) || strings.HasPrefix(s, "hex:") || !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+	for _, r := range s {
+		if r == '=' || !unicode.IsPrint(r) || unicode.IsSpace(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+// binaryText writes printable data as text and other data as hex
+func binaryText(b []byte) string {
+	if !utf8.Valid(b) {
+		return "hex:" + hex.EncodeToString(b)
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return "hex:" + hex.EncodeToString(b)
+		}
+	}
+	return quoteText(string(b))
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for the format written
+// by MarshalText and replaces c with the parsed packet, see ParseText
+func (c *ControlPacket) UnmarshalText(text []byte) error {
+	cp, err := ParseText(string(text))
+	if err != nil {
+		return err
+	}
+
+	*c = *cp
+	return nil
+}
+
+// ParseText parses a packet from a line in the format written by
+// MarshalText. The version defaults to v4 and the fields can be given in
+// any order, the short key expiry is accepted for messageExpiry. Everything
+// after a '#' outside of a quoted string is a comment. The fixed header
+// flags are the ones required by the packet type or, for PUBLISH, taken from
+// the fields.
+func ParseText(line string) (*ControlPacket, error) {
+	tokens, err := splitText(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: missing packet type", ErrInvalidText)
+	}
+
+	var t PacketType
+	if err = t.UnmarshalText([]byte(tokens[0])); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidText, err)
+	}
+	tokens = tokens[1:]
+
+	v := MQTTv311
+	if len(tokens) > 0 && strings.HasPrefix(tokens[0], "v") && !strings.Contains(tokens[0], "=") {
+		n, err := strconv.ParseUint(tokens[0][1:], 10, 8)
+		if err == nil {
+			err = checkVersion(Version(n))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: version %s: %v", ErrInvalidText, tokens[0], err)
+		}
+		v = Version(n)
+		tokens = tokens[1:]
+	}
+
+	cp := NewControlPacket(t, v)
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		f, err := newTextField(token)
+		if err == nil {
+			if seen[f.key] && !f.repeatable(t) {
+				err = errors.New("duplicate field")
+			} else {
+				seen[f.key] = true
+				err = f.set(cp)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidText, token, err)
+		}
+	}
+
+	switch p := cp.Content.(type) {
+	case *Connect:
+		if p.WillFlag && p.ProtocolVersion == MQTTv5 && p.WillProperties == nil {
+			p.WillProperties = &Properties{}
+		}
+	case *Publish:
+		cp.Flags = p.flags()
+	}
+
+	return cp, nil
+}
+
+// ReadText parses the packets in r, one per line as written by
+// MarshalText. Empty lines and lines holding only a comment are skipped.
+// Errors hold the number of the line they occurred in.
+func ReadText(r io.Reader) ([]*ControlPacket, error) {
+	var packets []*ControlPacket
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return packets, err
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && trimmed[0] != '#' {
+			cp, perr := ParseText(line)
+			if perr != nil {
+				return packets, fmt.Errorf("line %d: %w", n, perr)
+			}
+			packets = append(packets, cp)
+		}
+		if err == io.EOF {
+			return packets, nil
+		}
+	}
+}
+
+// splitText splits a line into tokens separated by spaces, quoted strings
+// are part of the token they appear in
+func splitText(line string) ([]string, error) {
+	var tokens []string
+	var token strings.Builder
+	inToken := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"':
+			end, err := quotedEnd(line, i)
+			if err != nil {
+				return nil, err
+			}
+			token.WriteString(line[i:end])
+			inToken = true
+			i = end - 1
+		case c == '#' && !inToken:
+			i = len(line)
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if inToken {
+				tokens = append(tokens, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			token.WriteByte(c)
+			inToken = true
+		}
+	}
+	if inToken {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens, nil
+}
+
+// quotedEnd returns the offset after the quoted string starting at offset
+// start of s
+func quotedEnd(s string, start int) (int, error) {
+	for i := start + 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unterminated string %s", ErrInvalidText, s[start:])
+}
+
+// unquoteText returns the value of s, which is quoted or written as is
+func unquoteText(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		return strconv.Unquote(s)
+	}
+	return s, nil
+}
+
+// textField is a key=value or flag token of a packet text
+type textField struct {
+	key, value string
+	hasValue   bool
+}
+
+func newTextField(token string) (textField, error) {
+	f := textField{key: token}
+	for i := 0; i < len(token); i++ {
+		switch token[i] {
+		case '"':
+			end, err := quotedEnd(token, i)
+			if err != nil {
+				return f, err
+			}
+			i = end - 1
+		case '=':
+			f.key, f.value, f.hasValue = token[:i], token[i+1:], true
+			return f, nil
+		}
+	}
+
+	return f, nil
+}
+
+// repeatable reports whether the field may appear more than once in
+// packets of type t
+func (f textField) repeatable(t PacketType) bool {
+	switch {
+	case t == SUBSCRIBE && f.key == "sub", t == UNSUBSCRIBE && f.key == "topic":
+		return true
+	}
+	return strings.HasPrefix(f.key, "user:") || strings.HasPrefix(f.key, "will.user:")
+}
+
+func (f textField) string() (string, error) {
+	if !f.hasValue {
+		return "", errors.New("missing value")
+	}
+	return unquoteText(f.value)
+}
+
+// binary returns the value of a binary field, hex:0102 is decoded
+func (f textField) binary() ([]byte, error) {
+	if !f.hasValue {
+		return nil, errors.New("missing value")
+	}
+	if strings.HasPrefix(f.value, "hex:") {
+		return hex.DecodeString(f.value[len("hex:"):])
+	}
+	s, err := unquoteText(f.value)
+	return []byte(s), err
+}
+
+func (f textField) uint(bits int) (uint64, error) {
+	if !f.hasValue {
+		return 0, errors.New("missing value")
+	}
+	return strconv.ParseUint(f.value, 0, bits)
+}
+
+func (f textField) byte() (byte, error) {
+	n, err := f.uint(8)
+	return byte(n), err
+}
+
+func (f textField) uint16() (uint16, error) {
+	n, err := f.uint(16)
+	return uint16(n), err
+}
+
+func (f textField) flag() (bool, error) {
+	if f.hasValue {
+		return false, errors.New("flags have no value")
+	}
+	return true, nil
+}
+
+// reasons parses a comma separated list of reason codes
+func (f textField) reasons() ([]byte, error) {
+	if !f.hasValue {
+		return nil, errors.New("missing value")
+	}
+
+	var codes []byte
+	for _, s := range strings.Split(f.value, ",") {
+		code, err := strconv.ParseUint(s, 0, 8)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, byte(code))
+	}
+	return codes, nil
+}
+
+// subscription parses a topic:options subscription
+func (f textField) subscription() (Subscription, error) {
+	var s Subscription
+	value, err := f.string()
+	if err != nil {
+		return s, err
+	}
+	sep := strings.LastIndexByte(value, ':')
+	if sep < 0 {
+		return s, errors.New("missing subscription options")
+	}
+
+	s.Topic = value[:sep]
+	for _, option := range strings.Split(value[sep+1:], ",") {
+		switch {
+		case option == "nl":
+			s.NoLocal = true
+		case option == "rap":
+			s.RetainAsPublished = true
+		case len(option) == 2 && option[0] == 'q' && option[1] >= '0' && option[1] <= '2':
+			s.QoS = option[1] - '0'
+		case len(option) == 3 && option[:2] == "rh" && option[2] >= '0' && option[2] <= '2':
+			s.RetainHandling = option[2] - '0'
+		default:
+			return s, fmt.Errorf("unknown subscription option %q", option)
+		}
+	}
+	return s, nil
+}
+
+// set sets the field of the content of cp
+func (f textField) set(cp *ControlPacket) error {
+	var err error
+	switch p := cp.Content.(type) {
+	case *Connect:
+		switch f.key {
+		case "protocolName":
+			p.ProtocolName, err = f.string()
+		case "clientID":
+			p.ClientID, err = f.string()
+		case "cleanStart":
+			p.CleanStart, err = f.flag()
+		case "keepAlive":
+			p.KeepAlive, err = f.uint16()
+		case "username":
+			p.UsernameFlag = true
+			p.Username, err = f.string()
+		case "password":
+			p.PasswordFlag = true
+			p.Password, err = f.binary()
+		case "willTopic":
+			p.WillFlag = true
+			p.WillTopic, err = f.string()
+		case "willMessage":
+			p.WillFlag = true
+			p.WillMessage, err = f.binary()
+		case "willQOS":
+			p.WillFlag = true
+			p.WillQOS, err = f.byte()
+		case "willRetain":
+			p.WillFlag = true
+			p.WillRetain, err = f.flag()
+		default:
+			if strings.HasPrefix(f.key, "will.") {
+				if p.WillProperties == nil && p.Properties != nil {
+					p.WillProperties = &Properties{}
+				}
+				p.WillFlag = true
+				return f.setProperty(p.WillProperties, will, strings.TrimPrefix(f.key, "will."))
+			}
+			return f.setProperty(p.Properties, CONNECT, f.key)
+		}
+	case *Connack:
+		switch f.key {
+		case "sessionPresent":
+			p.SessionPresent, err = f.flag()
+		case "reasonCode":
+			p.ReasonCode, err = f.byte()
+		default:
+			return f.setProperty(p.Properties, CONNACK, f.key)
+		}
+	case *Publish:
+		switch f.key {
+		case "topic":
+			p.Topic, err = f.string()
+		case "qos":
+			p.QoS, err = f.byte()
+			if err == nil && p.QoS > 2 {
+				err = ErrInvalidQoS
+			}
+		case "id":
+			p.PacketID, err = f.uint16()
+		case "duplicate":
+			p.Duplicate, err = f.flag()
+		case "retain":
+			p.Retain, err = f.flag()
+		case "payload":
+			p.Payload, err = f.binary()
+		default:
+			return f.setProperty(p.Properties, PUBLISH, f.key)
+		}
+	case *Puback:
+		return f.setAck(&p.PacketID, &p.ReasonCode, p.Properties, PUBACK)
+	case *Pubrec:
+		return f.setAck(&p.PacketID, &p.ReasonCode, p.Properties, PUBREC)
+	case *Pubrel:
+		return f.setAck(&p.PacketID, &p.ReasonCode, p.Properties, PUBREL)
+	case *Pubcomp:
+		return f.setAck(&p.PacketID, &p.ReasonCode, p.Properties, PUBCOMP)
+	case *Subscribe:
+		switch f.key {
+		case "id":
+			p.PacketID, err = f.uint16()
+		case "sub":
+			var s Subscription
+			if s, err = f.subscription(); err == nil {
+				p.Subscriptions = append(p.Subscriptions, s)
+			}
+		default:
+			return f.setProperty(p.Properties, SUBSCRIBE, f.key)
+		}
+	case *Suback:
+		switch f.key {
+		case "id":
+			p.PacketID, err = f.uint16()
+		case "reasons":
+			p.Reasons, err = f.reasons()
+		default:
+			return f.setProperty(p.Properties, SUBACK, f.key)
+		}
+	case *Unsubscribe:
+		switch f.key {
+		case "id":
+			p.PacketID, err = f.uint16()
+		case "topic":
+			var topic string
+			if topic, err = f.string(); err == nil {
+				p.Topics = append(p.Topics, topic)
+			}
+		default:
+			return f.setProperty(p.Properties, UNSUBSCRIBE, f.key)
+		}
+	case *Unsuback:
+		switch f.key {
+		case "id":
+			p.PacketID, err = f.uint16()
+		case "reasons":
+			p.Reasons, err = f.reasons()
+		default:
+			return f.setProperty(p.Properties, UNSUBACK, f.key)
+		}
+	case *Disconnect:
+		if f.key == "reasonCode" {
+			return f.setReasonCode(&p.ReasonCode, p.Properties)
+		}
+		return f.setProperty(p.Properties, DISCONNECT, f.key)
+	case *Auth:
+		if f.key == "reasonCode" {
+			return f.setReasonCode(&p.ReasonCode, p.Properties)
+		}
+		return f.setProperty(p.Properties, AUTH, f.key)
+	default:
+		return errors.New("unknown field")
+	}
+
+	return err
+}
+
+func (f textField) setAck(packetID *uint16, reasonCode *byte, props *Properties, t PacketType) error {
+	var err error
+	switch f.key {
+	case "id":
+		*packetID, err = f.uint16()
+	case "reasonCode":
+		return f.setReasonCode(reasonCode, props)
+	default:
+		return f.setProperty(props, t, f.key)
+	}
+	return err
+}
+
+// setReasonCode sets the reason code of an acknowledgement, DISCONNECT or
+// AUTH packet, v3 packets have none so props is nil for them
+func (f textField) setReasonCode(code *byte, props *Properties) error {
+	if props == nil {
+		return errors.New("reason codes require v5")
+	}
+	var err error
+	*code, err = f.byte()
+	return err
+}
+
+// setProperty sets the property named key
+func (f textField) setProperty(props *Properties, t PacketType, key string) error {
+	if strings.HasPrefix(key, "user:") {
+		if props == nil {
+			return errors.New("properties require v5")
+		}
+		name, err := unquoteText(key[len("user:"):])
+		if err != nil {
+			return err
+		}
+		value, err := f.string()
+		if err != nil {
+			return err
+		}
+		return props.Set(PropUser, StringPairValue(name, value))
+	}
+
+	id, ok := textProperties[key]
+	if !ok || id == PropUser {
+		return errors.New("unknown field")
+	}
+	if props == nil {
+		return errors.New("properties require v5")
+	}
+	if !ValidateID(t, id) {
+		return ErrInvalidProperty
+	}
+
+	v := PropertyValue{Type: propertyTypes[id]}
+	var err error
+	switch v.Type {
+	case TypeByte, TypeUint16, TypeUint32, TypeVBI:
+		// Set checks the range of the data type
+		var n uint64
+		n, err = f.uint(32)
+		v.Uint = uint32(n)
+	case TypeString:
+		v.Text, err = f.string()
+	case TypeBinary:
+		v.Bytes, err = f.binary()
+	}
+	if err != nil {
+		return err
+	}
+
+	return props.Set(id, v)
+}
@@ -0,0 +1,263 @@
+package mqttpackets
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseText(t *testing.T) {
+	cp, err := ParseText("PUBLISH v5 qos=1 id=7 topic=a/b retain expiry=30 user:k=v payload=hex:0102")
+	require.NoError(t, err)
+
+	expiry := uint32(30)
+	assert.Equal(t, PUBLISH, cp.Type)
+	assert.Equal(t, byte(0x3), cp.Flags)
+	assert.Equal(t, &Publish{
+		Topic:    "a/b",
+		QoS:      1,
+		PacketID: 7,
+		Retain:   true,
+		Payload:  []byte{1, 2},
+		Properties: &Properties{
+			MessageExpiry: &expiry,
+			User:          []User{{Key: "k", Value: "v"}},
+		},
+	}, cp.Content)
+
+	text, err := cp.MarshalText()
+	require.NoError(t, err)
+	assert.Equal(t, "PUBLISH v5 topic=a/b qos=1 id=7 retain payload=hex:0102 messageExpiry=30 user:k=v", string(text))
+
+	// without a version the packet is a v3.1.1 packet
+	cp, err = ParseText(`CONNECT clientID="my client" cleanStart username=u password=secret # comment`)
+	require.NoError(t, err)
+	assert.Equal(t, &Connect{
+		ProtocolName:    "MQTT",
+		ProtocolVersion: MQTTv311,
+		ClientID:        "my client",
+		CleanStart:      true,
+		UsernameFlag:    true,
+		Username:        "u",
+		PasswordFlag:    true,
+		Password:        []byte("secret"),
+	}, cp.Content)
+
+	cp, err = ParseText("SUBSCRIBE v5 id=1 sub=a/#:q1,nl sub=b:q2,rap,rh2 subscriptionIdentifier=5")
+	require.NoError(t, err)
+	id := 5
+	assert.Equal(t, byte(2), cp.Flags)
+	assert.Equal(t, &Subscribe{
+		PacketID: 1,
+		Subscriptions: []Subscription{
+			{Topic: "a/#", QoS: 1, NoLocal: true},
+			{Topic: "b", QoS: 2, RetainAsPublished: true, RetainHandling: 2},
+		},
+		Properties: &Properties{SubscriptionIdentifier: &id},
+	}, cp.Content)
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	b, u16, u32, id := byte(0), uint16(2), uint32(3), 4
+	props := &Properties{
+		PayloadFormat:          &b,
+		MessageExpiry:          &u32,
+		ContentType:            "text/plain; charset=utf-8",
+		ResponseTopic:          "reply/#",
+		CorrelationData:        []byte{0, 1, 0xFF},
+		SubscriptionIdentifier: &id,
+		TopicAlias:             &u16,
+		User:                   []User{{Key: "a key", Value: ""}, {Key: "k=v", Value: `"quoted"`}, {Key: "k", Value: "hex:not"}},
+	}
+
+	connect := NewControlPacket(CONNECT, MQTTv5)
+	c := connect.Content.(*Connect)
+	c.ClientID = "client"
+	c.KeepAlive = 60
+	c.UsernameFlag = true
+	c.PasswordFlag = true
+	c.Password = []byte{}
+	c.WillFlag = true
+	c.WillQOS = 2
+	c.WillRetain = true
+	c.WillTopic = "will"
+	c.WillMessage = []byte("gone\n")
+	c.WillProperties = &Properties{WillDelayInterval: &u32, User: []User{{Key: "w", Value: "x"}}}
+	c.Properties.SessionExpiryInterval = &u32
+	c.Properties.AuthData = []byte("data")
+
+	v31 := NewControlPacket(CONNECT, MQTTv31)
+	v31.Content.(*Connect).ProtocolName = "MQTT"
+
+	publish := NewControlPacket(PUBLISH, MQTTv5)
+	p := publish.Content.(*Publish)
+	p.Topic = "a b/ü"
+	p.QoS = 2
+	p.PacketID = 9
+	p.Duplicate = true
+	p.Payload = []byte{0xC3, 0x28}
+	p.Properties = props
+	publish.Flags = p.flags()
+
+	unsuback := NewControlPacket(UNSUBACK, MQTTv5)
+	unsuback.Content.(*Unsuback).Reasons = []byte{0, 0x11, 0x80}
+	unsuback.Content.(*Unsuback).Properties.ReasonString = "some failed"
+
+	packets := append(benchmarkPackets(), connect, v31, publish, unsuback)
+	for _, cp := range benchmarkPackets()[:AUTH-1] {
+		translated, err := Translate(cp, MQTTv5, MQTTv311)
+		require.NoError(t, err)
+		packets = append(packets, translated)
+	}
+
+	for _, cp := range packets {
+		text, err := cp.MarshalText()
+		require.NoError(t, err)
+		assert.NotContains(t, string(text), "\n")
+
+		var parsed ControlPacket
+		require.NoError(t, parsed.UnmarshalText(text), string(text))
+		assert.Equal(t, cp.Type, parsed.Type)
+		assert.Equal(t, cp.Content, parsed.Content, string(text))
+
+		again, err := parsed.MarshalText()
+		require.NoError(t, err)
+		assert.Equal(t, string(text), string(again))
+
+		// the parsed packet encodes to the same frame
+		want, err := cp.AppendTo(nil)
+		require.NoError(t, err)
+		got, err := parsed.AppendTo(nil)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, string(text))
+	}
+}
+
+func TestReadText(t *testing.T) {
+	packets, err := ReadText(strings.NewReader(`# a session
+CONNECT v5 clientID=c
+
+PUBLISH v5 topic=a payload="hello world"
+  # indented comment
+DISCONNECT v5 reasonCode=0x04`))
+	require.NoError(t, err)
+	require.Len(t, packets, 3)
+	assert.Equal(t, CONNECT, packets[0].Type)
+	assert.Equal(t, []byte("hello world"), packets[1].Content.(*Publish).Payload)
+	assert.Equal(t, byte(4), packets[2].Content.(*Disconnect).ReasonCode)
+
+	packets, err = ReadText(strings.NewReader("PINGREQ\nPINGRESP extra\n"))
+	assert.Len(t, packets, 1)
+	assert.True(t, errors.Is(err, ErrInvalidText))
+	assert.Contains(t, err.Error(), "line 2")
+}
+
+func TestParseTextErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{name: "empty", text: "  # nothing"},
+		{name: "unknown type", text: "PUBLISHED topic=a"},
+		{name: "unsupported version", text: "PUBLISH v6 topic=a"},
+		{name: "unknown field", text: "PUBLISH topic=a color=red"},
+		{name: "properties before v5", text: "PUBLISH topic=a messageExpiry=1"},
+		{name: "user property before v5", text: "PUBLISH topic=a user:k=v"},
+		{name: "property not allowed", text: "PUBLISH v5 topic=a sessionExpiryInterval=1"},
+		{name: "will property before v5", text: "CONNECT v4 will.willDelayInterval=1"},
+		{name: "duplicate field", text: "PUBLISH topic=a topic=b"},
+		{name: "unterminated quote", text: `PUBLISH topic="a`},
+		{name: "bad quote", text: `PUBLISH topic="a"b`},
+		{name: "bad hex", text: "PUBLISH payload=hex:0"},
+		{name: "number out of range", text: "PUBACK id=65536"},
+		{name: "invalid qos", text: "PUBLISH qos=3"},
+		{name: "property out of range", text: "PUBLISH v5 topic=a payloadFormat=256"},
+		{name: "flag with value", text: "PUBLISH retain=1"},
+		{name: "missing value", text: "PUBLISH topic"},
+		{name: "subscription without options", text: "SUBSCRIBE id=1 sub=a"},
+		{name: "unknown subscription option", text: "SUBSCRIBE id=1 sub=a:q3"},
+		{name: "bad reasons", text: "SUBACK id=1 reasons=0,x"},
+		{name: "ack reason code before v5", text: "PUBACK v4 id=1 reasonCode=0x10"},
+		{name: "disconnect reason code before v5", text: "DISCONNECT v4 reasonCode=0x04"},
+		{name: "auth reason code before v5", text: "AUTH reasonCode=0x18"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseText(tt.text)
+			assert.True(t, errors.Is(err, ErrInvalidText), "%v", err)
+		})
+	}
+
+	// v3 packets don't carry the reason code, so it isn't written
+	text, err := (&ControlPacket{FixedHeader: FixedHeader{Type: PUBACK}, Content: &Puback{PacketID: 1, ReasonCode: 0x10}}).MarshalText()
+	require.NoError(t, err)
+	assert.Equal(t, "PUBACK id=1", string(text))
+
+	// the CONNACK reason code is the v3 return code
+	cp, err := ParseText("CONNACK v4 reasonCode=0x05")
+	require.NoError(t, err)
+	assert.Equal(t, byte(5), cp.Content.(*Connack).ReasonCode)
+
+	_, err = (&ControlPacket{}).MarshalText()
+	assert.True(t, errors.Is(err, ErrInvalidPacketType))
+}
+
+func TestTextRoundTripSpecialCharacters(t *testing.T) {
+	for _, s := range []string{`a"b`, `a#b`, `"`, `#`, `a" #b`, `k=v"`, `a"b:c`, `#"#`} {
+		connect := NewControlPacket(CONNECT, MQTTv5)
+		c := connect.Content.(*Connect)
+		c.ClientID = s
+		c.UsernameFlag = true
+		c.Username = s
+		c.PasswordFlag = true
+		c.Password = []byte(s)
+		c.WillFlag = true
+		c.WillTopic = s
+		c.WillMessage = []byte(s)
+		c.WillProperties = &Properties{ContentType: s, ResponseTopic: s, CorrelationData: []byte(s)}
+		c.Properties.AuthMethod = s
+		c.Properties.AuthData = []byte(s)
+		c.Properties.User = []User{{Key: s, Value: s}}
+
+		publish := NewControlPacket(PUBLISH, MQTTv5)
+		p := publish.Content.(*Publish)
+		p.Topic = s
+		p.Payload = []byte(s)
+		p.Properties.ContentType = s
+		p.Properties.ResponseTopic = s
+		p.Properties.User = []User{{Key: s, Value: "v"}, {Key: "k", Value: s}}
+
+		connack := NewControlPacket(CONNACK, MQTTv5)
+		connack.Content.(*Connack).Properties.AssignedClientID = s
+		connack.Content.(*Connack).Properties.ResponseInfo = s
+		connack.Content.(*Connack).Properties.ServerReference = s
+		connack.Content.(*Connack).Properties.ReasonString = s
+
+		subscribe := NewControlPacket(SUBSCRIBE, MQTTv5)
+		subscribe.Content.(*Subscribe).PacketID = 1
+		subscribe.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: s, QoS: 1}}
+
+		unsubscribe := NewControlPacket(UNSUBSCRIBE, MQTTv311)
+		unsubscribe.Content.(*Unsubscribe).PacketID = 1
+		unsubscribe.Content.(*Unsubscribe).Topics = []string{s, s + "/x"}
+
+		for _, cp := range []*ControlPacket{connect, publish, connack, subscribe, unsubscribe} {
+			text, err := cp.MarshalText()
+			require.NoError(t, err)
+
+			parsed, err := ParseText(string(text))
+			require.NoError(t, err, string(text))
+			assert.Equal(t, cp.Content, parsed.Content, string(text))
+
+			want, err := cp.AppendTo(nil)
+			require.NoError(t, err)
+			got, err := parsed.AppendTo(nil)
+			require.NoError(t, err)
+			assert.Equal(t, want, got, string(text))
+		}
+	}
+}
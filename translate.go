@@ -0,0 +1,365 @@
+package mqttpackets
+
+import (
+	"fmt"
+	"sync"
+)
+
+// sessionExpiryNever is the session expiry interval of a session that does
+// not expire, the v5 equivalent of a v3 session without clean session
+const sessionExpiryNever = 0xFFFFFFFF
+
+// Loss describes information that was dropped or changed when a packet was
+// translated to another protocol version
+type Loss struct {
+	// Field is the name of the field that was lost, e.g. Properties.ReasonString
+	Field string
+	// Message describes what happened to the value
+	Message string
+}
+
+func (l Loss) String() string {
+	return l.Field + ": " + l.Message
+}
+
+// Translator converts the packets of a connection between MQTT 3.1.1 and
+// MQTT 5. It remembers the topic aliases and the UNSUBSCRIBE packets it has
+// translated, so a single Translator should be used for both directions of
+// a connection. It is safe for concurrent use, so the goroutines handling
+// the two directions can share it. The zero value is ready to use.
+type Translator struct {
+	mu sync.Mutex
+	// aliases maps the topic aliases set by the v5 peer to their topics
+	aliases map[uint16]string
+	// unsubscribes holds the number of topic filters of the downgraded
+	// UNSUBSCRIBE packets, by packet identifier
+	unsubscribes map[uint16]int
+}
+
+// Translate converts cp from protocol version from to version to with a new
+// Translator, topic aliases can only be resolved if cp sets them. Use
+// Translator.Translate to find out which information was lost.
+func Translate(cp *ControlPacket, from, to Version) (*ControlPacket, error) {
+	out, _, err := new(Translator).Translate(cp, from, to)
+	return out, err
+}
+
+// Translate returns a copy of cp converted from protocol version from to
+// version to together with the information that could not be carried over.
+// Properties are dropped when downgrading to v3 and created when upgrading,
+// reason codes are mapped to the closest return codes and topic aliases are
+// replaced by their topics. A CONNECT with a zero length client ID gets a
+// clean session in v3.1.1 and can't be translated to v3.1. The copy shares
+// payloads and other byte slices with cp.
+func (t *Translator) Translate(cp *ControlPacket, from, to Version) (*ControlPacket, []Loss, error) {
+	for _, v := range []Version{from, to} {
+		if v != MQTTv31 && v != MQTTv311 && v != MQTTv5 {
+			return nil, nil, &TranslateError{PacketType: cp.Type, Field: "Version", Err: ErrUnsupportedVersion}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tr := &translation{Translator: t, from: from, to: to, t: cp.Type}
+	out := &ControlPacket{FixedHeader: FixedHeader{Type: cp.Type, Flags: cp.Flags}}
+	switch c := cp.Content.(type) {
+	case *Connect:
+		out.Content = tr.connect(c)
+	case *Connack:
+		out.Content = tr.connack(c)
+	case *Publish:
+		out.Content = tr.publish(c)
+	case *Puback:
+		p := *c
+		p.ReasonCode = tr.reasonCode(c.ReasonCode)
+		p.Properties = tr.properties("Properties", c.Properties)
+		out.Content = &p
+	case *Pubrec:
+		p := *c
+		p.ReasonCode = tr.reasonCode(c.ReasonCode)
+		p.Properties = tr.properties("Properties", c.Properties)
+		out.Content = &p
+	case *Pubrel:
+		p := *c
+		p.ReasonCode = tr.reasonCode(c.ReasonCode)
+		p.Properties = tr.properties("Properties", c.Properties)
+		out.Content = &p
+	case *Pubcomp:
+		p := *c
+		p.ReasonCode = tr.reasonCode(c.ReasonCode)
+		p.Properties = tr.properties("Properties", c.Properties)
+		out.Content = &p
+	case *Subscribe:
+		out.Content = tr.subscribe(c)
+	case *Suback:
+		out.Content = tr.suback(c)
+	case *Unsubscribe:
+		out.Content = tr.unsubscribe(c)
+	case *Unsuback:
+		out.Content = tr.unsuback(c)
+	case *Pingreq:
+		out.Content = &Pingreq{}
+	case *Pingresp:
+		out.Content = &Pingresp{}
+	case *Disconnect:
+		d := *c
+		d.ReasonCode = tr.reasonCode(c.ReasonCode)
+		d.Properties = tr.properties("Properties", c.Properties)
+		out.Content = &d
+	case *Auth:
+		if to != MQTTv5 {
+			tr.fail("PacketType", ErrNoEquivalent)
+			break
+		}
+		a := *c
+		a.Properties = tr.properties("Properties", c.Properties)
+		out.Content = &a
+	default:
+		tr.fail("PacketType", ErrInvalidPacketType)
+	}
+
+	if tr.err != nil {
+		return nil, nil, tr.err
+	}
+	return out, tr.losses, nil
+}
+
+// translation holds the state of translating a single packet
+type translation struct {
+	*Translator
+	losses   []Loss
+	err      error
+	from, to Version
+	t        PacketType
+}
+
+func (tr *translation) downgrade() bool {
+	return tr.from == MQTTv5 && tr.to != MQTTv5
+}
+
+func (tr *translation) upgrade() bool {
+	return tr.from != MQTTv5 && tr.to == MQTTv5
+}
+
+func (tr *translation) lose(field, format string, a ...interface{}) {
+	tr.losses = append(tr.losses, Loss{Field: field, Message: fmt.Sprintf(format, a...)})
+}
+
+func (tr *translation) fail(field string, err error) {
+	if tr.err == nil {
+		tr.err = &TranslateError{PacketType: tr.t, Field: field, Err: err}
+	}
+}
+
+// properties returns a copy of props for v5 and nil for v3, every property
+// that is dropped is reported as lost
+func (tr *translation) properties(field string, props *Properties) *Properties {
+	if tr.to != MQTTv5 {
+		if props != nil {
+			for _, id := range propertyOrder {
+				if props.encodedLen(id) > 0 {
+					tr.lose(field+"."+propertyNames[id], "not supported before MQTT 5")
+				}
+			}
+		}
+		return nil
+	}
+
+	if props == nil {
+		return &Properties{}
+	}
+	p := *props
+	return &p
+}
+
+// reasonCode returns the reason code of an acknowledgement or DISCONNECT,
+// v3 packets have none
+func (tr *translation) reasonCode(code byte) byte {
+	if tr.to != MQTTv5 && code != 0 {
+		tr.lose("ReasonCode", "%s is not supported before MQTT 5", ReasonCode(code))
+		return 0
+	}
+	return code
+}
+
+func (tr *translation) connect(c *Connect) *Connect {
+	out := *c
+	out.ProtocolVersion = tr.to
+	out.ProtocolName = "MQTT"
+	if tr.to == MQTTv31 {
+		out.ProtocolName = "MQIsdp"
+	}
+
+	props := c.Properties
+	if tr.downgrade() {
+		// a v3 session either ends with the connection or never expires
+		var expiry uint32
+		if props != nil && props.SessionExpiryInterval != nil {
+			expiry = *props.SessionExpiryInterval
+			p := *props
+			p.SessionExpiryInterval = nil
+			props = &p
+		}
+		if (c.CleanStart && expiry != 0) || (!c.CleanStart && expiry != sessionExpiryNever) {
+			tr.lose("Properties.SessionExpiryInterval",
+				"session expiry interval %d can't be represented with clean session %t", expiry, c.CleanStart)
+		}
+		if c.PasswordFlag && !c.UsernameFlag {
+			out.PasswordFlag = false
+			out.Password = nil
+			tr.lose("Password", "a password without a user name is not supported before MQTT 5")
+		}
+	}
+	// v3.1.1 servers only assign client IDs to clean sessions and v3.1
+	// servers don't assign them at all
+	switch {
+	case tr.to == MQTTv311 && c.ClientID == "" && !c.CleanStart:
+		out.CleanStart = true
+		tr.lose("CleanStart", "a zero length client ID requires clean session in MQTT 3.1.1")
+	case tr.to == MQTTv31 && c.ClientID == "":
+		tr.fail("ClientID", ErrNoEquivalent)
+	}
+
+	out.Properties = tr.properties("Properties", props)
+	if tr.upgrade() && !c.CleanStart {
+		expiry := uint32(sessionExpiryNever)
+		out.Properties.SessionExpiryInterval = &expiry
+	}
+	if c.WillFlag || tr.to != MQTTv5 {
+		out.WillProperties = tr.properties("WillProperties", c.WillProperties)
+	}
+
+	return &out
+}
+
+func (tr *translation) connack(c *Connack) *Connack {
+	out := *c
+	switch {
+	case tr.downgrade():
+		rc := ReasonCode(c.ReasonCode)
+		out.ReasonCode = rc.ConnackReturnCode()
+		if ConnackReasonCode(out.ReasonCode) != rc {
+			tr.lose("ReasonCode", "%s became return code %d", rc, out.ReasonCode)
+		}
+	case tr.upgrade():
+		out.ReasonCode = byte(ConnackReasonCode(c.ReasonCode))
+	}
+	out.Properties = tr.properties("Properties", c.Properties)
+
+	return &out
+}
+
+func (tr *translation) publish(p *Publish) *Publish {
+	out := *p
+	props := p.Properties
+	if tr.downgrade() && props != nil && props.TopicAlias != nil {
+		alias := *props.TopicAlias
+		if p.Topic == "" {
+			topic, ok := tr.aliases[alias]
+			if !ok {
+				tr.fail("Properties.TopicAlias", ErrUnknownTopicAlias)
+				return nil
+			}
+			out.Topic = topic
+		} else {
+			if tr.aliases == nil {
+				tr.aliases = make(map[uint16]string)
+			}
+			tr.aliases[alias] = p.Topic
+		}
+
+		resolved := *props
+		resolved.TopicAlias = nil
+		props = &resolved
+	}
+	out.Properties = tr.properties("Properties", props)
+
+	return &out
+}
+
+func (tr *translation) subscribe(s *Subscribe) *Subscribe {
+	out := *s
+	if tr.to != MQTTv5 {
+		out.Subscriptions = make([]Subscription, len(s.Subscriptions))
+		for i, sub := range s.Subscriptions {
+			field := fmt.Sprintf("Subscriptions[%d]", i)
+			if sub.NoLocal {
+				tr.lose(field+".NoLocal", "not supported before MQTT 5")
+			}
+			if sub.RetainAsPublished {
+				tr.lose(field+".RetainAsPublished", "not supported before MQTT 5")
+			}
+			if sub.RetainHandling != 0 {
+				tr.lose(field+".RetainHandling", "not supported before MQTT 5")
+			}
+			out.Subscriptions[i] = Subscription{Topic: sub.Topic, QoS: sub.QoS}
+		}
+	}
+	out.Properties = tr.properties("Properties", s.Properties)
+
+	return &out
+}
+
+func (tr *translation) suback(s *Suback) *Suback {
+	out := *s
+	switch {
+	case tr.downgrade():
+		out.Reasons = make([]byte, len(s.Reasons))
+		for i, code := range s.Reasons {
+			rc := ReasonCode(code)
+			out.Reasons[i] = rc.SubackReturnCode()
+			if SubackReasonCode(out.Reasons[i]) != rc {
+				tr.lose(fmt.Sprintf("Reasons[%d]", i), "%s became the failure return code", rc)
+			}
+		}
+	case tr.upgrade():
+		out.Reasons = make([]byte, len(s.Reasons))
+		for i, code := range s.Reasons {
+			out.Reasons[i] = byte(SubackReasonCode(code))
+		}
+	}
+	out.Properties = tr.properties("Properties", s.Properties)
+
+	return &out
+}
+
+func (tr *translation) unsubscribe(u *Unsubscribe) *Unsubscribe {
+	out := *u
+	if tr.downgrade() {
+		if tr.unsubscribes == nil {
+			tr.unsubscribes = make(map[uint16]int)
+		}
+		tr.unsubscribes[u.PacketID] = len(u.Topics)
+	}
+	out.Properties = tr.properties("Properties", u.Properties)
+
+	return &out
+}
+
+func (tr *translation) unsuback(u *Unsuback) *Unsuback {
+	out := *u
+	switch {
+	case tr.downgrade():
+		out.Reasons = nil
+		for i, code := range u.Reasons {
+			if code != UnsubackSuccess {
+				tr.lose(fmt.Sprintf("Reasons[%d]", i), "%s is not supported before MQTT 5", ReasonCode(code))
+			}
+		}
+	case tr.upgrade():
+		// v3 has no reason codes, report success for every topic filter of
+		// the UNSUBSCRIBE being acknowledged
+		n, ok := tr.unsubscribes[u.PacketID]
+		if !ok {
+			out.Reasons = nil
+			tr.lose("Reasons", "the number of topic filters is unknown, no reason codes were created")
+			break
+		}
+		delete(tr.unsubscribes, u.PacketID)
+		out.Reasons = make([]byte, n)
+	}
+	out.Properties = tr.properties("Properties", u.Properties)
+
+	return &out
+}
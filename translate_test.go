@@ -0,0 +1,326 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lossFields(losses []Loss) []string {
+	var fields []string
+	for _, l := range losses {
+		fields = append(fields, l.Field)
+	}
+	return fields
+}
+
+// roundTrip encodes cp and decodes it again as version v
+func roundTrip(t *testing.T, cp *ControlPacket, v Version) *ControlPacket {
+	var b bytes.Buffer
+	_, err := cp.WriteTo(&b)
+	require.NoError(t, err)
+
+	decoded, err := ReadPacket(&b, v)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestTranslateConnect(t *testing.T) {
+	cp := NewControlPacket(CONNECT, MQTTv5)
+	c := cp.Content.(*Connect)
+	c.ClientID = "c"
+	c.KeepAlive = 30
+	c.PasswordFlag = true
+	c.Password = []byte("secret")
+	c.WillFlag = true
+	c.WillTopic = "will"
+	c.WillMessage = []byte("bye")
+	delay := uint32(5)
+	c.WillProperties = &Properties{WillDelayInterval: &delay}
+	expiry := uint32(3600)
+	c.Properties.SessionExpiryInterval = &expiry
+	c.Properties.User = []User{{Key: "k", Value: "v"}}
+
+	var tr Translator
+	out, losses, err := tr.Translate(cp, MQTTv5, MQTTv311)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Properties.SessionExpiryInterval",
+		"Password",
+		"Properties.User",
+		"WillProperties.WillDelayInterval",
+	}, lossFields(losses))
+
+	oc := out.Content.(*Connect)
+	assert.Equal(t, "MQTT", oc.ProtocolName)
+	assert.Equal(t, MQTTv311, oc.ProtocolVersion)
+	assert.Nil(t, oc.Properties)
+	assert.Nil(t, oc.WillProperties)
+	assert.False(t, oc.PasswordFlag)
+	assert.False(t, oc.CleanStart)
+	assert.Equal(t, oc, roundTrip(t, out, 0).Content)
+
+	// the original is left untouched
+	assert.Equal(t, &expiry, c.Properties.SessionExpiryInterval)
+	assert.True(t, c.PasswordFlag)
+
+	cp = NewControlPacket(CONNECT, MQTTv311)
+	cp.Content.(*Connect).ClientID = "c"
+	out, losses, err = tr.Translate(cp, MQTTv311, MQTTv31)
+	require.NoError(t, err)
+	assert.Empty(t, losses)
+	assert.Equal(t, "MQIsdp", out.Content.(*Connect).ProtocolName)
+}
+
+func TestTranslateConnectSession(t *testing.T) {
+	never := uint32(sessionExpiryNever)
+	hour := uint32(3600)
+
+	tests := []struct {
+		name       string
+		cleanStart bool
+		expiry     *uint32
+		lost       bool
+	}{
+		{name: "clean", cleanStart: true},
+		{name: "clean with expiry", cleanStart: true, expiry: &hour, lost: true},
+		{name: "resume without expiry", lost: true},
+		{name: "resume with expiry", expiry: &hour, lost: true},
+		{name: "resume never expiring", expiry: &never},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := NewControlPacket(CONNECT, MQTTv5)
+			c := cp.Content.(*Connect)
+			c.ClientID = "c"
+			c.CleanStart = tt.cleanStart
+			c.Properties.SessionExpiryInterval = tt.expiry
+
+			out, losses, err := new(Translator).Translate(cp, MQTTv5, MQTTv311)
+			require.NoError(t, err)
+			assert.Equal(t, tt.cleanStart, out.Content.(*Connect).CleanStart)
+			assert.Equal(t, tt.lost, len(losses) == 1)
+
+			back, losses, err := new(Translator).Translate(out, MQTTv311, MQTTv5)
+			require.NoError(t, err)
+			assert.Empty(t, losses)
+			if tt.cleanStart {
+				assert.Nil(t, back.Content.(*Connect).Properties.SessionExpiryInterval)
+			} else {
+				assert.Equal(t, &never, back.Content.(*Connect).Properties.SessionExpiryInterval)
+			}
+		})
+	}
+}
+
+func TestTranslateConnectEmptyClientID(t *testing.T) {
+	for _, cleanStart := range []bool{false, true} {
+		cp := NewControlPacket(CONNECT, MQTTv5)
+		cp.Content.(*Connect).CleanStart = cleanStart
+		never := uint32(sessionExpiryNever)
+		cp.Content.(*Connect).Properties.SessionExpiryInterval = &never
+
+		out, losses, err := new(Translator).Translate(cp, MQTTv5, MQTTv311)
+		require.NoError(t, err)
+		assert.Nil(t, out.Validate(MQTTv311), "clean start %t", cleanStart)
+		assert.True(t, out.Content.(*Connect).CleanStart)
+		if cleanStart {
+			assert.Equal(t, []string{"Properties.SessionExpiryInterval"}, lossFields(losses))
+		} else {
+			assert.Equal(t, []string{"CleanStart"}, lossFields(losses))
+		}
+
+		// v3.1 has no zero length client IDs
+		for _, from := range []Version{MQTTv5, MQTTv311} {
+			in := cp
+			if from == MQTTv311 {
+				in = out
+			}
+			_, _, err = new(Translator).Translate(in, from, MQTTv31)
+			var e *TranslateError
+			require.True(t, errors.As(err, &e), "%v", err)
+			assert.Equal(t, "ClientID", e.Field)
+			assert.True(t, errors.Is(err, ErrNoEquivalent))
+		}
+	}
+
+	cp := NewControlPacket(CONNECT, MQTTv5)
+	cp.Content.(*Connect).ClientID = "c"
+	for _, to := range []Version{MQTTv31, MQTTv311} {
+		out, _, err := new(Translator).Translate(cp, MQTTv5, to)
+		require.NoError(t, err)
+		assert.Nil(t, out.Validate(to), "v%d", to)
+	}
+}
+
+func TestTranslateConnack(t *testing.T) {
+	cp := NewControlPacket(CONNACK, MQTTv5)
+	cp.Content.(*Connack).ReasonCode = byte(ReasonBanned)
+	cp.Content.(*Connack).Properties.ReasonString = "go away"
+
+	var tr Translator
+	out, losses, err := tr.Translate(cp, MQTTv5, MQTTv311)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ReasonCode", "Properties.ReasonString"}, lossFields(losses))
+	assert.Equal(t, &Connack{ReasonCode: 5}, out.Content)
+	assert.Equal(t, out.Content, roundTrip(t, out, MQTTv311).Content)
+
+	out, losses, err = tr.Translate(out, MQTTv311, MQTTv5)
+	require.NoError(t, err)
+	assert.Empty(t, losses)
+	assert.Equal(t, &Connack{ReasonCode: byte(ReasonNotAuthorized), Properties: &Properties{}}, out.Content)
+}
+
+func TestTranslateTopicAlias(t *testing.T) {
+	alias := uint16(3)
+	publish := func(topic string) *ControlPacket {
+		cp := NewControlPacket(PUBLISH, MQTTv5)
+		p := cp.Content.(*Publish)
+		p.Topic = topic
+		p.Payload = []byte("x")
+		p.Properties.TopicAlias = &alias
+		return cp
+	}
+
+	var tr Translator
+	out, losses, err := tr.Translate(publish("a/b"), MQTTv5, MQTTv311)
+	require.NoError(t, err)
+	assert.Empty(t, losses)
+	assert.Equal(t, &Publish{Topic: "a/b", Payload: []byte("x")}, out.Content)
+
+	out, losses, err = tr.Translate(publish(""), MQTTv5, MQTTv311)
+	require.NoError(t, err)
+	assert.Empty(t, losses)
+	assert.Equal(t, &Publish{Topic: "a/b", Payload: []byte("x")}, out.Content)
+
+	_, err = Translate(publish(""), MQTTv5, MQTTv311)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnknownTopicAlias))
+	var trErr *TranslateError
+	require.True(t, errors.As(err, &trErr))
+	assert.Equal(t, "Properties.TopicAlias", trErr.Field)
+	assert.Equal(t, PUBLISH, trErr.PacketType)
+}
+
+func TestTranslateSubscriptions(t *testing.T) {
+	cp := NewControlPacket(SUBSCRIBE, MQTTv5)
+	cp.Content.(*Subscribe).PacketID = 1
+	cp.Content.(*Subscribe).Subscriptions = []Subscription{
+		{Topic: "a", QoS: 1, NoLocal: true},
+		{Topic: "b", QoS: 2, RetainHandling: 2},
+	}
+
+	var tr Translator
+	out, losses, err := tr.Translate(cp, MQTTv5, MQTTv311)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Subscriptions[0].NoLocal", "Subscriptions[1].RetainHandling"}, lossFields(losses))
+	assert.Equal(t, []Subscription{{Topic: "a", QoS: 1}, {Topic: "b", QoS: 2}}, out.Content.(*Subscribe).Subscriptions)
+	assert.True(t, cp.Content.(*Subscribe).Subscriptions[0].NoLocal)
+
+	suback := NewControlPacket(SUBACK, MQTTv5)
+	suback.Content.(*Suback).Reasons = []byte{SubackGrantedQoS1, SubackUnspecifiederror, SubackNotauthorized}
+	out, losses, err = tr.Translate(suback, MQTTv5, MQTTv311)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Reasons[2]"}, lossFields(losses))
+	assert.Equal(t, []byte{1, 0x80, 0x80}, out.Content.(*Suback).Reasons)
+
+	out, _, err = tr.Translate(out, MQTTv311, MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, SubackUnspecifiederror, SubackUnspecifiederror}, out.Content.(*Suback).Reasons)
+}
+
+func TestTranslateUnsuback(t *testing.T) {
+	cp := NewControlPacket(UNSUBSCRIBE, MQTTv5)
+	cp.Content.(*Unsubscribe).PacketID = 9
+	cp.Content.(*Unsubscribe).Topics = []string{"a", "b"}
+
+	var tr Translator
+	_, _, err := tr.Translate(cp, MQTTv5, MQTTv311)
+	require.NoError(t, err)
+
+	unsuback := NewControlPacket(UNSUBACK, MQTTv311)
+	unsuback.Content.(*Unsuback).PacketID = 9
+	out, losses, err := tr.Translate(unsuback, MQTTv311, MQTTv5)
+	require.NoError(t, err)
+	assert.Empty(t, losses)
+	assert.Equal(t, []byte{UnsubackSuccess, UnsubackSuccess}, out.Content.(*Unsuback).Reasons)
+	assert.Equal(t, out.Content, roundTrip(t, out, MQTTv5).Content)
+
+	_, losses, err = tr.Translate(unsuback, MQTTv311, MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Reasons"}, lossFields(losses))
+}
+
+func TestTranslatorConcurrentDirections(t *testing.T) {
+	var tr Translator
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// client to broker: v5 PUBLISH packets setting topic aliases and
+	// UNSUBSCRIBE packets being downgraded
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			alias := uint16(i%10 + 1)
+			cp := NewControlPacket(PUBLISH, MQTTv5)
+			cp.Content.(*Publish).Topic = "a"
+			cp.Content.(*Publish).Properties.TopicAlias = &alias
+			_, _, err := tr.Translate(cp, MQTTv5, MQTTv311)
+			assert.NoError(t, err)
+
+			cp = NewControlPacket(UNSUBSCRIBE, MQTTv5)
+			cp.Content.(*Unsubscribe).PacketID = uint16(i + 1)
+			cp.Content.(*Unsubscribe).Topics = []string{"a"}
+			_, _, err = tr.Translate(cp, MQTTv5, MQTTv311)
+			assert.NoError(t, err)
+		}
+	}()
+
+	// broker to client: UNSUBACK packets being upgraded
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			cp := NewControlPacket(UNSUBACK, MQTTv311)
+			cp.Content.(*Unsuback).PacketID = uint16(i + 1)
+			_, _, err := tr.Translate(cp, MQTTv311, MQTTv5)
+			assert.NoError(t, err)
+		}
+	}()
+
+	wg.Wait()
+}
+
+func TestTranslateAcks(t *testing.T) {
+	cp := NewControlPacket(PUBREC, MQTTv5)
+	cp.Content.(*Pubrec).PacketID = 4
+	cp.Content.(*Pubrec).ReasonCode = PubrecQuotaExceeded
+
+	out, losses, err := new(Translator).Translate(cp, MQTTv5, MQTTv311)
+	require.NoError(t, err)
+	assert.Equal(t, []Loss{{Field: "ReasonCode", Message: "Quota exceeded is not supported before MQTT 5"}}, losses)
+	assert.Equal(t, &Pubrec{PacketID: 4}, out.Content)
+
+	out, err = Translate(NewControlPacket(DISCONNECT, MQTTv311), MQTTv311, MQTTv5)
+	require.NoError(t, err)
+	assert.Equal(t, &Disconnect{Properties: &Properties{}}, out.Content)
+
+	out, err = Translate(NewControlPacket(PINGREQ, MQTTv5), MQTTv5, MQTTv311)
+	require.NoError(t, err)
+	assert.Equal(t, &Pingreq{}, out.Content)
+}
+
+func TestTranslateErrors(t *testing.T) {
+	_, err := Translate(NewControlPacket(AUTH, MQTTv5), MQTTv5, MQTTv311)
+	assert.True(t, errors.Is(err, ErrNoEquivalent))
+
+	_, err = Translate(NewControlPacket(PINGREQ, MQTTv5), MQTTv5, 6)
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+
+	_, err = Translate(&ControlPacket{FixedHeader: FixedHeader{Type: 0}}, MQTTv5, MQTTv311)
+	assert.True(t, errors.Is(err, ErrInvalidPacketType))
+}
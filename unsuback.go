@@ -8,9 +8,9 @@ import (
 
 // Unsuback is the Variable Header definition for a Unsuback control packet
 type Unsuback struct {
-	Reasons    []byte
-	Properties *Properties
-	PacketID   uint16
+	Reasons    []byte      `json:"reasons,omitempty"`
+	Properties *Properties `json:"properties,omitempty"`
+	PacketID   uint16      `json:"packetID,omitempty"`
 }
 
 // UnsubackSuccess, etc are the list of valid unsuback reason codes.
@@ -29,7 +29,7 @@ func (u *Unsuback) Unpack(r *bytes.Buffer) error {
 	var err error
 	u.PacketID, err = readUint16(r)
 	if err != nil {
-		return err
+		return malformed(UNSUBACK, "PacketID", r.Len(), err)
 	}
 
 	if u.Properties != nil {
@@ -57,6 +57,16 @@ func (u *Unsuback) Buffers() net.Buffers {
 	return net.Buffers{b.Bytes(), propLen, idvp, u.Reasons}
 }
 
+func (u *Unsuback) size() int {
+	return 2 + u.Properties.prefixedLen(UNSUBACK) + len(u.Reasons)
+}
+
+func (u *Unsuback) appendTo(dst []byte) []byte {
+	dst = appendUint16(dst, u.PacketID)
+	dst = u.Properties.appendPrefixed(dst, UNSUBACK)
+	return append(dst, u.Reasons...)
+}
+
 // WriteTo is the implementation of the interface required function for a packet
 func (u *Unsuback) WriteTo(w io.Writer) (int64, error) {
 	cp := &ControlPacket{FixedHeader: FixedHeader{Type: UNSUBACK}}
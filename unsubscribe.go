@@ -2,15 +2,19 @@ package mqttpackets
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net"
 )
 
 // Unsubscribe is the Variable Header definition for a Unsubscribe control packet
 type Unsubscribe struct {
-	Topics     []string
-	Properties *Properties
-	PacketID   uint16
+	Topics     []string    `json:"topics,omitempty"`
+	Properties *Properties `json:"properties,omitempty"`
+	PacketID   uint16      `json:"packetID,omitempty"`
+
+	// limits counts the topics while unpacking
+	limits *decodeLimits
 }
 
 // Unpack is the implementation of the interface required function for a packet
@@ -18,7 +22,7 @@ func (u *Unsubscribe) Unpack(r *bytes.Buffer) error {
 	var err error
 	u.PacketID, err = readUint16(r)
 	if err != nil {
-		return err
+		return malformed(UNSUBSCRIBE, "PacketID", r.Len(), err)
 	}
 
 	if u.Properties != nil {
@@ -31,11 +35,14 @@ func (u *Unsubscribe) Unpack(r *bytes.Buffer) error {
 	for {
 		t, err := readString(r)
 		if err != nil && err != io.EOF {
-			return err
+			return malformed(UNSUBSCRIBE, fmt.Sprintf("Topics[%d]", len(u.Topics)), r.Len(), err)
 		}
 		if err == io.EOF {
 			break
 		}
+		if err = u.limits.subscription(len(u.Topics) + 1); err != nil {
+			return err
+		}
 		u.Topics = append(u.Topics, t)
 	}
 
@@ -59,6 +66,23 @@ func (u *Unsubscribe) Buffers() net.Buffers {
 	return net.Buffers{b.Bytes(), propLen, idvp, topics.Bytes()}
 }
 
+func (u *Unsubscribe) size() int {
+	n := 2 + u.Properties.prefixedLen(UNSUBSCRIBE)
+	for _, t := range u.Topics {
+		n += 2 + len(t)
+	}
+	return n
+}
+
+func (u *Unsubscribe) appendTo(dst []byte) []byte {
+	dst = appendUint16(dst, u.PacketID)
+	dst = u.Properties.appendPrefixed(dst, UNSUBSCRIBE)
+	for _, t := range u.Topics {
+		dst = appendString(dst, t)
+	}
+	return dst
+}
+
 // WriteTo is the implementation of the interface required function for a packet
 func (u *Unsubscribe) WriteTo(w io.Writer) (int64, error) {
 	cp := &ControlPacket{FixedHeader: FixedHeader{Type: UNSUBSCRIBE, Flags: 2}}
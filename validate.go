@@ -0,0 +1,643 @@
+package mqttpackets
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// validator walks a packet in wire order and collects all findings
+type validator struct {
+	findings []Finding
+	version  Version
+	// pos is the offset of the next field in the frame, cur the offset of
+	// the field being checked
+	pos, cur int
+}
+
+// Validate checks the packet against the normative statements of the MQTT
+// specification for version v and returns the first violation found as
+// a *MalformedPacketError or *ProtocolError with the Rule field set to the
+// spec statement ID. Warnings reported by Lint are ignored. For CONNECT
+// packets v can be 0, in which case the ProtocolVersion of the packet is used.
+func (c *ControlPacket) Validate(v Version) error {
+	for _, f := range validatePacket(c, v) {
+		if f.Severity == SeverityError {
+			return f.error(c.Type)
+		}
+	}
+
+	return nil
+}
+
+// validatePacket returns all findings for the packet.
+func validatePacket(c *ControlPacket, v Version) []Finding {
+	if connect, ok := c.Content.(*Connect); ok && v == 0 {
+		v = connect.ProtocolVersion
+	}
+	if v != MQTTv31 && v != MQTTv5 {
+		v = MQTTv311
+	}
+
+	var remaining int
+	if c.Content != nil {
+		remaining = c.bodyLen()
+	}
+
+	vd := &validator{version: v}
+	vd.fixedHeader(c, remaining)
+	vd.pos = 1 + vbiLen(remaining)
+
+	switch p := c.Content.(type) {
+	case *Connect:
+		vd.connect(p)
+	case *Connack:
+		vd.connack(p)
+	case *Publish:
+		vd.publish(p)
+	case *Puback:
+		vd.ack(PUBACK, p.PacketID, p.ReasonCode, p.Properties)
+	case *Pubrec:
+		vd.ack(PUBREC, p.PacketID, p.ReasonCode, p.Properties)
+	case *Pubrel:
+		vd.ack(PUBREL, p.PacketID, p.ReasonCode, p.Properties)
+	case *Pubcomp:
+		vd.ack(PUBCOMP, p.PacketID, p.ReasonCode, p.Properties)
+	case *Subscribe:
+		vd.subscribe(p)
+	case *Suback:
+		vd.suback(p)
+	case *Unsubscribe:
+		vd.unsubscribe(p)
+	case *Unsuback:
+		vd.unsuback(p)
+	case *Disconnect:
+		if p.Properties != nil {
+			vd.next(1)
+		}
+		vd.reasonCode(DISCONNECT, p.ReasonCode)
+		vd.properties(DISCONNECT, "Properties", p.Properties)
+	case *Auth:
+		vd.next(1)
+		vd.reasonCode(AUTH, p.ReasonCode)
+		vd.properties(AUTH, "Properties", p.Properties)
+		if vd.version == MQTTv5 && p.ReasonCode != AuthSuccess && (p.Properties == nil || p.Properties.AuthMethod == "") {
+			vd.protocol("§3.15.2.2.2", "Properties.AuthMethod", "AUTH packet must include an Authentication Method")
+		}
+	}
+
+	return vd.findings
+}
+
+// next moves to the next field of the frame which is n bytes long
+func (vd *validator) next(n int) {
+	vd.cur = vd.pos
+	vd.pos += n
+}
+
+// rule picks the statement ID matching the validated version
+func (vd *validator) rule(v3, v5 string) string {
+	if vd.version == MQTTv5 {
+		return v5
+	}
+	return v3
+}
+
+func (vd *validator) malformed(rule, field, format string, args ...interface{}) {
+	vd.findings = append(vd.findings, Finding{
+		Severity:   SeverityError,
+		Rule:       rule,
+		Field:      field,
+		Offset:     vd.cur,
+		Message:    fmt.Sprintf(format, args...),
+		ReasonCode: reasonMalformedPacket,
+		malformed:  true,
+	})
+}
+
+func (vd *validator) protocol(rule, field, format string, args ...interface{}) {
+	vd.protocolCode(reasonProtocolError, rule, field, format, args...)
+}
+
+func (vd *validator) protocolCode(code byte, rule, field, format string, args ...interface{}) {
+	vd.findings = append(vd.findings, Finding{
+		Severity:   SeverityError,
+		Rule:       rule,
+		Field:      field,
+		Offset:     vd.cur,
+		Message:    fmt.Sprintf(format, args...),
+		ReasonCode: code,
+	})
+}
+
+func (vd *validator) warning(rule, field, format string, args ...interface{}) {
+	vd.findings = append(vd.findings, Finding{
+		Severity: SeverityWarning,
+		Rule:     rule,
+		Field:    field,
+		Offset:   vd.cur,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// fixedHeader checks the packet type, the reserved flags and the remaining
+// length of the fixed header
+func (vd *validator) fixedHeader(c *ControlPacket, remaining int) {
+	if c.Type == AUTH && vd.version != MQTTv5 {
+		vd.malformed("§2.2.1", "PacketType", "AUTH packets are not allowed before MQTT 5")
+	}
+
+	var want byte
+	switch c.Type {
+	case PUBLISH:
+		want = c.Flags
+	case PUBREL, SUBSCRIBE, UNSUBSCRIBE:
+		want = 2
+	}
+	if c.Flags != want {
+		vd.malformed(vd.rule("MQTT-2.2.2-1", "MQTT-2.1.3-1"), "Flags",
+			"reserved flags of %s must be %d, got %d", packetTypeName(c.Type), want, c.Flags)
+	}
+
+	if remaining > maxRemainingLength {
+		vd.cur = 1
+		vd.malformed(vd.rule("§2.2.3", "§2.1.4"), "RemainingLength",
+			"remaining length %d exceeds the maximum of %d", remaining, maxRemainingLength)
+	}
+}
+
+func (vd *validator) connect(c *Connect) {
+	vd.next(2 + len(c.ProtocolName))
+	switch c.ProtocolVersion {
+	case MQTTv31:
+		if c.ProtocolName != "MQIsdp" {
+			vd.protocol("MQTT-3.1.2-1", "ProtocolName", "protocol name must be MQIsdp, got %q", c.ProtocolName)
+		}
+	case MQTTv311, MQTTv5:
+		if c.ProtocolName != "MQTT" {
+			vd.protocol("MQTT-3.1.2-1", "ProtocolName", "protocol name must be MQTT, got %q", c.ProtocolName)
+		}
+	}
+	vd.string("ProtocolName", c.ProtocolName)
+
+	vd.next(1)
+	if c.ProtocolVersion < MQTTv31 || c.ProtocolVersion > MQTTv5 {
+		vd.protocolCode(reasonUnsupportedProtocolVersion, "MQTT-3.1.2-2", "ProtocolVersion",
+			"unsupported protocol version %d", c.ProtocolVersion)
+	} else if c.ProtocolVersion != vd.version {
+		vd.protocolCode(reasonUnsupportedProtocolVersion, "MQTT-3.1.2-2", "ProtocolVersion",
+			"protocol version %d doesn't match connection version %d", c.ProtocolVersion, vd.version)
+	}
+
+	vd.next(1)
+	if c.reserved {
+		vd.malformed("MQTT-3.1.2-3", "ConnectFlags", "reserved connect flag must be 0")
+	}
+	if !c.WillFlag && c.WillQOS != 0 {
+		vd.malformed(vd.rule("MQTT-3.1.2-13", "MQTT-3.1.2-11"), "WillQOS", "will QoS must be 0 when the will flag is not set")
+	}
+	if c.WillQOS > 2 {
+		vd.malformed(vd.rule("MQTT-3.1.2-14", "MQTT-3.1.2-12"), "WillQOS", "will QoS must not be 3")
+	}
+	if !c.WillFlag && c.WillRetain {
+		vd.malformed(vd.rule("MQTT-3.1.2-15", "MQTT-3.1.2-13"), "WillRetain", "will retain must be 0 when the will flag is not set")
+	}
+	if vd.version != MQTTv5 && c.PasswordFlag && !c.UsernameFlag {
+		vd.malformed("MQTT-3.1.2-22", "PasswordFlag", "password flag requires the user name flag")
+	}
+
+	vd.next(2)
+	if c.ProtocolVersion == MQTTv5 {
+		vd.properties(CONNECT, "Properties", c.Properties)
+	} else if c.Properties != nil {
+		vd.cur = vd.pos
+		vd.warning("§2.2.2", "Properties", "properties are not encoded before MQTT 5")
+	}
+
+	vd.next(2 + len(c.ClientID))
+	vd.string("ClientID", c.ClientID)
+	switch {
+	case vd.version == MQTTv311 && c.ClientID == "" && !c.CleanStart:
+		vd.protocol("MQTT-3.1.3-7", "ClientID", "zero length client ID requires clean session")
+	case vd.version == MQTTv31 && c.ClientID == "":
+		vd.protocol("§3.1", "ClientID", "client ID must be between 1 and 23 characters long")
+	case vd.version == MQTTv31 && len(c.ClientID) > 23:
+		vd.warning("§3.1", "ClientID", "client ID is longer than 23 characters")
+	case vd.version != MQTTv31 && !portableClientID(c.ClientID):
+		vd.warning("MQTT-3.1.3-5", "ClientID",
+			"servers are only required to accept client IDs of 1 to 23 alphanumeric characters")
+	}
+
+	if c.WillFlag {
+		if c.ProtocolVersion == MQTTv5 {
+			vd.properties(will, "WillProperties", c.WillProperties)
+		}
+		vd.next(2 + len(c.WillTopic))
+		vd.topicName("WillTopic", c.WillTopic, false)
+		vd.next(2 + len(c.WillMessage))
+	}
+	if c.UsernameFlag {
+		vd.next(2 + len(c.Username))
+		vd.string("Username", c.Username)
+		if vd.version == MQTTv31 && len(c.Username) > 12 {
+			vd.warning("§3.1", "Username", "user names should be kept to 12 characters or fewer")
+		}
+	}
+	if c.PasswordFlag {
+		vd.next(2 + len(c.Password))
+		if vd.version == MQTTv31 && len(c.Password) > 12 {
+			vd.warning("§3.1", "Password", "passwords should be kept to 12 characters or fewer")
+		}
+	}
+}
+
+// portableClientID reports whether the client ID is one every server must
+// accept, empty client IDs are checked separately
+func portableClientID(id string) bool {
+	if len(id) > 23 {
+		return false
+	}
+	for _, r := range id {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func (vd *validator) connack(c *Connack) {
+	vd.next(1)
+	sessionPresent := vd.cur
+	vd.next(1)
+	if vd.version == MQTTv5 {
+		vd.reasonCode(CONNACK, c.ReasonCode)
+	} else if c.ReasonCode > 5 {
+		vd.protocol("§3.2.2.3", "ReasonCode", "reserved return code %d", c.ReasonCode)
+	}
+	if c.ReasonCode != 0 && c.SessionPresent {
+		vd.cur = sessionPresent
+		vd.protocol(vd.rule("MQTT-3.2.2-4", "MQTT-3.2.2-6"), "SessionPresent", "session present must be 0 when the connection is refused")
+	}
+
+	vd.properties(CONNACK, "Properties", c.Properties)
+}
+
+func (vd *validator) publish(p *Publish) {
+	vd.cur = 0
+	if p.QoS > 2 {
+		vd.malformed("MQTT-3.3.1-4", "QoS", "QoS must not be 3")
+	}
+	if p.QoS == 0 && p.Duplicate {
+		vd.malformed("MQTT-3.3.1-2", "Duplicate", "DUP flag must be 0 for QoS 0 messages")
+	}
+
+	vd.next(2 + len(p.Topic))
+	aliased := p.Properties != nil && p.Properties.TopicAlias != nil
+	vd.topicName("Topic", p.Topic, aliased)
+	if p.QoS > 0 {
+		vd.next(2)
+		vd.packetID(p.PacketID)
+	} else if p.PacketID != 0 {
+		vd.warning(vd.rule("MQTT-2.3.1-5", "MQTT-2.2.1-2"), "PacketID",
+			"packet identifier %d is not encoded for QoS 0 messages", p.PacketID)
+	}
+
+	vd.properties(PUBLISH, "Properties", p.Properties)
+
+	vd.next(len(p.Payload))
+	if p.Properties != nil && p.Properties.PayloadFormat != nil && *p.Properties.PayloadFormat == 1 && !utf8.Valid(p.Payload) {
+		vd.warning("§3.3.2.3.2", "Payload", "payload is marked as UTF-8 but is not valid UTF-8")
+	}
+}
+
+// ack validates PUBACK, PUBREC, PUBREL and PUBCOMP packets
+func (vd *validator) ack(t PacketType, id uint16, code byte, props *Properties) {
+	vd.next(2)
+	vd.packetID(id)
+	if props != nil {
+		vd.next(1)
+	}
+	vd.reasonCode(t, code)
+	vd.properties(t, "Properties", props)
+}
+
+func (vd *validator) subscribe(s *Subscribe) {
+	vd.next(2)
+	vd.packetID(s.PacketID)
+	vd.properties(SUBSCRIBE, "Properties", s.Properties)
+
+	if len(s.Subscriptions) == 0 {
+		vd.cur = vd.pos
+		vd.protocol(vd.rule("MQTT-3.8.3-3", "MQTT-3.8.3-2"), "Subscriptions", "SUBSCRIBE must contain at least one subscription")
+	}
+	for i, sub := range s.Subscriptions {
+		field := fmt.Sprintf("Subscriptions[%d]", i)
+		vd.next(2 + len(sub.Topic))
+		vd.topicFilter(field+".Topic", sub.Topic)
+
+		vd.next(1)
+		if sub.QoS > 2 {
+			if vd.version == MQTTv5 {
+				vd.protocol("§3.8.3.1", field+".QoS", "maximum QoS must not be 3")
+			} else {
+				vd.malformed("MQTT-3.8.3-4", field+".QoS", "maximum QoS must not be 3")
+			}
+		}
+
+		if vd.version != MQTTv5 {
+			if sub.NoLocal || sub.RetainAsPublished || sub.RetainHandling != 0 {
+				vd.malformed("MQTT-3.8.3-4", field, "reserved subscription option bits must be 0")
+			}
+			continue
+		}
+
+		if sub.RetainHandling > 2 {
+			vd.protocol("§3.8.3.1", field+".RetainHandling", "retain handling must not be 3")
+		}
+		if sub.NoLocal && strings.HasPrefix(sub.Topic, "$share/") {
+			vd.protocol("MQTT-3.8.3-4", field+".NoLocal", "no local must not be set on a shared subscription")
+		}
+	}
+}
+
+func (vd *validator) suback(s *Suback) {
+	vd.next(2)
+	vd.packetID(s.PacketID)
+	vd.properties(SUBACK, "Properties", s.Properties)
+
+	for i, r := range s.Reasons {
+		vd.next(1)
+		if vd.version != MQTTv5 {
+			if r > 2 && r != 0x80 {
+				vd.protocol("MQTT-3.9.3-2", fmt.Sprintf("Reasons[%d]", i), "reserved return code %d", r)
+			}
+			continue
+		}
+		if !validReasonCode(SUBACK, r) {
+			vd.protocol(reasonCodeRules[SUBACK], fmt.Sprintf("Reasons[%d]", i), "invalid reason code %d for SUBACK", r)
+		}
+	}
+}
+
+func (vd *validator) unsubscribe(u *Unsubscribe) {
+	vd.next(2)
+	vd.packetID(u.PacketID)
+	vd.properties(UNSUBSCRIBE, "Properties", u.Properties)
+
+	if len(u.Topics) == 0 {
+		vd.cur = vd.pos
+		vd.protocol("MQTT-3.10.3-2", "Topics", "UNSUBSCRIBE must contain at least one topic filter")
+	}
+	for i, t := range u.Topics {
+		vd.next(2 + len(t))
+		vd.topicFilter(fmt.Sprintf("Topics[%d]", i), t)
+	}
+}
+
+func (vd *validator) unsuback(u *Unsuback) {
+	vd.next(2)
+	vd.packetID(u.PacketID)
+	vd.properties(UNSUBACK, "Properties", u.Properties)
+
+	if vd.version != MQTTv5 {
+		if len(u.Reasons) > 0 {
+			vd.cur = vd.pos
+			vd.malformed("§3.11.3", "Reasons", "UNSUBACK has no payload before MQTT 5")
+		}
+		return
+	}
+	for i, r := range u.Reasons {
+		vd.next(1)
+		if !validReasonCode(UNSUBACK, r) {
+			vd.protocol(reasonCodeRules[UNSUBACK], fmt.Sprintf("Reasons[%d]", i), "invalid reason code %d for UNSUBACK", r)
+		}
+	}
+}
+
+// packetID checks that a packet identifier is set where it is required
+func (vd *validator) packetID(id uint16) {
+	if id == 0 {
+		vd.protocol(vd.rule("MQTT-2.3.1-1", "MQTT-2.2.1-3"), "PacketID", "packet identifier must not be 0")
+	}
+}
+
+// reasonCode checks a reason code of a v5 packet, before v5 only a success
+// can be encoded
+func (vd *validator) reasonCode(t PacketType, code byte) {
+	if vd.version != MQTTv5 {
+		if code != 0 {
+			vd.protocol("§2.2", "ReasonCode", "reason codes are not supported before MQTT 5")
+		}
+		return
+	}
+
+	if !validReasonCode(t, code) {
+		vd.protocol(reasonCodeRules[t], "ReasonCode", "invalid reason code %d for %s", code, packetTypeName(t))
+	}
+}
+
+// string checks that s is a well formed UTF-8 string without null characters
+// and warns about characters receivers may reject
+func (vd *validator) string(field, s string) {
+	if len(s) > maxFieldLen {
+		vd.malformed(vd.rule("§1.5.3", "§1.5.4"), field, "string is longer than %d bytes", maxFieldLen)
+	}
+	if !utf8.ValidString(s) {
+		vd.malformed(vd.rule("MQTT-1.5.3-1", "MQTT-1.5.4-1"), field, "string is not valid UTF-8")
+		return
+	}
+	if strings.ContainsRune(s, 0) {
+		vd.malformed(vd.rule("MQTT-1.5.3-2", "MQTT-1.5.4-2"), field, "string must not contain null characters")
+	}
+	for _, r := range s {
+		if r != 0 && (r <= 0x1F || r >= 0x7F && r <= 0x9F || r >= 0xFDD0 && r <= 0xFDEF || r&0xFFFE == 0xFFFE) {
+			vd.warning(vd.rule("§1.5.3", "§1.5.4"), field, "string contains control character or non-character %U", r)
+			return
+		}
+	}
+}
+
+// topicName checks a topic name of a PUBLISH or will message, an empty topic
+// is allowed in v5 when a topic alias is used
+func (vd *validator) topicName(field, topic string, aliased bool) {
+	vd.string(field, topic)
+
+	if topic == "" && !(aliased && vd.version == MQTTv5) {
+		vd.protocol("MQTT-4.7.3-1", field, "topic name must be at least one character long")
+	}
+	if strings.ContainsAny(topic, "+#") {
+		vd.protocol("MQTT-3.3.2-2", field, "topic name must not contain wildcards")
+	}
+	if strings.HasPrefix(topic, "$") {
+		vd.warning("§4.7.2", field, "topics beginning with $ are reserved for server use")
+	}
+	vd.leadingSlash(field, topic)
+}
+
+// topicFilter checks the wildcard placement in a topic filter
+func (vd *validator) topicFilter(field, filter string) {
+	vd.string(field, filter)
+
+	if filter == "" {
+		vd.protocol("MQTT-4.7.3-1", field, "topic filter must be at least one character long")
+		return
+	}
+
+	if vd.version == MQTTv5 && strings.HasPrefix(filter, "$share/") {
+		parts := strings.SplitN(filter, "/", 3)
+		if parts[1] == "" || strings.ContainsAny(parts[1], "+#") {
+			vd.protocol("MQTT-4.8.2-2", field, "invalid share name %q", parts[1])
+		}
+		if len(parts) < 3 || parts[2] == "" {
+			vd.protocol("MQTT-4.8.2-1", field, "shared subscription must have a topic filter")
+			return
+		}
+		filter = parts[2]
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		if strings.Contains(l, "#") && (l != "#" || i != len(levels)-1) {
+			vd.protocol(vd.rule("MQTT-4.7.1-2", "MQTT-4.7.1-1"), field, "multi-level wildcard must be the last level")
+		}
+		if strings.Contains(l, "+") && l != "+" {
+			vd.protocol(vd.rule("MQTT-4.7.1-3", "MQTT-4.7.1-2"), field, "single-level wildcard must occupy an entire level")
+		}
+	}
+	vd.leadingSlash(field, filter)
+}
+
+// leadingSlash warns about topics starting with an empty level, they don't
+// match the same topic without the slash
+func (vd *validator) leadingSlash(field, topic string) {
+	if strings.HasPrefix(topic, "/") {
+		vd.warning("§4.7.1.1", field, "topic contains leading slash")
+	}
+}
+
+// properties walks the properties of a packet in the order Pack writes them
+// and checks their values and duplicates
+func (vd *validator) properties(t PacketType, field string, p *Properties) {
+	if p == nil {
+		return
+	}
+	if vd.version != MQTTv5 {
+		vd.cur = vd.pos
+		vd.malformed("§2.2", field, "properties are not supported before MQTT 5")
+		return
+	}
+
+	vd.next(vbiLen(p.packedLen(t)))
+	for _, id := range p.duplicates {
+		vd.protocol("§2.2.2.2", field, "property %d included more than once", id)
+	}
+
+	for _, id := range propertyOrder {
+		n := p.encodedLen(id)
+		if n == 0 {
+			continue
+		}
+		name := field + "." + propertyNames[id]
+		if !ValidateID(t, id) {
+			// Pack leaves the property out, so it takes no bytes
+			vd.cur = vd.pos
+			vd.malformed("§2.2.2.2", name, "property is not valid for %s", packetTypeName(t))
+			continue
+		}
+
+		if id == PropUser {
+			for i, u := range p.User {
+				vd.next(5 + len(u.Key) + len(u.Value))
+				vd.string(fmt.Sprintf("%s.User[%d].Key", field, i), u.Key)
+				vd.string(fmt.Sprintf("%s.User[%d].Value", field, i), u.Value)
+			}
+			continue
+		}
+
+		vd.next(n)
+		vd.property(t, id, name, p)
+	}
+}
+
+// property checks the value of a single property
+func (vd *validator) property(t PacketType, id byte, name string, p *Properties) {
+	boolean := func(v *byte, rule string) {
+		if *v > 1 {
+			vd.protocol(rule, name, "value must be 0 or 1, got %d", *v)
+		}
+	}
+
+	switch id {
+	case PropPayloadFormat:
+		boolean(p.PayloadFormat, "§3.3.2.3.2")
+	case PropRequestProblemInfo:
+		boolean(p.RequestProblemInfo, "§3.1.2.11.7")
+	case PropRequestResponseInfo:
+		boolean(p.RequestResponseInfo, "§3.1.2.11.6")
+	case PropMaximumQOS:
+		boolean(p.MaximumQOS, "§3.2.2.3.4")
+	case PropRetainAvailable:
+		boolean(p.RetainAvailable, "§3.2.2.3.5")
+	case PropWildcardSubAvailable:
+		boolean(p.WildcardSubAvailable, "§3.2.2.3.11")
+	case PropSubIDAvailable:
+		boolean(p.SubIDAvailable, "§3.2.2.3.12")
+	case PropSharedSubAvailable:
+		boolean(p.SharedSubAvailable, "§3.2.2.3.13")
+	case PropReceiveMaximum:
+		if *p.ReceiveMaximum == 0 {
+			vd.protocol("§3.1.2.11.3", name, "receive maximum must not be 0")
+		}
+	case PropMaximumPacketSize:
+		if *p.MaximumPacketSize == 0 {
+			vd.protocol("§3.1.2.11.4", name, "maximum packet size must not be 0")
+		}
+	case PropTopicAlias:
+		if *p.TopicAlias == 0 {
+			vd.protocolCode(0x94, "MQTT-3.3.2-8", name, "topic alias must not be 0")
+		}
+	case PropSubscriptionIdentifier:
+		if t == SUBSCRIBE && *p.SubscriptionIdentifier == 0 {
+			vd.protocol("§3.8.2.1.2", name, "subscription identifier must not be 0")
+		}
+	case PropResponseTopic:
+		vd.string(name, p.ResponseTopic)
+		if strings.ContainsAny(p.ResponseTopic, "+#") {
+			vd.protocol("MQTT-3.3.2-14", name, "response topic must not contain wildcards")
+		}
+	case PropAuthData:
+		if p.AuthMethod == "" {
+			vd.protocol("§3.1.2.11.10", name, "authentication data requires an authentication method")
+		}
+	case PropContentType:
+		vd.string(name, p.ContentType)
+	case PropAssignedClientID:
+		vd.string(name, p.AssignedClientID)
+	case PropAuthMethod:
+		vd.string(name, p.AuthMethod)
+	case PropResponseInfo:
+		vd.string(name, p.ResponseInfo)
+	case PropServerReference:
+		vd.string(name, p.ServerReference)
+	case PropReasonString:
+		vd.string(name, p.ReasonString)
+	}
+}
+
+// reasonCodeRules holds the spec sections listing the reason codes of each
+// packet type
+var reasonCodeRules = map[PacketType]string{
+	CONNACK:    "§3.2.2.2",
+	PUBACK:     "§3.4.2.1",
+	PUBREC:     "§3.5.2.1",
+	PUBREL:     "§3.6.2.1",
+	PUBCOMP:    "§3.7.2.1",
+	SUBACK:     "§3.9.3",
+	UNSUBACK:   "§3.11.3",
+	DISCONNECT: "§3.14.2.1",
+	AUTH:       "§3.15.2.1",
+}
+
+func validReasonCode(t PacketType, code byte) bool {
+	return ReasonCode(code).ValidFor(t)
+}
@@ -0,0 +1,321 @@
+package mqttpackets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateValidPackets(t *testing.T) {
+	for _, v := range []Version{MQTTv311, MQTTv5} {
+		connect := NewControlPacket(CONNECT, v)
+		connect.Content.(*Connect).ClientID = "client"
+		connect.Content.(*Connect).CleanStart = true
+
+		publish := NewControlPacket(PUBLISH, v)
+		publish.Content.(*Publish).Topic = "a/b"
+		publish.Content.(*Publish).QoS = 1
+		publish.Content.(*Publish).PacketID = 1
+
+		subscribe := NewControlPacket(SUBSCRIBE, v)
+		subscribe.Content.(*Subscribe).PacketID = 1
+		subscribe.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "a/+/#", QoS: 2}}
+
+		unsubscribe := NewControlPacket(UNSUBSCRIBE, v)
+		unsubscribe.Content.(*Unsubscribe).PacketID = 1
+		unsubscribe.Content.(*Unsubscribe).Topics = []string{"a/#"}
+
+		puback := NewControlPacket(PUBACK, v)
+		puback.Content.(*Puback).PacketID = 1
+
+		for _, cp := range []*ControlPacket{connect, publish, subscribe, unsubscribe, puback,
+			NewControlPacket(PINGREQ, v), NewControlPacket(DISCONNECT, v)} {
+			assert.Nil(t, cp.Validate(v), "%s v%d", cp.PacketType(), v)
+		}
+	}
+	assert.Nil(t, NewControlPacket(AUTH, MQTTv5).Validate(MQTTv5))
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   Version
+		packet    func() *ControlPacket
+		rule      string
+		malformed bool
+	}{
+		{
+			name:    "pubrel flags",
+			version: MQTTv311,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBREL, MQTTv311)
+				cp.Flags = 0
+				cp.Content.(*Pubrel).PacketID = 1
+				return cp
+			},
+			rule:      "MQTT-2.2.2-1",
+			malformed: true,
+		},
+		{
+			name:    "subscribe flags v5",
+			version: MQTTv5,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBSCRIBE, MQTTv5)
+				cp.Flags = 0
+				cp.Content.(*Subscribe).PacketID = 1
+				cp.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "a"}}
+				return cp
+			},
+			rule:      "MQTT-2.1.3-1",
+			malformed: true,
+		},
+		{
+			name:    "publish qos 3",
+			version: MQTTv311,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv311)
+				cp.Content.(*Publish).Topic = "a"
+				cp.Content.(*Publish).QoS = 3
+				cp.Content.(*Publish).PacketID = 1
+				return cp
+			},
+			rule:      "MQTT-3.3.1-4",
+			malformed: true,
+		},
+		{
+			name:    "publish qos 0 dup",
+			version: MQTTv5,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv5)
+				cp.Content.(*Publish).Topic = "a"
+				cp.Content.(*Publish).Duplicate = true
+				return cp
+			},
+			rule:      "MQTT-3.3.1-2",
+			malformed: true,
+		},
+		{
+			name:    "publish wildcard topic",
+			version: MQTTv5,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv5)
+				cp.Content.(*Publish).Topic = "a/+"
+				return cp
+			},
+			rule: "MQTT-3.3.2-2",
+		},
+		{
+			name:    "publish without packet id",
+			version: MQTTv311,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv311)
+				cp.Content.(*Publish).Topic = "a"
+				cp.Content.(*Publish).QoS = 1
+				return cp
+			},
+			rule: "MQTT-2.3.1-1",
+		},
+		{
+			name:    "publish topic alias 0",
+			version: MQTTv5,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv5)
+				alias := uint16(0)
+				cp.Content.(*Publish).Properties.TopicAlias = &alias
+				return cp
+			},
+			rule: "MQTT-3.3.2-8",
+		},
+		{
+			name:    "subscribe without subscriptions",
+			version: MQTTv311,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBSCRIBE, MQTTv311)
+				cp.Content.(*Subscribe).PacketID = 1
+				return cp
+			},
+			rule: "MQTT-3.8.3-3",
+		},
+		{
+			name:    "subscribe invalid filter",
+			version: MQTTv5,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBSCRIBE, MQTTv5)
+				cp.Content.(*Subscribe).PacketID = 1
+				cp.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "a/#/b"}}
+				return cp
+			},
+			rule: "MQTT-4.7.1-1",
+		},
+		{
+			name:    "subscribe QoS 3 on v3",
+			version: MQTTv311,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBSCRIBE, MQTTv311)
+				cp.Content.(*Subscribe).PacketID = 1
+				cp.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "a", QoS: 3}}
+				return cp
+			},
+			rule:      "MQTT-3.8.3-4",
+			malformed: true,
+		},
+		{
+			name:    "subscribe v5 options on v3",
+			version: MQTTv311,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBSCRIBE, MQTTv311)
+				cp.Content.(*Subscribe).PacketID = 1
+				cp.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "a", RetainAsPublished: true}}
+				return cp
+			},
+			rule:      "MQTT-3.8.3-4",
+			malformed: true,
+		},
+		{
+			name:    "subscribe no local on shared subscription",
+			version: MQTTv5,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBSCRIBE, MQTTv5)
+				cp.Content.(*Subscribe).PacketID = 1
+				cp.Content.(*Subscribe).Subscriptions = []Subscription{{Topic: "$share/g/a", NoLocal: true}}
+				return cp
+			},
+			rule: "MQTT-3.8.3-4",
+		},
+		{
+			name:    "unsubscribe without topics",
+			version: MQTTv5,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(UNSUBSCRIBE, MQTTv5)
+				cp.Content.(*Unsubscribe).PacketID = 1
+				return cp
+			},
+			rule: "MQTT-3.10.3-2",
+		},
+		{
+			name:    "connect password without username",
+			version: MQTTv311,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(CONNECT, MQTTv311)
+				cp.Content.(*Connect).ClientID = "c"
+				cp.Content.(*Connect).PasswordFlag = true
+				return cp
+			},
+			rule:      "MQTT-3.1.2-22",
+			malformed: true,
+		},
+		{
+			name:    "connect will qos without will flag",
+			version: MQTTv5,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(CONNECT, MQTTv5)
+				cp.Content.(*Connect).WillQOS = 1
+				return cp
+			},
+			rule:      "MQTT-3.1.2-11",
+			malformed: true,
+		},
+		{
+			name:    "connect empty client id without clean session",
+			version: MQTTv311,
+			packet: func() *ControlPacket {
+				return NewControlPacket(CONNECT, MQTTv311)
+			},
+			rule: "MQTT-3.1.3-7",
+		},
+		{
+			name:    "suback v3 reason code",
+			version: MQTTv311,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(SUBACK, MQTTv311)
+				cp.Content.(*Suback).PacketID = 1
+				cp.Content.(*Suback).Reasons = []byte{0x87}
+				return cp
+			},
+			rule: "MQTT-3.9.3-2",
+		},
+		{
+			name:    "puback invalid reason code",
+			version: MQTTv5,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBACK, MQTTv5)
+				cp.Content.(*Puback).PacketID = 1
+				cp.Content.(*Puback).ReasonCode = 0x04
+				return cp
+			},
+			rule: "§3.4.2.1",
+		},
+		{
+			name:    "auth in v3",
+			version: MQTTv311,
+			packet: func() *ControlPacket {
+				return NewControlPacket(AUTH, MQTTv311)
+			},
+			rule:      "§2.2.1",
+			malformed: true,
+		},
+		{
+			name:    "invalid utf8",
+			version: MQTTv5,
+			packet: func() *ControlPacket {
+				cp := NewControlPacket(PUBLISH, MQTTv5)
+				cp.Content.(*Publish).Topic = "a\xff"
+				return cp
+			},
+			rule:      "MQTT-1.5.4-1",
+			malformed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.packet().Validate(tt.version)
+			require.NotNil(t, err)
+
+			if tt.malformed {
+				var e *MalformedPacketError
+				require.True(t, errors.As(err, &e), err.Error())
+				assert.Equal(t, tt.rule, e.Rule)
+				assert.Equal(t, byte(0x81), e.ReasonCode)
+				assert.GreaterOrEqual(t, e.Offset, 0)
+			} else {
+				var e *ProtocolError
+				require.True(t, errors.As(err, &e), err.Error())
+				assert.Equal(t, tt.rule, e.Rule)
+				assert.GreaterOrEqual(t, e.Offset, 0)
+			}
+		})
+	}
+}
+
+func TestValidateDecodedConnectReservedFlag(t *testing.T) {
+	p := []byte{16, 13, 0, 4, 77, 81, 84, 84, 4, 3, 0, 30, 0, 1, 'c'}
+
+	cp, err := ReadPacket(bytes.NewReader(p), 0)
+	require.Nil(t, err)
+
+	_, err = ReadPacketWithOptions(bytes.NewReader(p), 0, &ReaderOptions{Strict: true})
+	var e *MalformedPacketError
+	require.True(t, errors.As(err, &e))
+	assert.Equal(t, "MQTT-3.1.2-3", e.Rule)
+	// the offset of the connect flags
+	assert.Equal(t, 9, e.Offset)
+	assert.NotNil(t, cp.Validate(0))
+}
+
+func TestValidateDecodedDuplicateProperty(t *testing.T) {
+	// PUBLISH with the Message Expiry Interval property included twice
+	p := []byte{0x30, 14, 0, 1, 'a', 10, 2, 0, 0, 0, 1, 2, 0, 0, 0, 2}
+
+	cp, err := ReadPacket(bytes.NewReader(p), MQTTv5)
+	require.Nil(t, err)
+	assert.Equal(t, uint32(2), *cp.Content.(*Publish).Properties.MessageExpiry)
+
+	_, err = ReadPacketWithOptions(bytes.NewReader(p), MQTTv5, &ReaderOptions{Strict: true})
+	var e *ProtocolError
+	require.True(t, errors.As(err, &e))
+	assert.Equal(t, "§2.2.2.2", e.Rule)
+	assert.Equal(t, byte(0x82), e.ReasonCode)
+}